@@ -8,15 +8,20 @@ CREATE TABLE accounts (
     last_message_id character varying(32) DEFAULT ''::character varying,
     next_notification_check_at timestamp without time zone,
     next_stuck_notification_check_at timestamp without time zone,
-    check_count integer DEFAULT 0
+    check_count integer DEFAULT 0,
+    next_milestone_check_at timestamp without time zone
 );
 
+CREATE INDEX accounts_lower_username_idx ON accounts(LOWER(username) text_ops);
+
 CREATE TABLE devices (
     id SERIAL PRIMARY KEY,
     apns_token character varying(100) UNIQUE,
     sandbox boolean,
     expires_at timestamp without time zone,
-    grace_period_expires_at timestamp without time zone
+    grace_period_expires_at timestamp without time zone,
+    push_to_start_token character varying(200) DEFAULT ''::character varying,
+    secret text DEFAULT ''::text
 );
 
 CREATE TABLE devices_accounts (
@@ -25,7 +30,9 @@ CREATE TABLE devices_accounts (
     device_id integer REFERENCES devices(id) ON DELETE CASCADE,
     watcher_notifiable boolean DEFAULT true,
     inbox_notifiable boolean DEFAULT true,
-    global_mute boolean DEFAULT false
+    global_mute boolean DEFAULT false,
+    upvote_milestones integer[] DEFAULT '{}'::integer[],
+    comment_milestones integer[] DEFAULT '{}'::integer[]
 );
 
 CREATE UNIQUE INDEX devices_accounts_account_id_device_id_idx ON devices_accounts(account_id int4_ops,device_id int4_ops);
@@ -34,14 +41,21 @@ CREATE TABLE subreddits (
     id SERIAL PRIMARY KEY,
     subreddit_id character varying(32) DEFAULT ''::character varying UNIQUE,
     name character varying(32) DEFAULT ''::character varying,
-    next_check_at timestamp without time zone
+    next_check_at timestamp without time zone,
+    last_post_id character varying(32) DEFAULT ''::character varying,
+    last_post_at timestamp without time zone,
+    broken_reason integer DEFAULT 0,
+    next_health_check_at timestamp without time zone
 );
 
 CREATE TABLE users (
     id SERIAL PRIMARY KEY,
     user_id character varying(32) DEFAULT ''::character varying UNIQUE,
     name character varying(32) DEFAULT ''::character varying,
-    next_check_at timestamp without time zone
+    next_check_at timestamp without time zone,
+    last_comment_id character varying(32) DEFAULT ''::character varying,
+    last_comment_at timestamp without time zone,
+    next_health_check_at timestamp without time zone
 );
 
 CREATE TABLE watchers (
@@ -54,11 +68,92 @@ CREATE TABLE watchers (
     upvotes integer DEFAULT 0,
     keyword character varying(32) DEFAULT ''::character varying,
     flair character varying(32) DEFAULT ''::character varying,
-    domain character varying(32) DEFAULT ''::character varying,
+    domain character varying(128) DEFAULT ''::character varying,
     hits integer DEFAULT 0,
     type integer DEFAULT 0,
     label character varying(64) DEFAULT ''::character varying,
     author character varying(32) DEFAULT ''::character varying,
-    subreddit character varying(32) DEFAULT ''::character varying
+    subreddit character varying(32) DEFAULT ''::character varying,
+    max_notifications integer DEFAULT 0,
+    notification_window integer DEFAULT 0,
+    cooldown integer DEFAULT 0,
+    summarize_suppressed boolean DEFAULT false,
+    snoozed_until timestamp without time zone,
+    expires_at timestamp without time zone,
+    notify_on_expiry boolean DEFAULT false,
+    trending_sensitivity integer DEFAULT 0,
+    user_activity integer DEFAULT 0,
+    media_kind integer DEFAULT 0,
+    post_kind integer DEFAULT 0,
+    nsfw integer DEFAULT 0,
+    min_comments integer DEFAULT 0,
+    max_age integer DEFAULT 0,
+    broken_reason integer DEFAULT 0,
+    broken_at timestamp without time zone,
+    notify_on_broken boolean DEFAULT false
+);
+CREATE INDEX watchers_expires_at_idx ON watchers(expires_at timestamp_ops) WHERE expires_at IS NOT NULL;
+
+
+CREATE TABLE feeds (
+    id SERIAL PRIMARY KEY,
+    path character varying(96) DEFAULT ''::character varying UNIQUE,
+    next_check_at timestamp without time zone
+);
+
+CREATE TABLE watchers_subreddits (
+    id SERIAL PRIMARY KEY,
+    watcher_id integer REFERENCES watchers(id) ON DELETE CASCADE,
+    subreddit_id integer REFERENCES subreddits(id) ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX watchers_subreddits_watcher_id_subreddit_id_idx ON watchers_subreddits(watcher_id int4_ops,subreddit_id int4_ops);
+CREATE INDEX watchers_subreddits_subreddit_id_idx ON watchers_subreddits(subreddit_id int4_ops);
+
+CREATE TABLE watcher_hits (
+    id SERIAL PRIMARY KEY,
+    watcher_id integer REFERENCES watchers(id) ON DELETE CASCADE,
+    post_id character varying(32) DEFAULT ''::character varying,
+    title character varying(300) DEFAULT ''::character varying,
+    subreddit character varying(32) DEFAULT ''::character varying,
+    author character varying(32) DEFAULT ''::character varying,
+    matched_at timestamp without time zone,
+    status integer DEFAULT 0
+);
+
+CREATE INDEX watcher_hits_watcher_id_id_idx ON watcher_hits(watcher_id int4_ops,id int4_ops);
+CREATE INDEX watcher_hits_matched_at_idx ON watcher_hits(matched_at timestamp_ops);
+
+CREATE TABLE live_activities (
+    id SERIAL PRIMARY KEY,
+    apns_token character varying(200) UNIQUE,
+    reddit_account_id character varying(32) DEFAULT ''::character varying,
+    access_token text DEFAULT ''::text,
+    refresh_token text DEFAULT ''::text,
+    token_expires_at timestamp without time zone,
+    thread_id character varying(32) DEFAULT ''::character varying,
+    subreddit character varying(32) DEFAULT ''::character varying,
+    next_check_at timestamp without time zone,
+    expires_at timestamp without time zone,
+    development boolean DEFAULT false,
+    authors integer DEFAULT 0,
+    pending boolean DEFAULT false,
+    device_id integer REFERENCES devices(id) ON DELETE CASCADE
+);
+
+CREATE INDEX live_activities_expires_at_idx ON live_activities(expires_at timestamp_ops);
+CREATE INDEX live_activities_device_id_idx ON live_activities(device_id int4_ops);
+CREATE UNIQUE INDEX live_activities_pending_idx ON live_activities(device_id, thread_id) WHERE pending;
+
+CREATE TABLE live_activity_subscriptions (
+    id SERIAL PRIMARY KEY,
+    created_at timestamp without time zone,
+    device_id integer REFERENCES devices(id) ON DELETE CASCADE,
+    account_id integer REFERENCES accounts(id) ON DELETE CASCADE,
+    subreddit_id integer REFERENCES subreddits(id) ON DELETE CASCADE,
+    title_pattern character varying(100) DEFAULT ''::character varying,
+    authors integer DEFAULT 0
 );
 
+CREATE INDEX live_activity_subscriptions_subreddit_id_idx ON live_activity_subscriptions(subreddit_id int4_ops);
+CREATE INDEX live_activity_subscriptions_device_id_idx ON live_activity_subscriptions(device_id int4_ops);
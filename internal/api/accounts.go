@@ -26,7 +26,7 @@ func (a *api) notificationsAccountHandler(w http.ResponseWriter, r *http.Request
 
 	anr := &accountNotificationsRequest{}
 	if err := json.NewDecoder(r.Body).Decode(anr); err != nil {
-		a.errorResponse(w, r, 500, err)
+		a.errorResponse(w, r, 400, err)
 		return
 	}
 
@@ -86,6 +86,89 @@ func (a *api) getNotificationsAccountHandler(w http.ResponseWriter, r *http.Requ
 	_ = json.NewEncoder(w).Encode(an)
 }
 
+type accountMilestonesRequest struct {
+	Upvotes  []int64 `json:"upvotes"`
+	Comments []int64 `json:"comments"`
+}
+
+func (a *api) milestonesAccountHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	amr := &accountMilestonesRequest{}
+	if err := json.NewDecoder(r.Body).Decode(amr); err != nil {
+		a.errorResponse(w, r, 400, err)
+		return
+	}
+
+	vars := mux.Vars(r)
+	apns := vars["apns"]
+	rid := vars["redditID"]
+
+	dev, err := a.deviceRepo.GetByAPNSToken(ctx, apns)
+	if err != nil {
+		a.errorResponse(w, r, 500, err)
+		return
+	}
+
+	acct, err := a.accountRepo.GetByRedditID(ctx, rid)
+	if err != nil {
+		a.errorResponse(w, r, 500, err)
+		return
+	}
+
+	m := domain.Milestones{Upvotes: amr.Upvotes, Comments: amr.Comments}
+	if err := m.Validate(); err != nil {
+		a.errorResponse(w, r, 422, err)
+		return
+	}
+
+	if err := a.deviceRepo.SetMilestones(ctx, &dev, &acct, m); err != nil {
+		a.errorResponse(w, r, 500, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (a *api) getMilestonesAccountHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	vars := mux.Vars(r)
+	apns := vars["apns"]
+	rid := vars["redditID"]
+
+	dev, err := a.deviceRepo.GetByAPNSToken(ctx, apns)
+	if err != nil {
+		a.errorResponse(w, r, 500, err)
+		return
+	}
+
+	acct, err := a.accountRepo.GetByRedditID(ctx, rid)
+	if err != nil {
+		a.errorResponse(w, r, 500, err)
+		return
+	}
+
+	m, err := a.deviceRepo.GetMilestones(ctx, &dev, &acct)
+	if err != nil {
+		a.errorResponse(w, r, 500, err)
+		return
+	}
+
+	amr := &accountMilestonesRequest{Upvotes: m.Upvotes, Comments: m.Comments}
+	if amr.Upvotes == nil {
+		amr.Upvotes = []int64{}
+	}
+	if amr.Comments == nil {
+		amr.Comments = []int64{}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(amr)
+}
+
 func (a *api) disassociateAccountHandler(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithCancel(r.Context())
 	defer cancel()
@@ -140,7 +223,7 @@ func (a *api) upsertAccountsHandler(w http.ResponseWriter, r *http.Request) {
 
 	var raccs []domain.Account
 	if err := json.NewDecoder(r.Body).Decode(&raccs); err != nil {
-		a.errorResponse(w, r, 422, err)
+		a.errorResponse(w, r, 400, err)
 		return
 	}
 	for _, acc := range raccs {
@@ -216,7 +299,7 @@ func (a *api) upsertAccountHandler(w http.ResponseWriter, r *http.Request) {
 
 	if err := json.NewDecoder(r.Body).Decode(&acct); err != nil {
 		a.logger.Error("failed to parse request json", zap.Error(err))
-		a.errorResponse(w, r, 422, err)
+		a.errorResponse(w, r, 400, err)
 		return
 	}
 
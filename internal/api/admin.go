@@ -0,0 +1,242 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+
+	"github.com/christianselig/apollo-backend/internal/domain"
+)
+
+var (
+	errAdminUnauthorized       = errors.New("invalid admin credentials")
+	errNotificationCheckQueued = errors.New("a notification check is already queued or running")
+)
+
+// adminAuthMiddleware guards the admin API with its own token, which has
+// nothing to do with device secrets. Nobody gets in without ADMIN_API_TOKEN set.
+func (a *api) adminAuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		token := strings.TrimPrefix(auth, "Bearer ")
+
+		if a.adminToken == "" || token == auth || subtle.ConstantTimeCompare([]byte(token), []byte(a.adminToken)) != 1 {
+			_ = a.statsd.Incr("apollo.admin.auth_failed", nil, 1.0)
+			a.errorResponse(w, r, 401, errAdminUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+type adminDeviceItem struct {
+	ID                   int64     `json:"id"`
+	APNSToken            string    `json:"apns_token"`
+	Sandbox              bool      `json:"sandbox"`
+	ExpiresAt            time.Time `json:"expires_at"`
+	GracePeriodExpiresAt time.Time `json:"grace_period_expires_at"`
+
+	// Whether the device was handed a secret, and can start live activities
+	Authenticated bool `json:"authenticated"`
+	PushToStart   bool `json:"push_to_start"`
+
+	Notifications accountNotificationsRequest `json:"notifications"`
+}
+
+type adminAccountResponse struct {
+	ID                      int64     `json:"id"`
+	Username                string    `json:"username"`
+	RedditAccountID         string    `json:"reddit_account_id"`
+	Development             bool      `json:"development"`
+	LastMessageID           string    `json:"last_message_id"`
+	CheckCount              int64     `json:"check_count"`
+	TokenExpiresAt          time.Time `json:"token_expires_at"`
+	NextNotificationCheckAt time.Time `json:"next_notification_check_at"`
+
+	Devices []adminDeviceItem `json:"devices"`
+}
+
+// adminGetAccountHandler looks up an account by either its username or reddit
+// ID, and shows what support usually needs to know about it. Never its tokens.
+func (a *api) adminGetAccountHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	var (
+		acct domain.Account
+		err  error
+	)
+
+	q := r.URL.Query()
+	switch {
+	case q.Get("reddit_id") != "":
+		acct, err = a.accountRepo.GetByRedditID(ctx, q.Get("reddit_id"))
+	case q.Get("username") != "":
+		acct, err = a.accountRepo.GetByUsername(ctx, q.Get("username"))
+	default:
+		err = errors.New("either username or reddit_id is required")
+		a.errorResponse(w, r, 400, err)
+		return
+	}
+	if err != nil {
+		a.errorResponse(w, r, 500, err)
+		return
+	}
+
+	devs, err := a.deviceRepo.GetByAccountID(ctx, acct.ID)
+	if err != nil {
+		a.errorResponse(w, r, 500, err)
+		return
+	}
+
+	aar := adminAccountResponse{
+		ID:                      acct.ID,
+		Username:                acct.Username,
+		RedditAccountID:         acct.AccountID,
+		Development:             acct.Development,
+		LastMessageID:           acct.LastMessageID,
+		CheckCount:              acct.CheckCount,
+		TokenExpiresAt:          acct.TokenExpiresAt,
+		NextNotificationCheckAt: acct.NextNotificationCheckAt,
+		Devices:                 make([]adminDeviceItem, len(devs)),
+	}
+
+	for i, dev := range devs {
+		inbox, watchers, global, err := a.deviceRepo.GetNotifiable(ctx, &dev, &acct)
+		if err != nil {
+			a.errorResponse(w, r, 500, err)
+			return
+		}
+
+		aar.Devices[i] = adminDeviceItem{
+			ID:                   dev.ID,
+			APNSToken:            dev.APNSToken,
+			Sandbox:              dev.Sandbox,
+			ExpiresAt:            dev.ExpiresAt,
+			GracePeriodExpiresAt: dev.GracePeriodExpiresAt,
+			Authenticated:        dev.Secret != "",
+			PushToStart:          dev.PushToStartToken != "",
+			Notifications: accountNotificationsRequest{
+				InboxNotifications:   inbox,
+				WatcherNotifications: watchers,
+				GlobalMute:           global,
+			},
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(aar)
+}
+
+// adminCheckAccountHandler has the notifications worker check an account's
+// inbox right away, instead of waiting on the scheduler to get to it.
+func (a *api) adminCheckAccountHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	acct, err := a.accountRepo.GetByRedditID(ctx, mux.Vars(r)["redditID"])
+	if err != nil {
+		a.errorResponse(w, r, 500, err)
+		return
+	}
+
+	// Same lock the scheduler takes, so the account isn't checked twice at once
+	key := fmt.Sprintf("locks:accounts:%s", acct.AccountID)
+	ok, err := a.redis.SetNX(ctx, key, 1, domain.NotificationCheckTimeout).Result()
+	if err != nil {
+		a.errorResponse(w, r, 500, err)
+		return
+	}
+	if !ok {
+		a.errorResponse(w, r, 409, errNotificationCheckQueued)
+		return
+	}
+
+	if err := a.notificationsQueue.Publish(acct.AccountID); err != nil {
+		_ = a.redis.Del(ctx, key).Err()
+		a.errorResponse(w, r, 500, err)
+		return
+	}
+
+	_ = a.statsd.Incr("apollo.admin.notification_checks", nil, 1.0)
+	a.logger.Info("enqueued notification check from admin", zap.String("account#reddit_account_id", acct.AccountID))
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+type adminAccountWatchers struct {
+	Username        string        `json:"username"`
+	RedditAccountID string        `json:"reddit_account_id"`
+	Watchers        []watcherItem `json:"watchers"`
+}
+
+// adminListDeviceWatchersHandler lists the watchers of every account on a device.
+func (a *api) adminListDeviceWatchersHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	apns := mux.Vars(r)["apns"]
+
+	if _, err := a.deviceRepo.GetByAPNSToken(ctx, apns); err != nil {
+		a.errorResponse(w, r, 500, err)
+		return
+	}
+
+	accs, err := a.accountRepo.GetByAPNSToken(ctx, apns)
+	if err != nil {
+		a.errorResponse(w, r, 500, err)
+		return
+	}
+
+	now := time.Now()
+	aaws := make([]adminAccountWatchers, len(accs))
+	for i, acct := range accs {
+		watchers, err := a.watcherRepo.GetByDeviceAPNSTokenAndAccountRedditID(ctx, apns, acct.AccountID)
+		if err != nil {
+			a.errorResponse(w, r, 500, err)
+			return
+		}
+
+		aaws[i] = adminAccountWatchers{
+			Username:        acct.Username,
+			RedditAccountID: acct.AccountID,
+			Watchers:        make([]watcherItem, len(watchers)),
+		}
+		for j, watcher := range watchers {
+			aaws[i].Watchers[j] = newWatcherItem(watcher, now)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(aaws)
+}
+
+func (a *api) adminTestDeviceHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	dev, err := a.deviceRepo.GetByAPNSToken(ctx, mux.Vars(r)["apns"])
+	if err != nil {
+		a.errorResponse(w, r, 500, err)
+		return
+	}
+
+	if status, err := a.sendTestNotification(ctx, dev); err != nil {
+		a.errorResponse(w, r, status, err)
+		return
+	}
+
+	a.logger.Info("sent test notification from admin", zap.Int64("device#id", dev.ID))
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,114 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/adjust/rmq/v5"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/christianselig/apollo-backend/internal/domain"
+)
+
+type adminTestAccounts struct {
+	domain.AccountRepository
+}
+
+func (adminTestAccounts) GetByRedditID(_ context.Context, id string) (domain.Account, error) {
+	return domain.Account{ID: 1, AccountID: id, Username: "iamthatis"}, nil
+}
+
+type adminTestQueue struct {
+	rmq.Queue
+	published []string
+}
+
+func (q *adminTestQueue) Publish(payload ...string) error {
+	q.published = append(q.published, payload...)
+	return nil
+}
+
+func newAdminTestAPI(t *testing.T, token string) (*api, *adminTestQueue) {
+	t.Helper()
+
+	mr := miniredis.RunT(t)
+	queue := &adminTestQueue{}
+
+	return &api{
+		logger:             zap.NewNop(),
+		redis:              redis.NewClient(&redis.Options{Addr: mr.Addr()}),
+		adminToken:         token,
+		notificationsQueue: queue,
+		accountRepo:        adminTestAccounts{},
+	}, queue
+}
+
+func TestAdminAuthMiddleware(t *testing.T) {
+	t.Parallel()
+
+	tt := map[string]struct {
+		token  string
+		header string
+		want   int
+	}{
+		"valid":                     {"t0ken", "Bearer t0ken", http.StatusOK},
+		"missing bearer":            {"t0ken", "", http.StatusUnauthorized},
+		"wrong bearer":              {"t0ken", "Bearer nope", http.StatusUnauthorized},
+		"not a bearer":              {"t0ken", "t0ken", http.StatusUnauthorized},
+		"no admin token":            {"", "Bearer ", http.StatusUnauthorized},
+		"no admin token, no header": {"", "", http.StatusUnauthorized},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			a, _ := newAdminTestAPI(t, tc.token)
+			next := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})
+
+			r := httptest.NewRequest("GET", "/admin/v1/account?username=iamthatis", nil)
+			if tc.header != "" {
+				r.Header.Set("Authorization", tc.header)
+			}
+
+			rr := httptest.NewRecorder()
+			a.adminAuthMiddleware(next).ServeHTTP(rr, r)
+			assert.Equal(t, tc.want, rr.Code)
+		})
+	}
+}
+
+func TestAdminCheckAccountHandler(t *testing.T) {
+	t.Parallel()
+
+	a, queue := newAdminTestAPI(t, "t0ken")
+	router := a.Routes()
+
+	check := func() *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/admin/v1/accounts/1ia22/check", nil)
+		r.Header.Set("Authorization", "Bearer t0ken")
+
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, r)
+		return rr
+	}
+
+	require.Equal(t, http.StatusAccepted, check().Code)
+	assert.Equal(t, []string{"1ia22"}, queue.published)
+
+	// The lock is still held, so it isn't queued again
+	rr := check()
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	assert.Contains(t, rr.Body.String(), "check_in_progress")
+	assert.Equal(t, []string{"1ia22"}, queue.published)
+}
@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/DataDog/datadog-go/statsd"
+	"github.com/adjust/rmq/v5"
 	"github.com/bugsnag/bugsnag-go/v2"
 	"github.com/go-redis/redis/v8"
 	"github.com/gofrs/uuid"
@@ -21,24 +22,45 @@ import (
 	"github.com/christianselig/apollo-backend/internal/domain"
 	"github.com/christianselig/apollo-backend/internal/reddit"
 	"github.com/christianselig/apollo-backend/internal/repository"
+	"github.com/christianselig/apollo-backend/internal/tokencrypt"
 )
 
 type api struct {
 	logger     *zap.Logger
 	statsd     *statsd.Client
+	redis      *redis.Client
 	reddit     *reddit.Client
 	apns       *token.Token
 	httpClient *http.Client
 
+	// Encrypts the reddit tokens live activities are stored with
+	liveActivityTokens *tokencrypt.Sealer
+
+	// Encrypts device secrets, and whether devices without one get turned away
+	deviceSecrets      *tokencrypt.Sealer
+	deviceAuthRequired bool
+
+	// Request bodies are checked against this when set
+	spec *openAPIDocument
+
+	// Guards the admin API, which is off without it
+	adminToken string
+
+	notificationsQueue  rmq.Queue
+	liveActivitiesQueue rmq.Queue
+
 	accountRepo      domain.AccountRepository
 	deviceRepo       domain.DeviceRepository
 	subredditRepo    domain.SubredditRepository
+	feedRepo         domain.FeedRepository
 	watcherRepo      domain.WatcherRepository
 	userRepo         domain.UserRepository
 	liveActivityRepo domain.LiveActivityRepository
+
+	liveActivitySubscriptionRepo domain.LiveActivitySubscriptionRepository
 }
 
-func NewAPI(ctx context.Context, logger *zap.Logger, statsd *statsd.Client, redis *redis.Client, pool *pgxpool.Pool) *api {
+func NewAPI(ctx context.Context, logger *zap.Logger, statsd *statsd.Client, redis *redis.Client, pool *pgxpool.Pool, queue rmq.Connection) *api {
 	tracer := otel.Tracer("api")
 
 	reddit := reddit.NewClient(
@@ -64,28 +86,72 @@ func NewAPI(ctx context.Context, logger *zap.Logger, statsd *statsd.Client, redi
 		}
 	}
 
+	liveActivityTokens, err := tokencrypt.NewFromBase64(os.Getenv("LIVE_ACTIVITY_TOKEN_KEY"))
+	if err != nil {
+		panic(err)
+	}
+
+	deviceSecrets, err := tokencrypt.NewFromBase64(os.Getenv("DEVICE_SECRET_KEY"))
+	if err != nil {
+		panic(err)
+	}
+
+	var spec *openAPIDocument
+	if os.Getenv("OPENAPI_VALIDATE_REQUESTS") == "true" {
+		if spec, err = parseOpenAPI(openAPISpec); err != nil {
+			panic(err)
+		}
+	}
+
+	notificationsQueue, err := queue.OpenQueue("notifications")
+	if err != nil {
+		panic(err)
+	}
+
+	liveActivitiesQueue, err := queue.OpenQueue("live-activities")
+	if err != nil {
+		panic(err)
+	}
+
 	accountRepo := repository.NewPostgresAccount(pool)
 	deviceRepo := repository.NewPostgresDevice(pool)
 	subredditRepo := repository.NewPostgresSubreddit(pool)
+	feedRepo := repository.NewPostgresFeed(pool)
 	watcherRepo := repository.NewPostgresWatcher(pool)
 	userRepo := repository.NewPostgresUser(pool)
 	liveActivityRepo := repository.NewPostgresLiveActivity(pool)
+	liveActivitySubscriptionRepo := repository.NewPostgresLiveActivitySubscription(pool)
 
 	client := &http.Client{}
 
 	return &api{
 		logger:     logger,
 		statsd:     statsd,
+		redis:      redis,
 		reddit:     reddit,
 		apns:       apns,
 		httpClient: client,
 
+		liveActivityTokens: liveActivityTokens,
+
+		deviceSecrets:      deviceSecrets,
+		deviceAuthRequired: os.Getenv("DEVICE_AUTH_REQUIRED") == "true",
+
+		spec: spec,
+
+		adminToken:          os.Getenv("ADMIN_API_TOKEN"),
+		notificationsQueue:  notificationsQueue,
+		liveActivitiesQueue: liveActivitiesQueue,
+
 		accountRepo:      accountRepo,
 		deviceRepo:       deviceRepo,
 		subredditRepo:    subredditRepo,
+		feedRepo:         feedRepo,
 		watcherRepo:      watcherRepo,
 		userRepo:         userRepo,
 		liveActivityRepo: liveActivityRepo,
+
+		liveActivitySubscriptionRepo: liveActivitySubscriptionRepo,
 	}
 }
 
@@ -100,10 +166,13 @@ func (a *api) Routes() *mux.Router {
 	r := mux.NewRouter()
 
 	r.HandleFunc("/v1/health", a.healthCheckHandler).Methods("GET")
+	r.HandleFunc("/v1/openapi.yaml", a.openAPIHandler).Methods("GET")
 
 	r.HandleFunc("/v1/device", a.upsertDeviceHandler).Methods("POST")
 	r.HandleFunc("/v1/device/{apns}", a.deleteDeviceHandler).Methods("DELETE")
 	r.HandleFunc("/v1/device/{apns}/test", a.testDeviceHandler).Methods("POST")
+	r.HandleFunc("/v1/device/{apns}/migrate", a.migrateDeviceHandler).Methods("POST")
+	r.HandleFunc(deviceSecretRoute, a.deviceSecretHandler).Methods("POST")
 	r.HandleFunc("/v1/device/{apns}/test/comment_reply", generateNotificationTester(a, commentReply)).Methods("POST")
 	r.HandleFunc("/v1/device/{apns}/test/post_reply", generateNotificationTester(a, postReply)).Methods("POST")
 	r.HandleFunc("/v1/device/{apns}/test/private_message", generateNotificationTester(a, privateMessage)).Methods("POST")
@@ -116,11 +185,28 @@ func (a *api) Routes() *mux.Router {
 	r.HandleFunc("/v1/device/{apns}/account/{redditID}", a.disassociateAccountHandler).Methods("DELETE")
 	r.HandleFunc("/v1/device/{apns}/account/{redditID}/notifications", a.notificationsAccountHandler).Methods("PATCH")
 	r.HandleFunc("/v1/device/{apns}/account/{redditID}/notifications", a.getNotificationsAccountHandler).Methods("GET")
+	r.HandleFunc("/v1/device/{apns}/account/{redditID}/milestones", a.milestonesAccountHandler).Methods("PATCH")
+	r.HandleFunc("/v1/device/{apns}/account/{redditID}/milestones", a.getMilestonesAccountHandler).Methods("GET")
 
 	r.HandleFunc("/v1/device/{apns}/account/{redditID}/watcher", a.createWatcherHandler).Methods("POST")
+	r.HandleFunc("/v1/device/{apns}/account/{redditID}/watcher/preview", a.previewWatcherHandler).Methods("POST")
 	r.HandleFunc("/v1/device/{apns}/account/{redditID}/watcher/{watcherID}", a.deleteWatcherHandler).Methods("DELETE")
 	r.HandleFunc("/v1/device/{apns}/account/{redditID}/watcher/{watcherID}", a.editWatcherHandler).Methods("PATCH")
+	r.HandleFunc("/v1/device/{apns}/account/{redditID}/watcher/{watcherID}/hits", a.listWatcherHitsHandler).Methods("GET")
 	r.HandleFunc("/v1/device/{apns}/account/{redditID}/watchers", a.listWatchersHandler).Methods("GET")
+	r.HandleFunc("/v1/device/{apns}/account/{redditID}/watchers/export", a.exportWatchersHandler).Methods("GET")
+	r.HandleFunc("/v1/device/{apns}/account/{redditID}/watchers/import", a.importWatchersHandler).Methods("POST")
+
+	r.HandleFunc("/v1/device/{apns}/live_activities/token", a.pushToStartTokenHandler).Methods("POST")
+	r.HandleFunc("/v1/device/{apns}/account/{redditID}/live_activity_subscription", a.createLiveActivitySubscriptionHandler).Methods("POST")
+	r.HandleFunc("/v1/device/{apns}/account/{redditID}/live_activity_subscription/{subscriptionID}", a.deleteLiveActivitySubscriptionHandler).Methods("DELETE")
+	r.HandleFunc("/v1/device/{apns}/account/{redditID}/live_activity_subscriptions", a.listLiveActivitySubscriptionsHandler).Methods("GET")
+
+	r.HandleFunc("/v1/device/{apns}/live_activities", a.createDeviceLiveActivityHandler).Methods("POST")
+	r.HandleFunc("/v1/device/{apns}/live_activities/claim", a.claimLiveActivityHandler).Methods("POST")
+	r.HandleFunc("/v1/device/{apns}/live_activities/{liveActivity}", a.getLiveActivityHandler).Methods("GET")
+	r.HandleFunc("/v1/device/{apns}/live_activities/{liveActivity}", a.updateLiveActivityHandler).Methods("PATCH")
+	r.HandleFunc("/v1/device/{apns}/live_activities/{liveActivity}", a.deleteLiveActivityHandler).Methods("DELETE")
 
 	r.HandleFunc("/v1/live_activities", a.createLiveActivityHandler).Methods("POST")
 
@@ -131,8 +217,22 @@ func (a *api) Routes() *mux.Router {
 
 	r.HandleFunc("/v1/test/bugsnag", a.testBugsnagHandler).Methods("POST")
 
+	admin := r.PathPrefix("/admin/v1").Subrouter()
+	admin.HandleFunc("/account", a.adminGetAccountHandler).Methods("GET")
+	admin.HandleFunc("/accounts/{redditID}/check", a.adminCheckAccountHandler).Methods("POST")
+	admin.HandleFunc("/devices/{apns}/watchers", a.adminListDeviceWatchersHandler).Methods("GET")
+	admin.HandleFunc("/devices/{apns}/test", a.adminTestDeviceHandler).Methods("POST")
+	admin.Use(a.adminAuthMiddleware)
+
+	r.NotFoundHandler = http.HandlerFunc(a.notFoundHandler)
+	r.MethodNotAllowedHandler = http.HandlerFunc(a.methodNotAllowedHandler)
+
 	r.Use(a.loggingMiddleware)
 	r.Use(a.requestIdMiddleware)
+	r.Use(a.rateLimitMiddleware)
+	r.Use(a.deviceAuthMiddleware)
+	r.Use(a.deviceRateLimitMiddleware)
+	r.Use(a.requestValidationMiddleware)
 
 	return r
 }
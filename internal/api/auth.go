@@ -0,0 +1,134 @@
+package api
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/subtle"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+
+	"github.com/christianselig/apollo-backend/internal/domain"
+)
+
+// Bodies of signed requests are read up front to check them, so cap how much we'll read
+const maxSignedBodySize = 1 << 20
+
+var (
+	errMissingDeviceAuth  = errors.New("missing device authentication")
+	errInvalidDeviceAuth  = errors.New("invalid device authentication")
+	errInvalidDeviceNonce = errors.New("invalid or expired device nonce")
+)
+
+// Devices without a secret need this route to get one, so it stays open to
+// them even once DEVICE_AUTH_REQUIRED is set. It checks their nonce itself.
+const deviceSecretRoute = "/v1/device/{apns}/secret"
+
+// deviceAuthMiddleware makes sure requests to routes under /v1/device/{apns}
+// come from the device itself, by either having its secret as a bearer token or
+// being signed with it (see domain.DeviceSignature) in the X-Apollo-Signature
+// and X-Apollo-Timestamp headers.
+//
+// Devices without a secret, like the ones that registered before we handed them
+// out, get one by echoing back the nonce pushed to them when they register.
+// Until DEVICE_AUTH_REQUIRED is set, they're let through without one, and so
+// are devices we don't know, which handlers turn away themselves. Once it is,
+// both are turned away here.
+func (a *api) deviceAuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := mux.CurrentRoute(r)
+		if route == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		tpl, _ := route.GetPathTemplate()
+		if !strings.HasPrefix(tpl, "/v1/device/{apns}") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		dev, err := a.deviceRepo.GetByAPNSToken(r.Context(), mux.Vars(r)["apns"])
+		if err == domain.ErrNotFound {
+			_ = a.statsd.Incr("apollo.api.device_auth", []string{"result:unknown"}, 0.1)
+			if a.deviceAuthRequired {
+				a.errorResponse(w, r, 401, errMissingDeviceAuth)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+			return
+		} else if err != nil {
+			a.errorResponse(w, r, 500, err)
+			return
+		}
+
+		if dev.Secret == "" {
+			_ = a.statsd.Incr("apollo.api.device_auth", []string{"result:legacy"}, 0.1)
+			if a.deviceAuthRequired && tpl != deviceSecretRoute {
+				a.errorResponse(w, r, 401, errMissingDeviceAuth)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		secret, err := a.deviceSecrets.Open(dev.Secret)
+		if err != nil {
+			a.logger.Error("failed to decrypt device secret", zap.Error(err), zap.Int64("device#id", dev.ID))
+			a.errorResponse(w, r, 500, err)
+			return
+		}
+
+		if err := authenticateDevice(r, secret, time.Now()); err != nil {
+			_ = a.statsd.Incr("apollo.api.device_auth", []string{"result:failed"}, 1.0)
+			a.errorResponse(w, r, 401, err)
+			return
+		}
+
+		_ = a.statsd.Incr("apollo.api.device_auth", []string{"result:ok"}, 0.1)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func authenticateDevice(r *http.Request, secret string, now time.Time) error {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		if subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(secret)) != 1 {
+			return errInvalidDeviceAuth
+		}
+		return nil
+	}
+
+	signature := r.Header.Get("X-Apollo-Signature")
+	if signature == "" {
+		return errMissingDeviceAuth
+	}
+
+	timestamp, err := strconv.ParseInt(r.Header.Get("X-Apollo-Timestamp"), 10, 64)
+	if err != nil {
+		return errInvalidDeviceAuth
+	}
+
+	if skew := now.Sub(time.Unix(timestamp, 0)); skew > domain.DeviceSignatureMaxSkew || skew < -domain.DeviceSignatureMaxSkew {
+		return errInvalidDeviceAuth
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxSignedBodySize))
+	if err != nil {
+		return err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	expected := domain.DeviceSignature(secret, r.Method, r.URL.EscapedPath(), timestamp, body)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return errInvalidDeviceAuth
+	}
+	return nil
+}
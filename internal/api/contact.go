@@ -15,7 +15,7 @@ type sendMessageRequest struct {
 func (a *api) contactHandler(w http.ResponseWriter, r *http.Request) {
 	smr := &sendMessageRequest{}
 	if err := json.NewDecoder(r.Body).Decode(smr); err != nil {
-		a.errorResponse(w, r, 500, err)
+		a.errorResponse(w, r, 400, err)
 		return
 	}
 
@@ -2,13 +2,16 @@ package api
 
 import (
 	"context"
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
 	"time"
 
 	"github.com/dustin/go-humanize/english"
+	"github.com/go-redis/redis/v8"
 	"github.com/gorilla/mux"
 	"github.com/sideshow/apns2"
 	"github.com/sideshow/apns2/payload"
@@ -25,7 +28,7 @@ func (a *api) upsertDeviceHandler(w http.ResponseWriter, r *http.Request) {
 
 	d := &domain.Device{}
 	if err := json.NewDecoder(r.Body).Decode(d); err != nil {
-		a.errorResponse(w, r, 500, err)
+		a.errorResponse(w, r, 400, err)
 		return
 	}
 
@@ -37,7 +40,159 @@ func (a *api) upsertDeviceHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	dev, err := a.deviceRepo.GetByAPNSToken(ctx, d.APNSToken)
+	if err != nil {
+		a.errorResponse(w, r, 500, err)
+		return
+	}
+
+	udr := upsertDeviceResponse{}
+	if dev.Secret == "" {
+		if err := a.sendDeviceNonce(ctx, dev); err != nil {
+			a.logger.Error("failed to send device nonce", zap.Error(err), zap.Int64("device#id", dev.ID))
+		} else {
+			udr.NonceSent = true
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(udr)
+}
+
+// upsertDeviceResponse says whether we pushed a nonce to the device, which it
+// has to echo back to get its secret. Devices that have one already don't get
+// another.
+type upsertDeviceResponse struct {
+	NonceSent bool `json:"nonce_sent"`
+}
+
+func deviceNonceKey(dev domain.Device) string {
+	return fmt.Sprintf("devices:%d:nonce", dev.ID)
+}
+
+// sendDeviceNonce pushes a nonce to the device in a silent notification. Only
+// whoever actually holds the device's token gets to see it, so echoing it back
+// proves it's the device asking for a secret.
+func (a *api) sendDeviceNonce(ctx context.Context, dev domain.Device) error {
+	nonce, err := domain.NewDeviceSecret()
+	if err != nil {
+		return err
+	}
+
+	if err := a.redis.Set(ctx, deviceNonceKey(dev), nonce, domain.DeviceNonceTTL).Err(); err != nil {
+		return err
+	}
+
+	notification := &apns2.Notification{}
+	notification.Topic = "com.christianselig.Apollo"
+	notification.DeviceToken = dev.APNSToken
+	notification.PushType = apns2.PushTypeBackground
+	notification.Priority = apns2.PriorityLow
+	notification.Payload = payload.
+		NewPayload().
+		ContentAvailable().
+		Custom("device_nonce", nonce)
+
+	client := apns2.NewTokenClient(a.apns)
+	if !dev.Sandbox {
+		client = client.Production()
+	}
+
+	res, err := client.PushWithContext(ctx, notification)
+	if err != nil {
+		return err
+	} else if !res.Sent() {
+		return fmt.Errorf("error sending nonce: %d: %s", res.StatusCode, res.Reason)
+	}
+
+	_ = a.statsd.Incr("apollo.device.nonce_sent", nil, 1.0)
+	return nil
+}
+
+// checkDeviceNonce says whether nonce is the one we last pushed to the device.
+// Nonces only work once, right or wrong, so they can't be guessed at.
+func (a *api) checkDeviceNonce(ctx context.Context, dev domain.Device, nonce string) (bool, error) {
+	var get *redis.StringCmd
+	_, err := a.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		get = pipe.Get(ctx, deviceNonceKey(dev))
+		pipe.Del(ctx, deviceNonceKey(dev))
+		return nil
+	})
+
+	expected := get.Val()
+	if err == redis.Nil {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return nonce != "" && subtle.ConstantTimeCompare([]byte(nonce), []byte(expected)) == 1, nil
+}
+
+type deviceSecretRequest struct {
+	Nonce string `json:"nonce"`
+}
+
+type deviceSecretResponse struct {
+	Secret string `json:"secret"`
+}
+
+// deviceSecretHandler hands the device a new secret. Devices without one yet
+// prove they hold their token with the nonce pushed to them when registering,
+// the rest authenticate with their current secret, which stops working then.
+func (a *api) deviceSecretHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	dsr := &deviceSecretRequest{}
+	if err := json.NewDecoder(r.Body).Decode(dsr); err != nil {
+		a.errorResponse(w, r, 400, err)
+		return
+	}
+
+	dev, err := a.deviceRepo.GetByAPNSToken(ctx, mux.Vars(r)["apns"])
+	if err != nil {
+		a.errorResponse(w, r, 500, err)
+		return
+	}
+
+	// deviceAuthMiddleware already made sure the rest used their secret
+	if dev.Secret == "" {
+		ok, err := a.checkDeviceNonce(ctx, dev, dsr.Nonce)
+		if err != nil {
+			a.errorResponse(w, r, 500, err)
+			return
+		}
+		if !ok {
+			_ = a.statsd.Incr("apollo.device.nonce_failed", nil, 1.0)
+			a.errorResponse(w, r, 401, errInvalidDeviceNonce)
+			return
+		}
+	}
+
+	secret, err := domain.NewDeviceSecret()
+	if err != nil {
+		a.errorResponse(w, r, 500, err)
+		return
+	}
+
+	sealed, err := a.deviceSecrets.Seal(secret)
+	if err != nil {
+		a.errorResponse(w, r, 500, err)
+		return
+	}
+
+	if err := a.deviceRepo.SetSecret(ctx, &dev, sealed); err != nil {
+		a.errorResponse(w, r, 500, err)
+		return
+	}
+
+	_ = a.statsd.Incr("apollo.device.secret_issued", nil, 1.0)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(deviceSecretResponse{Secret: secret})
 }
 
 func (a *api) testDeviceHandler(w http.ResponseWriter, r *http.Request) {
@@ -54,12 +209,21 @@ func (a *api) testDeviceHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	accs, err := a.accountRepo.GetByAPNSToken(ctx, tok)
-	if err != nil {
-		a.errorResponse(w, r, 500, err)
+	if status, err := a.sendTestNotification(ctx, d); err != nil {
+		a.errorResponse(w, r, status, err)
 		return
 	}
 
+	w.WriteHeader(http.StatusOK)
+}
+
+// sendTestNotification pushes a notification listing the device's accounts.
+func (a *api) sendTestNotification(ctx context.Context, d domain.Device) (int, error) {
+	accs, err := a.accountRepo.GetByAPNSToken(ctx, d.APNSToken)
+	if err != nil {
+		return 500, err
+	}
+
 	users := make([]string, len(accs))
 	for i := range accs {
 		users[i] = accs[i].Username
@@ -86,12 +250,11 @@ func (a *api) testDeviceHandler(w http.ResponseWriter, r *http.Request) {
 	res, err := client.Push(notification)
 	if err != nil {
 		a.logger.Info("failed to send test notification", zap.Error(err))
-		a.errorResponse(w, r, 500, err)
+		return 500, err
 	} else if !res.Sent() {
-		a.errorResponse(w, r, 422, fmt.Errorf("errror sending notification: %d: %s", res.StatusCode, res.Reason))
-	} else {
-		w.WriteHeader(http.StatusOK)
+		return 422, fmt.Errorf("errror sending notification: %d: %s", res.StatusCode, res.Reason)
 	}
+	return 200, nil
 }
 
 func (a *api) deleteDeviceHandler(w http.ResponseWriter, r *http.Request) {
@@ -120,3 +283,91 @@ func (a *api) deleteDeviceHandler(w http.ResponseWriter, r *http.Request) {
 
 	w.WriteHeader(http.StatusOK)
 }
+
+type migrateDeviceRequest struct {
+	APNSToken string `json:"apns_token"`
+	Sandbox   bool   `json:"sandbox"`
+}
+
+// migrateDeviceHandler moves everything tied to a device over to the new token
+// iOS handed out for it. Only the device itself gets to, so it has to have a
+// secret, which deviceAuthMiddleware already checked the request against.
+// Devices without one get one first, like when they register.
+func (a *api) migrateDeviceHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	vars := mux.Vars(r)
+	old := vars["apns"]
+
+	mdr := &migrateDeviceRequest{}
+	if err := json.NewDecoder(r.Body).Decode(mdr); err != nil {
+		a.errorResponse(w, r, 400, err)
+		return
+	}
+
+	if mdr.APNSToken == old {
+		a.errorResponse(w, r, 422, errors.New("cannot migrate device to the same token"))
+		return
+	}
+
+	from, err := a.deviceRepo.GetByAPNSToken(ctx, old)
+	if err != nil {
+		a.errorResponse(w, r, 422, err)
+		return
+	}
+
+	if from.Secret == "" {
+		a.errorResponse(w, r, 401, errMissingDeviceAuth)
+		return
+	}
+
+	to, err := a.deviceRepo.GetByAPNSToken(ctx, mdr.APNSToken)
+	switch err {
+	case nil:
+		// The new token may have registered already, but it shouldn't lose the old one's subscription
+		if from.GracePeriodExpiresAt.After(to.GracePeriodExpiresAt) {
+			to.ExpiresAt = from.ExpiresAt
+			to.GracePeriodExpiresAt = from.GracePeriodExpiresAt
+			err = a.deviceRepo.Update(ctx, &to)
+		}
+	case domain.ErrNotFound:
+		to = domain.Device{
+			APNSToken:            mdr.APNSToken,
+			Sandbox:              mdr.Sandbox,
+			ExpiresAt:            from.ExpiresAt,
+			GracePeriodExpiresAt: from.GracePeriodExpiresAt,
+		}
+		err = a.deviceRepo.Create(ctx, &to)
+	}
+	if err != nil {
+		a.errorResponse(w, r, 422, err)
+		return
+	}
+
+	if err := a.deviceRepo.Migrate(ctx, &from, &to); err != nil {
+		a.errorResponse(w, r, 500, err)
+		return
+	}
+
+	if err := a.clearDeviceDedupeKeys(ctx, from); err != nil {
+		a.logger.Error("failed to clear dedupe keys of migrated device", zap.Error(err), zap.Int64("device#id", from.ID))
+	}
+
+	_ = a.statsd.Incr("apollo.device.migrated", nil, 1.0)
+	w.WriteHeader(http.StatusOK)
+}
+
+// clearDeviceDedupeKeys removes the keys watcher workers use to avoid notifying
+// a device about the same post twice, which they keep track of under
+// domain.DeviceDedupeKey.
+func (a *api) clearDeviceDedupeKeys(ctx context.Context, dev domain.Device) error {
+	dedupeKey := domain.DeviceDedupeKey(dev.ID)
+
+	keys, err := a.redis.ZRange(ctx, dedupeKey, 0, -1).Result()
+	if err != nil {
+		return err
+	}
+
+	return a.redis.Del(ctx, append(keys, dedupeKey)...).Err()
+}
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/christianselig/apollo-backend/internal/domain"
+)
+
+func TestCheckDeviceNonce(t *testing.T) {
+	t.Parallel()
+
+	dev := domain.Device{ID: 42}
+
+	tt := map[string]struct {
+		stored string
+		nonce  string
+		want   bool
+	}{
+		"matching":  {"abc123", "abc123", true},
+		"wrong":     {"abc123", "abc124", false},
+		"empty":     {"abc123", "", false},
+		"never set": {"", "abc123", false},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			mr := miniredis.RunT(t)
+			a := &api{redis: redis.NewClient(&redis.Options{Addr: mr.Addr()})}
+
+			if tc.stored != "" {
+				require.NoError(t, mr.Set(deviceNonceKey(dev), tc.stored))
+			}
+
+			ok, err := a.checkDeviceNonce(ctx, dev, tc.nonce)
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, ok)
+
+			// Right or wrong, it's gone
+			assert.False(t, mr.Exists(deviceNonceKey(dev)))
+		})
+	}
+}
+
+func TestClearDeviceDedupeKeys(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	a := &api{redis: redis.NewClient(&redis.Options{Addr: mr.Addr()})}
+
+	dev := domain.Device{ID: 42}
+	keys := []string{"watcher:42:abc123", "watcher:trending:42:def456"}
+	for i, key := range keys {
+		require.NoError(t, mr.Set(key, "1"))
+		_, err := mr.ZAdd(domain.DeviceDedupeKey(dev.ID), float64(i), key)
+		require.NoError(t, err)
+	}
+
+	// The same watcher ID as the device's, which isn't tracked
+	require.NoError(t, mr.Set("watcher:42:ratelimit", "1"))
+
+	require.NoError(t, a.clearDeviceDedupeKeys(ctx, dev))
+
+	for _, key := range keys {
+		assert.False(t, mr.Exists(key))
+	}
+	assert.False(t, mr.Exists(domain.DeviceDedupeKey(dev.ID)))
+	assert.True(t, mr.Exists("watcher:42:ratelimit"))
+}
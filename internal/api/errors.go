@@ -1,13 +1,143 @@
 package api
 
 import (
+	"encoding/json"
 	"errors"
 	"net/http"
+	"strconv"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+
+	"github.com/christianselig/apollo-backend/internal/domain"
+	"github.com/christianselig/apollo-backend/internal/reddit"
 )
 
 var ErrDuplicateAPNSToken = errors.New("duplicate apns token")
 
+// errorEnvelope is the body of every error response, e.g.
+//
+//	{"error":{"code":"subreddit_private","message":"subreddit is private"}}
+//
+// Codes are stable and meant for the app to branch on, messages are not.
+type errorEnvelope struct {
+	Error errorBody `json:"error"`
+}
+
+type errorBody struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+
+	// Which fields failed validation, and why
+	Fields map[string]string `json:"fields,omitempty"`
+}
+
+// knownErrors always get the same code, wherever they come from. They get their
+// status too, unless the handler asked for a specific one. Failures upstream
+// always keep theirs, so they're never mistaken for the client's fault.
+var knownErrors = []struct {
+	err    error
+	status int
+	code   string
+}{
+	{domain.ErrNotFound, 404, "not_found"},
+	{domain.ErrConflict, 409, "conflict"},
+	{ErrDuplicateAPNSToken, 409, "duplicate_apns_token"},
+	{errMissingDeviceAuth, 401, "authentication_required"},
+	{errInvalidDeviceAuth, 401, "authentication_failed"},
+	{errInvalidDeviceNonce, 401, "nonce_invalid"},
+	{errLiveActivityEnded, 422, "live_activity_ended"},
+	{errUserFollowersDisabled, 403, "user_followers_disabled"},
+	{errRateLimited, 429, "rate_limited"},
+	{errAdminUnauthorized, 401, "admin_unauthorized"},
+	{errNotificationCheckQueued, 409, "check_in_progress"},
+	{reddit.ErrSubredditIsPrivate, 403, "subreddit_private"},
+	{reddit.ErrSubredditIsQuarantined, 403, "subreddit_quarantined"},
+	{reddit.ErrSubredditNotFound, 422, "subreddit_not_found"},
+	{reddit.ErrUserNotFound, 422, "user_not_found"},
+	{reddit.ErrOauthRevoked, 401, "reddit_oauth_revoked"},
+	{reddit.ErrRateLimited, 503, "reddit_rate_limited"},
+	{reddit.ErrTooManyRequests, 503, "reddit_rate_limited"},
+	{reddit.ErrTimeout, 504, "reddit_timeout"},
+}
+
+// Codes for errors we know nothing more about than their status
+var statusCodes = map[int]string{
+	400: "bad_request",
+	401: "unauthorized",
+	403: "forbidden",
+	404: "not_found",
+	405: "method_not_allowed",
+	409: "conflict",
+	422: "unprocessable",
+	429: "rate_limited",
+}
+
+// classifyError works out the status and body of an error response. Handlers
+// pass in 500 when they have nothing more specific to say, which known errors
+// then replace with their own status.
+func classifyError(status int, err error) (int, errorBody) {
+	body := errorBody{Message: err.Error()}
+
+	for _, ke := range knownErrors {
+		if errors.Is(err, ke.err) {
+			body.Code = ke.code
+			if status == http.StatusInternalServerError || ke.status >= 500 {
+				status = ke.status
+			}
+			return status, body
+		}
+	}
+
+	var (
+		verrs  validation.Errors
+		serr   *json.SyntaxError
+		uterr  *json.UnmarshalTypeError
+		numerr *strconv.NumError
+		rserr  reddit.ServerError
+	)
+
+	switch {
+	case errors.As(err, &verrs):
+		body.Code = "validation_failed"
+		body.Fields = make(map[string]string, len(verrs))
+		for field, ferr := range verrs {
+			body.Fields[field] = ferr.Error()
+		}
+		return 422, body
+	case errors.As(err, &serr), errors.As(err, &uterr), errors.As(err, &numerr):
+		body.Code = "malformed_request"
+		return 400, body
+	case errors.As(err, &rserr):
+		body.Code = "reddit_error"
+		return 502, body
+	}
+
+	if code, ok := statusCodes[status]; ok {
+		body.Code = code
+		return status, body
+	}
+
+	body.Code = "internal_error"
+	if status < 500 {
+		status = 500
+	}
+	return status, body
+}
+
 func (a *api) errorResponse(w http.ResponseWriter, _ *http.Request, status int, err error) {
+	status, body := classifyError(status, err)
+
 	w.Header().Set("X-Apollo-Error", err.Error())
-	http.Error(w, err.Error(), status)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(errorEnvelope{Error: body})
+}
+
+func (a *api) notFoundHandler(w http.ResponseWriter, r *http.Request) {
+	a.errorResponse(w, r, 404, errors.New("no such route"))
+}
+
+func (a *api) methodNotAllowedHandler(w http.ResponseWriter, r *http.Request) {
+	a.errorResponse(w, r, 405, errors.New("method not allowed"))
 }
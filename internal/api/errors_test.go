@@ -0,0 +1,43 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/christianselig/apollo-backend/internal/domain"
+	"github.com/christianselig/apollo-backend/internal/reddit"
+)
+
+func TestClassifyError(t *testing.T) {
+	t.Parallel()
+
+	tt := map[string]struct {
+		status int
+		err    error
+		want   int
+		code   string
+	}{
+		"known error":                 {500, domain.ErrNotFound, 404, "not_found"},
+		"wrapped known error":         {500, fmt.Errorf("fetching device: %w", reddit.ErrSubredditIsPrivate), 403, "subreddit_private"},
+		"known error, chosen status":  {422, domain.ErrNotFound, 422, "not_found"},
+		"upstream error, any status":  {422, fmt.Errorf("fetching posts: %w", reddit.ErrTimeout), 504, "reddit_timeout"},
+		"unknown error":               {500, errors.New("boom"), 500, "internal_error"},
+		"unknown error, known status": {409, errors.New("taken"), 409, "conflict"},
+		"unknown error, odd status":   {418, errors.New("teapot"), 500, "internal_error"},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			status, body := classifyError(tc.status, tc.err)
+			assert.Equal(t, tc.want, status)
+			assert.Equal(t, tc.code, body.Code)
+			assert.Equal(t, tc.err.Error(), body.Message)
+		})
+	}
+}
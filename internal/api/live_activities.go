@@ -3,22 +3,123 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
+	"github.com/gorilla/mux"
+
 	"github.com/christianselig/apollo-backend/internal/domain"
 )
 
+var errLiveActivityEnded = errors.New("live activity has ended")
+
+// liveActivityResponse is what we let on about a live activity, never its reddit tokens.
+type liveActivityResponse struct {
+	ThreadID    string                     `json:"thread_id"`
+	Subreddit   string                     `json:"subreddit"`
+	Authors     domain.LiveActivityAuthors `json:"authors"`
+	Development bool                       `json:"development"`
+	Status      string                     `json:"status"`
+	NextCheckAt time.Time                  `json:"next_check_at"`
+	ExpiresAt   time.Time                  `json:"expires_at"`
+}
+
+func newLiveActivityResponse(la domain.LiveActivity, now time.Time) liveActivityResponse {
+	status := "active"
+	if la.Pending {
+		status = "pending"
+	} else if la.Ended(now) {
+		status = "ending"
+	}
+
+	return liveActivityResponse{
+		ThreadID:    la.ThreadID,
+		Subreddit:   la.Subreddit,
+		Authors:     la.Authors,
+		Development: la.Development,
+		Status:      status,
+		NextCheckAt: la.NextCheckAt,
+		ExpiresAt:   la.ExpiresAt,
+	}
+}
+
+// updateLiveActivityRequest changes what a live activity follows, or pushes its
+// expiry back by ExtendBy seconds, up to domain.LiveActivityDuration from now.
+// Authors is a pointer so an empty list can lift the restriction.
+type updateLiveActivityRequest struct {
+	ThreadID  string                      `json:"thread_id,omitempty"`
+	Subreddit string                      `json:"subreddit,omitempty"`
+	Authors   *domain.LiveActivityAuthors `json:"authors,omitempty"`
+	ExtendBy  int64                       `json:"extend_by,omitempty"`
+}
+
+// deviceLiveActivity looks up one of the live activities of the device in the
+// path, which deviceAuthMiddleware already made sure the request comes from.
+func (a *api) deviceLiveActivity(w http.ResponseWriter, r *http.Request) (domain.LiveActivity, bool) {
+	vars := mux.Vars(r)
+
+	dev, err := a.deviceRepo.GetByAPNSToken(r.Context(), vars["apns"])
+	if err != nil {
+		status := 500
+		if err == domain.ErrNotFound {
+			status = 404
+		}
+		a.errorResponse(w, r, status, err)
+		return domain.LiveActivity{}, false
+	}
+
+	la, err := a.liveActivityRepo.GetByDeviceID(r.Context(), dev.ID, vars["liveActivity"])
+	if err != nil {
+		status := 500
+		if err == domain.ErrNotFound {
+			status = 404
+		}
+		a.errorResponse(w, r, status, err)
+		return domain.LiveActivity{}, false
+	}
+
+	return la, true
+}
+
+// createLiveActivityHandler is what older versions of the app use, and creates
+// activities that aren't tied to any device. Nothing can be done with them
+// after, they're only kept up to date until they expire.
 func (a *api) createLiveActivityHandler(w http.ResponseWriter, r *http.Request) {
+	a.createLiveActivity(w, r, 0)
+}
+
+func (a *api) createDeviceLiveActivityHandler(w http.ResponseWriter, r *http.Request) {
+	dev, err := a.deviceRepo.GetByAPNSToken(r.Context(), mux.Vars(r)["apns"])
+	if err != nil {
+		status := 500
+		if err == domain.ErrNotFound {
+			status = 404
+		}
+		a.errorResponse(w, r, status, err)
+		return
+	}
+
+	a.createLiveActivity(w, r, dev.ID)
+}
+
+func (a *api) createLiveActivity(w http.ResponseWriter, r *http.Request, deviceID int64) {
 	ctx, cancel := context.WithCancel(r.Context())
 	defer cancel()
 
-	la := &domain.LiveActivity{}
+	la := &domain.LiveActivity{DeviceID: deviceID}
 	if err := json.NewDecoder(r.Body).Decode(la); err != nil {
 		a.errorResponse(w, r, 400, err)
 		return
 	}
 
+	if err := la.Validate(); err != nil {
+		a.errorResponse(w, r, 422, err)
+		return
+	}
+
 	if _, err := a.liveActivityRepo.Get(ctx, la.APNSToken); err == nil {
 		a.errorResponse(w, r, 400, ErrDuplicateAPNSToken)
 		return
@@ -34,6 +135,16 @@ func (a *api) createLiveActivityHandler(w http.ResponseWriter, r *http.Request)
 	la.RefreshToken = rtr.RefreshToken
 	la.TokenExpiresAt = time.Now().Add(1 * time.Hour)
 
+	// Only the live activities worker ever needs these in the clear
+	if la.AccessToken, err = a.liveActivityTokens.Seal(la.AccessToken); err != nil {
+		a.errorResponse(w, r, 500, err)
+		return
+	}
+	if la.RefreshToken, err = a.liveActivityTokens.Seal(la.RefreshToken); err != nil {
+		a.errorResponse(w, r, 500, err)
+		return
+	}
+
 	if err := a.liveActivityRepo.Create(ctx, la); err != nil {
 		a.errorResponse(w, r, 500, err)
 		return
@@ -41,3 +152,166 @@ func (a *api) createLiveActivityHandler(w http.ResponseWriter, r *http.Request)
 
 	w.WriteHeader(http.StatusOK)
 }
+
+func (a *api) getLiveActivityHandler(w http.ResponseWriter, r *http.Request) {
+	la, ok := a.deviceLiveActivity(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(newLiveActivityResponse(la, time.Now()))
+}
+
+func (a *api) updateLiveActivityHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	la, ok := a.deviceLiveActivity(w, r)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	if la.Ended(now) {
+		a.errorResponse(w, r, 422, errLiveActivityEnded)
+		return
+	}
+
+	ular := &updateLiveActivityRequest{}
+	if err := json.NewDecoder(r.Body).Decode(ular); err != nil {
+		a.errorResponse(w, r, 400, err)
+		return
+	}
+
+	if ular.ThreadID != "" {
+		la.ThreadID = strings.ToLower(ular.ThreadID)
+	}
+	if ular.Subreddit != "" {
+		la.Subreddit = ular.Subreddit
+	}
+	if ular.Authors != nil {
+		la.Authors = *ular.Authors
+	}
+	if ular.ExtendBy > 0 {
+		la.ExpiresAt = la.ExpiresAt.Add(time.Duration(ular.ExtendBy) * time.Second)
+		if max := now.Add(domain.LiveActivityDuration); la.ExpiresAt.After(max) {
+			la.ExpiresAt = max
+		}
+	}
+
+	if err := la.Validate(); err != nil {
+		a.errorResponse(w, r, 422, err)
+		return
+	}
+
+	if err := a.liveActivityRepo.UpdateSettings(ctx, &la); err != nil {
+		a.errorResponse(w, r, 500, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(newLiveActivityResponse(la, now))
+}
+
+func (a *api) deleteLiveActivityHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	la, ok := a.deviceLiveActivity(w, r)
+	if !ok {
+		return
+	}
+
+	if now := time.Now(); !la.Ended(now) {
+		la.ExpiresAt = now
+		if err := a.liveActivityRepo.UpdateSettings(ctx, &la); err != nil {
+			a.errorResponse(w, r, 500, err)
+			return
+		}
+	}
+
+	// The live activities worker sends the final end event with up to date
+	// content and cleans up after, so hand it over right away instead of
+	// waiting on the scheduler. Taking the scheduler's lock means nobody else
+	// has it queued, otherwise that pass or the next one ends it.
+	key := fmt.Sprintf("locks:live-activities:%s", la.APNSToken)
+	locked, err := a.redis.SetNX(ctx, key, 1, domain.NotificationCheckTimeout).Result()
+	if err != nil {
+		a.errorResponse(w, r, 500, err)
+		return
+	}
+	if locked {
+		if err := a.liveActivitiesQueue.Publish(la.APNSToken); err != nil {
+			_ = a.redis.Del(ctx, key).Err()
+			a.errorResponse(w, r, 500, err)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+type claimLiveActivityRequest struct {
+	ThreadID  string `json:"thread_id"`
+	APNSToken string `json:"apns_token"`
+}
+
+// claimLiveActivityHandler takes the token of an activity we started on the
+// device through its push-to-start token, which until then is only known by
+// the thread it follows.
+func (a *api) claimLiveActivityHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	clar := &claimLiveActivityRequest{}
+	if err := json.NewDecoder(r.Body).Decode(clar); err != nil {
+		a.errorResponse(w, r, 400, err)
+		return
+	}
+
+	dev, err := a.deviceRepo.GetByAPNSToken(ctx, mux.Vars(r)["apns"])
+	if err != nil {
+		status := 500
+		if err == domain.ErrNotFound {
+			status = 404
+		}
+		a.errorResponse(w, r, status, err)
+		return
+	}
+
+	la, err := a.liveActivityRepo.GetPending(ctx, dev.ID, strings.ToLower(clar.ThreadID))
+	if err != nil {
+		status := 500
+		if err == domain.ErrNotFound {
+			status = 404
+		}
+		a.errorResponse(w, r, status, err)
+		return
+	}
+
+	claimed := la
+	claimed.APNSToken = clar.APNSToken
+	if err := claimed.Validate(); err != nil {
+		a.errorResponse(w, r, 422, err)
+		return
+	}
+
+	if err := a.liveActivityRepo.Claim(ctx, &la, clar.APNSToken); err != nil {
+		switch err {
+		case domain.ErrNotFound:
+			a.errorResponse(w, r, 404, err)
+		case domain.ErrConflict:
+			a.errorResponse(w, r, 409, ErrDuplicateAPNSToken)
+		default:
+			a.errorResponse(w, r, 500, err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(newLiveActivityResponse(la, time.Now()))
+}
@@ -0,0 +1,215 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/gorilla/mux"
+
+	"github.com/christianselig/apollo-backend/internal/domain"
+	"github.com/christianselig/apollo-backend/internal/reddit"
+)
+
+type pushToStartTokenRequest struct {
+	PushToStartToken string `json:"push_to_start_token"`
+}
+
+func (ptstr *pushToStartTokenRequest) Validate() error {
+	return validation.ValidateStruct(ptstr,
+		validation.Field(&ptstr.PushToStartToken, validation.Length(64, 200)),
+	)
+}
+
+// pushToStartTokenHandler saves the token that lets us start live activities
+// on the device. An empty token stops us from starting any.
+func (a *api) pushToStartTokenHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	ptstr := &pushToStartTokenRequest{}
+	if err := json.NewDecoder(r.Body).Decode(ptstr); err != nil {
+		a.errorResponse(w, r, 400, err)
+		return
+	}
+
+	if err := ptstr.Validate(); err != nil {
+		a.errorResponse(w, r, 422, err)
+		return
+	}
+
+	dev, err := a.deviceRepo.GetByAPNSToken(ctx, mux.Vars(r)["apns"])
+	if err != nil {
+		a.errorResponse(w, r, 422, err)
+		return
+	}
+
+	if err := a.deviceRepo.SetPushToStartToken(ctx, &dev, ptstr.PushToStartToken); err != nil {
+		a.errorResponse(w, r, 500, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+type createLiveActivitySubscriptionRequest struct {
+	Subreddit    string                     `json:"subreddit"`
+	TitlePattern string                     `json:"title_pattern"`
+	Authors      domain.LiveActivityAuthors `json:"authors"`
+}
+
+type liveActivitySubscriptionItem struct {
+	ID           int64                      `json:"id"`
+	CreatedAt    time.Time                  `json:"created_at"`
+	Subreddit    string                     `json:"subreddit"`
+	TitlePattern string                     `json:"title_pattern"`
+	Authors      domain.LiveActivityAuthors `json:"authors"`
+}
+
+func (a *api) createLiveActivitySubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	vars := mux.Vars(r)
+
+	clasr := &createLiveActivitySubscriptionRequest{}
+	if err := json.NewDecoder(r.Body).Decode(clasr); err != nil {
+		a.errorResponse(w, r, 400, err)
+		return
+	}
+
+	las := domain.LiveActivitySubscription{
+		TitlePattern: clasr.TitlePattern,
+		Authors:      clasr.Authors,
+	}
+	if err := las.Validate(); err != nil {
+		a.errorResponse(w, r, 422, err)
+		return
+	}
+
+	dev, account, status, err := a.watcherDeviceAccount(ctx, vars["apns"], vars["redditID"])
+	if err != nil {
+		a.errorResponse(w, r, status, err)
+		return
+	}
+
+	subs, err := a.liveActivitySubscriptionRepo.GetByDeviceAPNSTokenAndAccountRedditID(ctx, vars["apns"], vars["redditID"])
+	if err != nil {
+		a.errorResponse(w, r, 500, err)
+		return
+	}
+	if len(subs) >= domain.MaxLiveActivitySubscriptions {
+		err := fmt.Errorf("cannot follow more than %d threads", domain.MaxLiveActivitySubscriptions)
+		a.errorResponse(w, r, 422, err)
+		return
+	}
+
+	ac := a.reddit.NewAuthenticatedClient(account.AccountID, account.RefreshToken, account.AccessToken)
+	srr, err := ac.SubredditAbout(ctx, clasr.Subreddit)
+	if err != nil {
+		switch err {
+		case reddit.ErrSubredditIsPrivate, reddit.ErrSubredditIsQuarantined:
+			a.errorResponse(w, r, 403, fmt.Errorf("error following %s: %w", clasr.Subreddit, err))
+		case reddit.ErrSubredditNotFound:
+			a.errorResponse(w, r, 422, err)
+		default:
+			a.errorResponse(w, r, 500, err)
+		}
+		return
+	}
+	if !srr.Public {
+		a.errorResponse(w, r, 403, reddit.ErrSubredditIsPrivate)
+		return
+	}
+
+	sr, err := a.subredditRepo.GetByName(ctx, srr.Name)
+	if err != nil {
+		if err != domain.ErrNotFound {
+			a.errorResponse(w, r, 500, err)
+			return
+		}
+
+		// Might be that we don't know about that subreddit yet
+		sr = domain.Subreddit{SubredditID: srr.ID, Name: srr.Name}
+		if err := a.subredditRepo.CreateOrUpdate(ctx, &sr); err != nil {
+			a.errorResponse(w, r, 500, err)
+			return
+		}
+	}
+
+	las.DeviceID = dev.ID
+	las.AccountID = account.ID
+	las.SubredditID = sr.ID
+
+	if err := a.liveActivitySubscriptionRepo.Create(ctx, &las); err != nil {
+		a.errorResponse(w, r, 500, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(liveActivitySubscriptionItem{
+		ID:           las.ID,
+		CreatedAt:    las.CreatedAt,
+		Subreddit:    sr.Name,
+		TitlePattern: las.TitlePattern,
+		Authors:      las.Authors,
+	})
+}
+
+func (a *api) listLiveActivitySubscriptionsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	vars := mux.Vars(r)
+
+	subs, err := a.liveActivitySubscriptionRepo.GetByDeviceAPNSTokenAndAccountRedditID(ctx, vars["apns"], vars["redditID"])
+	if err != nil {
+		a.errorResponse(w, r, 400, err)
+		return
+	}
+
+	items := make([]liveActivitySubscriptionItem, len(subs))
+	for i, las := range subs {
+		items[i] = liveActivitySubscriptionItem{
+			ID:           las.ID,
+			CreatedAt:    las.CreatedAt,
+			Subreddit:    las.Subreddit,
+			TitlePattern: las.TitlePattern,
+			Authors:      las.Authors,
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(items)
+}
+
+func (a *api) deleteLiveActivitySubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	vars := mux.Vars(r)
+	id, err := strconv.ParseInt(vars["subscriptionID"], 10, 64)
+	if err != nil {
+		a.errorResponse(w, r, 422, err)
+		return
+	}
+
+	las, err := a.liveActivitySubscriptionRepo.GetByID(ctx, id)
+	if err != nil {
+		a.errorResponse(w, r, 422, err)
+		return
+	} else if las.Device.APNSToken != vars["apns"] {
+		err := fmt.Errorf("wrong device for live activity subscription %d", las.ID)
+		a.errorResponse(w, r, 422, err)
+		return
+	}
+
+	_ = a.liveActivitySubscriptionRepo.Delete(ctx, id)
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,313 @@
+package api
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/gorilla/mux"
+	"gopkg.in/yaml.v3"
+)
+
+// openAPISpec describes every route in Routes(), and is served as is to
+// whoever wants to generate a client from it.
+//
+//go:embed openapi.yaml
+var openAPISpec []byte
+
+// Request bodies are read up front to validate them, so cap how much we'll read
+const maxValidatedBodySize = 1 << 20
+
+// openAPIDocument is the little of an OpenAPI document we need to check
+// requests, and in tests responses, against it.
+type openAPIDocument struct {
+	Paths      map[string]openAPIPathItem `yaml:"paths"`
+	Components struct {
+		Schemas map[string]*openAPISchema `yaml:"schemas"`
+	} `yaml:"components"`
+}
+
+type openAPIPathItem struct {
+	Get    *openAPIOperation `yaml:"get"`
+	Post   *openAPIOperation `yaml:"post"`
+	Patch  *openAPIOperation `yaml:"patch"`
+	Put    *openAPIOperation `yaml:"put"`
+	Delete *openAPIOperation `yaml:"delete"`
+}
+
+func (pi openAPIPathItem) operation(method string) *openAPIOperation {
+	switch method {
+	case http.MethodGet:
+		return pi.Get
+	case http.MethodPost:
+		return pi.Post
+	case http.MethodPatch:
+		return pi.Patch
+	case http.MethodPut:
+		return pi.Put
+	case http.MethodDelete:
+		return pi.Delete
+	}
+	return nil
+}
+
+type openAPIOperation struct {
+	OperationID string `yaml:"operationId"`
+	RequestBody *struct {
+		Required bool `yaml:"required"`
+		Content  map[string]struct {
+			Schema *openAPISchema `yaml:"schema"`
+		} `yaml:"content"`
+	} `yaml:"requestBody"`
+	Responses map[string]struct {
+		Content map[string]struct {
+			Schema *openAPISchema `yaml:"schema"`
+		} `yaml:"content"`
+	} `yaml:"responses"`
+}
+
+// responseSchema returns the schema of the operation's JSON response for a
+// status, like "200", if it has one.
+func (op *openAPIOperation) responseSchema(status string) *openAPISchema {
+	content, ok := op.Responses[status].Content["application/json"]
+	if !ok {
+		return nil
+	}
+	return content.Schema
+}
+
+// jsonSchema returns the schema of the operation's JSON request body, if it has one.
+func (op *openAPIOperation) jsonSchema() (*openAPISchema, bool) {
+	if op.RequestBody == nil {
+		return nil, false
+	}
+
+	content, ok := op.RequestBody.Content["application/json"]
+	if !ok || content.Schema == nil {
+		return nil, false
+	}
+	return content.Schema, op.RequestBody.Required
+}
+
+type openAPISchema struct {
+	Ref        string                    `yaml:"$ref"`
+	Type       string                    `yaml:"type"`
+	Format     string                    `yaml:"format"`
+	Nullable   bool                      `yaml:"nullable"`
+	Required   []string                  `yaml:"required"`
+	Properties map[string]*openAPISchema `yaml:"properties"`
+	Items      *openAPISchema            `yaml:"items"`
+	Enum       []interface{}             `yaml:"enum"`
+	MinLength  *int                      `yaml:"minLength"`
+	MaxLength  *int                      `yaml:"maxLength"`
+	MinItems   *int                      `yaml:"minItems"`
+	MaxItems   *int                      `yaml:"maxItems"`
+	Minimum    *float64                  `yaml:"minimum"`
+	Maximum    *float64                  `yaml:"maximum"`
+}
+
+func parseOpenAPI(bb []byte) (*openAPIDocument, error) {
+	doc := &openAPIDocument{}
+	if err := yaml.Unmarshal(bb, doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+func (doc *openAPIDocument) resolve(schema *openAPISchema) *openAPISchema {
+	for schema != nil && schema.Ref != "" {
+		schema = doc.Components.Schemas[strings.TrimPrefix(schema.Ref, "#/components/schemas/")]
+	}
+	return schema
+}
+
+// validate checks a decoded JSON value against a schema, and adds anything
+// wrong with it to errs keyed by where in the value it is, e.g. "criteria.media".
+func (doc *openAPIDocument) validate(schema *openAPISchema, path string, v interface{}, errs validation.Errors) {
+	schema = doc.resolve(schema)
+	if schema == nil {
+		return
+	}
+
+	field := path
+	if field == "" {
+		field = "body"
+	}
+
+	if v == nil {
+		if !schema.Nullable {
+			errs[field] = errors.New("must not be null")
+		}
+		return
+	}
+
+	if len(schema.Enum) > 0 && !openAPIEnumContains(schema.Enum, v) {
+		errs[field] = fmt.Errorf("must be one of %v", schema.Enum)
+		return
+	}
+
+	switch schema.Type {
+	case "object":
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			errs[field] = errors.New("must be an object")
+			return
+		}
+
+		for _, name := range schema.Required {
+			if _, ok := obj[name]; !ok {
+				errs[openAPIPath(path, name)] = errors.New("is required")
+			}
+		}
+
+		for name, val := range obj {
+			if ps, ok := schema.Properties[name]; ok {
+				doc.validate(ps, openAPIPath(path, name), val, errs)
+			}
+		}
+	case "array":
+		arr, ok := v.([]interface{})
+		if !ok {
+			errs[field] = errors.New("must be an array")
+			return
+		}
+
+		if schema.MinItems != nil && len(arr) < *schema.MinItems {
+			errs[field] = fmt.Errorf("must have at least %d items", *schema.MinItems)
+			return
+		}
+		if schema.MaxItems != nil && len(arr) > *schema.MaxItems {
+			errs[field] = fmt.Errorf("must have at most %d items", *schema.MaxItems)
+			return
+		}
+
+		for i, item := range arr {
+			doc.validate(schema.Items, fmt.Sprintf("%s[%d]", field, i), item, errs)
+		}
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			errs[field] = errors.New("must be a string")
+			return
+		}
+
+		length := utf8.RuneCountInString(str)
+		if schema.MinLength != nil && length < *schema.MinLength {
+			errs[field] = fmt.Errorf("must be at least %d characters", *schema.MinLength)
+		} else if schema.MaxLength != nil && length > *schema.MaxLength {
+			errs[field] = fmt.Errorf("must be at most %d characters", *schema.MaxLength)
+		} else if schema.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339, str); err != nil {
+				errs[field] = errors.New("must be an RFC 3339 date")
+			}
+		}
+	case "integer", "number":
+		n, ok := v.(float64)
+		if !ok {
+			errs[field] = fmt.Errorf("must be a %s", schema.Type)
+			return
+		}
+
+		if schema.Type == "integer" && n != math.Trunc(n) {
+			errs[field] = errors.New("must be an integer")
+		} else if schema.Minimum != nil && n < *schema.Minimum {
+			errs[field] = fmt.Errorf("must be at least %v", *schema.Minimum)
+		} else if schema.Maximum != nil && n > *schema.Maximum {
+			errs[field] = fmt.Errorf("must be at most %v", *schema.Maximum)
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			errs[field] = errors.New("must be a boolean")
+		}
+	}
+}
+
+func openAPIPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+func openAPIEnumContains(enum []interface{}, v interface{}) bool {
+	for _, e := range enum {
+		if fmt.Sprint(e) == fmt.Sprint(v) {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *api) openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/yaml")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(openAPISpec)
+}
+
+// requestValidationMiddleware turns away JSON bodies that don't match their
+// route's schema in the spec, before they get to the handler. It only runs
+// when OPENAPI_VALIDATE_REQUESTS is set.
+func (a *api) requestValidationMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := mux.CurrentRoute(r)
+		if a.spec == nil || route == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		tpl, _ := route.GetPathTemplate()
+		op := a.spec.Paths[tpl].operation(r.Method)
+		if op == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		schema, required := op.jsonSchema()
+		if schema == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxValidatedBodySize))
+		if err != nil {
+			a.errorResponse(w, r, 400, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		if len(bytes.TrimSpace(body)) == 0 {
+			if required {
+				a.errorResponse(w, r, 422, validation.Errors{"body": errors.New("is required")})
+				return
+			}
+
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var v interface{}
+		if err := json.Unmarshal(body, &v); err != nil {
+			a.errorResponse(w, r, 400, err)
+			return
+		}
+
+		errs := validation.Errors{}
+		a.spec.validate(schema, "", v, errs)
+		if len(errs) > 0 {
+			_ = a.statsd.Incr("apollo.api.invalid_requests", []string{fmt.Sprintf("operation:%s", op.OperationID)}, 1.0)
+			a.errorResponse(w, r, 422, errs)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
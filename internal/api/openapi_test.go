@@ -0,0 +1,331 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+
+	"github.com/christianselig/apollo-backend/internal/domain"
+)
+
+func TestOpenAPICoversRoutes(t *testing.T) {
+	t.Parallel()
+
+	doc, err := parseOpenAPI(openAPISpec)
+	require.NoError(t, err)
+
+	routes := map[string]bool{}
+	err = (&api{}).Routes().Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		tpl, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+
+		methods, err := route.GetMethods()
+		if err != nil {
+			// Subrouters only have a prefix, every other route needs its methods
+			if re, _ := route.GetPathRegexp(); !strings.HasSuffix(re, "$") {
+				return nil
+			}
+			return fmt.Errorf("%s has no methods: %w", tpl, err)
+		}
+
+		for _, method := range methods {
+			routes[method+" "+tpl] = true
+			assert.NotNil(t, doc.Paths[tpl].operation(method), "%s %s is missing from openapi.yaml", method, tpl)
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	for path, item := range doc.Paths {
+		for _, method := range []string{"GET", "POST", "PATCH", "PUT", "DELETE"} {
+			if item.operation(method) != nil {
+				assert.True(t, routes[method+" "+path], "%s %s is in openapi.yaml but not routed", method, path)
+			}
+		}
+	}
+}
+
+func TestOpenAPIRefs(t *testing.T) {
+	t.Parallel()
+
+	var doc map[string]interface{}
+	require.NoError(t, yaml.Unmarshal(openAPISpec, &doc))
+
+	components := doc["components"].(map[string]interface{})
+
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			if ref, ok := v["$ref"].(string); ok {
+				parts := strings.Split(strings.TrimPrefix(ref, "#/components/"), "/")
+				require.Len(t, parts, 2, ref)
+
+				kind, _ := components[parts[0]].(map[string]interface{})
+				assert.Contains(t, kind, parts[1], "%s does not resolve", ref)
+			}
+
+			for _, child := range v {
+				walk(child)
+			}
+		case []interface{}:
+			for _, child := range v {
+				walk(child)
+			}
+		}
+	}
+	walk(doc)
+}
+
+func TestOpenAPIValidate(t *testing.T) {
+	t.Parallel()
+
+	doc, err := parseOpenAPI(openAPISpec)
+	require.NoError(t, err)
+
+	tt := map[string]struct {
+		path   string
+		method string
+		body   string
+		fields []string
+	}{
+		"valid watcher":          {"/v1/device/{apns}/account/{redditID}/watcher", "POST", `{"type":"subreddit","subreddit":"apolloapp","criteria":{"upvotes":100}}`, nil},
+		"watcher without type":   {"/v1/device/{apns}/account/{redditID}/watcher", "POST", `{"subreddit":"apolloapp"}`, []string{"type"}},
+		"unknown watcher type":   {"/v1/device/{apns}/account/{redditID}/watcher", "POST", `{"type":"comment"}`, []string{"type"}},
+		"unknown media":          {"/v1/device/{apns}/account/{redditID}/watcher", "POST", `{"type":"subreddit","criteria":{"media":"gif"}}`, []string{"criteria.media"}},
+		"fractional upvotes":     {"/v1/device/{apns}/account/{redditID}/watcher", "POST", `{"type":"subreddit","criteria":{"upvotes":1.5}}`, []string{"criteria.upvotes"}},
+		"bad snooze date":        {"/v1/device/{apns}/account/{redditID}/watcher", "POST", `{"type":"subreddit","snoozed_until":"tomorrow"}`, []string{"snoozed_until"}},
+		"unknown fields pass":    {"/v1/device/{apns}/account/{redditID}/watcher", "POST", `{"type":"user","user":"iamthatis","color":"red"}`, nil},
+		"accounts in array":      {"/v1/device/{apns}/accounts", "POST", `[{"Username":"iamthatis","AccessToken":"a","RefreshToken":"r"},{"Username":"x"}]`, []string{"body[1].Username", "body[1].AccessToken", "body[1].RefreshToken"}},
+		"accounts not an array":  {"/v1/device/{apns}/accounts", "POST", `{}`, []string{"body"}},
+		"too many milestones":    {"/v1/device/{apns}/account/{redditID}/milestones", "PATCH", `{"upvotes":[1,2,3,4,5,6,7,8,9,10,11]}`, []string{"upvotes"}},
+		"zero milestone":         {"/v1/device/{apns}/account/{redditID}/milestones", "PATCH", `{"comments":[0]}`, []string{"comments[0]"}},
+		"unknown author":         {"/v1/device/{apns}/live_activities/{liveActivity}", "PATCH", `{"authors":["op","admins"]}`, []string{"authors[1]"}},
+		"short live activity":    {"/v1/live_activities", "POST", `{"apns_token":"abc","reddit_account_id":"1ia22","refresh_token":"r","thread_id":"abc123","subreddit":"apolloapp"}`, []string{"apns_token"}},
+		"null where not allowed": {"/v1/device/{apns}/account/{redditID}/notifications", "PATCH", `{"global_mute":null}`, []string{"global_mute"}},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			op := doc.Paths[tc.path].operation(tc.method)
+			require.NotNil(t, op)
+
+			schema, _ := op.jsonSchema()
+			require.NotNil(t, schema)
+
+			var v interface{}
+			require.NoError(t, json.Unmarshal([]byte(tc.body), &v))
+
+			errs := validation.Errors{}
+			doc.validate(schema, "", v, errs)
+
+			fields := []string{}
+			for field := range errs {
+				fields = append(fields, field)
+			}
+
+			if tc.fields == nil {
+				tc.fields = []string{}
+			}
+			assert.ElementsMatch(t, tc.fields, fields)
+		})
+	}
+}
+
+// undocumentedFields lists the fields of a response that its schema doesn't
+// know about, which means the spec fell behind the handler.
+func undocumentedFields(doc *openAPIDocument, schema *openAPISchema, path string, v interface{}) []string {
+	schema = doc.resolve(schema)
+	if schema == nil {
+		return nil
+	}
+
+	fields := []string{}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if len(schema.Properties) == 0 {
+			return fields
+		}
+
+		for name, val := range v {
+			ps, ok := schema.Properties[name]
+			if !ok {
+				fields = append(fields, openAPIPath(path, name))
+				continue
+			}
+			fields = append(fields, undocumentedFields(doc, ps, openAPIPath(path, name), val)...)
+		}
+	case []interface{}:
+		field := path
+		if field == "" {
+			field = "body"
+		}
+
+		for i, item := range v {
+			fields = append(fields, undocumentedFields(doc, schema.Items, fmt.Sprintf("%s[%d]", field, i), item)...)
+		}
+	}
+	return fields
+}
+
+func TestOpenAPIResponses(t *testing.T) {
+	t.Parallel()
+
+	doc, err := parseOpenAPI(openAPISpec)
+	require.NoError(t, err)
+
+	now := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	// Every field set, so nothing is left out by omitempty
+	watcher := domain.Watcher{
+		ID:                  42,
+		CreatedAt:           now.Add(-time.Hour),
+		Label:               "Changelogs",
+		Type:                domain.MultiSubredditWatcher,
+		WatcheeLabel:        "apolloapp",
+		Author:              "iamthatis",
+		Upvotes:             100,
+		Keyword:             "changelog",
+		Flair:               "update",
+		Domain:              "apolloapp.io",
+		Hits:                3,
+		MediaKind:           domain.ImageWatcherMedia,
+		PostKind:            domain.SelfWatcherPostKind,
+		NSFW:                domain.ExcludeWatcherNSFW,
+		MinComments:         10,
+		MaxAge:              time.Hour,
+		MaxNotifications:    5,
+		NotificationWindow:  time.Hour,
+		Cooldown:            time.Minute,
+		SummarizeSuppressed: true,
+		SnoozedUntil:        now.Add(time.Hour),
+		ExpiresAt:           now.Add(24 * time.Hour),
+		NotifyOnExpiry:      true,
+		BrokenReason:        domain.WatcherSubredditPrivate,
+		BrokenAt:            now.Add(-time.Minute),
+		NotifyOnBroken:      true,
+		Subreddits:          []domain.Subreddit{{Name: "apolloapp"}, {Name: "iosbeta"}},
+	}
+
+	la := domain.LiveActivity{
+		ThreadID:    "abc123",
+		Subreddit:   "apolloapp",
+		Authors:     domain.LiveActivityOP | domain.LiveActivityModerators,
+		Development: true,
+		NextCheckAt: now,
+		ExpiresAt:   now.Add(time.Hour),
+	}
+
+	notifications := accountNotificationsRequest{InboxNotifications: true, WatcherNotifications: true, GlobalMute: true}
+
+	tt := map[string]struct {
+		path   string
+		method string
+		status string
+		body   interface{}
+	}{
+		"register device":  {"/v1/device", "POST", "200", upsertDeviceResponse{NonceSent: true}},
+		"device secret":    {"/v1/device/{apns}/secret", "POST", "200", deviceSecretResponse{Secret: "s3cr3t"}},
+		"notifications":    {"/v1/device/{apns}/account/{redditID}/notifications", "GET", "200", notifications},
+		"milestones":       {"/v1/device/{apns}/account/{redditID}/milestones", "GET", "200", accountMilestonesRequest{Upvotes: []int64{100}, Comments: []int64{10}}},
+		"created watcher":  {"/v1/device/{apns}/account/{redditID}/watcher", "POST", "200", watcherCreatedResponse{ID: 42}},
+		"watchers":         {"/v1/device/{apns}/account/{redditID}/watchers", "GET", "200", []watcherItem{newWatcherItem(watcher, now)}},
+		"trending watcher": {"/v1/device/{apns}/account/{redditID}/watchers", "GET", "200", []watcherItem{newWatcherItem(domain.Watcher{Type: domain.TrendingWatcher, TrendingSensitivity: domain.HighTrendingSensitivity}, now)}},
+		"user watcher":     {"/v1/device/{apns}/account/{redditID}/watchers", "GET", "200", []watcherItem{newWatcherItem(domain.Watcher{Type: domain.UserWatcher, UserActivity: domain.UserWatcherComments}, now)}},
+		"watcher hits": {"/v1/device/{apns}/account/{redditID}/watcher/{watcherID}/hits", "GET", "200", watcherHitsResponse{
+			Hits:   []watcherHitItem{{ID: 1, PostID: "abc123", Title: "Changelog", Subreddit: "apolloapp", Author: "iamthatis", MatchedAt: now, Status: domain.WatcherHitDelivered.String()}},
+			Before: 1,
+		}},
+		"watcher preview": {"/v1/device/{apns}/account/{redditID}/watcher/preview", "POST", "200", watcherPreviewResponse{
+			Evaluated: 1,
+			Matches: []watcherPreviewPost{{
+				ID: "abc123", Title: "Changelog", Subreddit: "apolloapp", Author: "iamthatis", Score: 100, CreatedAt: now,
+				Checks: []watcherPreviewCheck{{Criterion: "upvotes", Expected: "100", Actual: "100", Matched: true}},
+			}},
+		}},
+		"watcher export": {"/v1/device/{apns}/account/{redditID}/watchers/export", "GET", "200", watcherExport{
+			Version:    1,
+			ExportedAt: now,
+			Watchers:   []createWatcherRequest{exportedWatcher(watcher, now)},
+		}},
+		"watcher import": {"/v1/device/{apns}/account/{redditID}/watchers/import", "POST", "200", watcherImportResponse{
+			Imported: 1,
+			Failed:   1,
+			Results:  []watcherImportResult{{Index: 0, Label: "Changelogs", ID: 42}, {Index: 1, Label: "Broken", Error: "type: cannot be blank"}},
+		}},
+		"live activity": {"/v1/device/{apns}/live_activities/{liveActivity}", "GET", "200", newLiveActivityResponse(la, now)},
+		"subscription": {"/v1/device/{apns}/account/{redditID}/live_activity_subscription", "POST", "200", liveActivitySubscriptionItem{
+			ID: 1, CreatedAt: now, Subreddit: "apolloapp", TitlePattern: "Changelog *", Authors: domain.LiveActivityOP,
+		}},
+		"admin account": {"/admin/v1/account", "GET", "200", adminAccountResponse{
+			ID: 1, Username: "iamthatis", RedditAccountID: "1ia22", LastMessageID: "t4_abc", CheckCount: 1,
+			TokenExpiresAt: now, NextNotificationCheckAt: now,
+			Devices: []adminDeviceItem{{ID: 1, APNSToken: "abc", Sandbox: true, ExpiresAt: now, GracePeriodExpiresAt: now, Authenticated: true, PushToStart: true, Notifications: notifications}},
+		}},
+		"admin watchers": {"/admin/v1/devices/{apns}/watchers", "GET", "200", []adminAccountWatchers{{
+			Username: "iamthatis", RedditAccountID: "1ia22", Watchers: []watcherItem{newWatcherItem(watcher, now)},
+		}}},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			op := doc.Paths[tc.path].operation(tc.method)
+			require.NotNil(t, op)
+
+			schema := op.responseSchema(tc.status)
+			require.NotNil(t, schema, "%s %s has no %s response schema", tc.method, tc.path, tc.status)
+
+			bb, err := json.Marshal(tc.body)
+			require.NoError(t, err)
+
+			var v interface{}
+			require.NoError(t, json.Unmarshal(bb, &v))
+
+			errs := validation.Errors{}
+			doc.validate(schema, "", v, errs)
+			assert.Empty(t, errs)
+
+			assert.Empty(t, undocumentedFields(doc, schema, "", v))
+		})
+	}
+}
+
+func TestOpenAPIErrorResponse(t *testing.T) {
+	t.Parallel()
+
+	doc, err := parseOpenAPI(openAPISpec)
+	require.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	(&api{}).errorResponse(rr, nil, 422, validation.Errors{"label": errors.New("cannot be blank")})
+
+	var v interface{}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &v))
+
+	schema := &openAPISchema{Ref: "#/components/schemas/Error"}
+
+	errs := validation.Errors{}
+	doc.validate(schema, "", v, errs)
+	assert.Empty(t, errs)
+	assert.Empty(t, undocumentedFields(doc, schema, "", v))
+}
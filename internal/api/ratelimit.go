@@ -0,0 +1,218 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+var errRateLimited = errors.New("too many requests")
+
+// tokenBucket lets through up to Capacity requests at once, and gets a request
+// back every Refill after that.
+type tokenBucket struct {
+	Capacity int64
+	Refill   time.Duration
+}
+
+// routeRateLimit is how often a single device, and a single address, may call
+// a route. Addresses get more room since a lot of devices can share one.
+type routeRateLimit struct {
+	Device tokenBucket
+	IP     tokenBucket
+}
+
+// Routes not in routeRateLimits are cheap enough to only need this
+var defaultRateLimit = routeRateLimit{
+	Device: tokenBucket{Capacity: 60, Refill: time.Second},
+	IP:     tokenBucket{Capacity: 300, Refill: 200 * time.Millisecond},
+}
+
+// Calls to reddit count against our own quota, so routes making them
+// synchronously get much less room than the rest.
+var redditRateLimit = routeRateLimit{
+	Device: tokenBucket{Capacity: 10, Refill: 6 * time.Second},
+	IP:     tokenBucket{Capacity: 50, Refill: 1200 * time.Millisecond},
+}
+
+// Every one of these sends a push notification
+var testNotificationRateLimit = routeRateLimit{
+	Device: tokenBucket{Capacity: 5, Refill: 12 * time.Second},
+	IP:     tokenBucket{Capacity: 50, Refill: 1200 * time.Millisecond},
+}
+
+// routeRateLimits are keyed by method and path template, like "POST /v1/device".
+var routeRateLimits = map[string]routeRateLimit{
+	"POST /v1/device/{apns}/account":                                       redditRateLimit,
+	"POST /v1/device/{apns}/accounts":                                      redditRateLimit,
+	"POST /v1/device/{apns}/account/{redditID}/watcher":                    redditRateLimit,
+	"POST /v1/device/{apns}/account/{redditID}/watcher/preview":            redditRateLimit,
+	"POST /v1/device/{apns}/account/{redditID}/watchers/import":            redditRateLimit,
+	"POST /v1/device/{apns}/account/{redditID}/live_activity_subscription": redditRateLimit,
+	"POST /v1/device/{apns}/live_activities":                               redditRateLimit,
+	"POST /v1/live_activities":                                             redditRateLimit,
+
+	"POST /v1/device":                               testNotificationRateLimit,
+	"POST /v1/device/{apns}/secret":                 testNotificationRateLimit,
+	"POST /v1/device/{apns}/test":                   testNotificationRateLimit,
+	"POST /v1/device/{apns}/test/comment_reply":     testNotificationRateLimit,
+	"POST /v1/device/{apns}/test/post_reply":        testNotificationRateLimit,
+	"POST /v1/device/{apns}/test/private_message":   testNotificationRateLimit,
+	"POST /v1/device/{apns}/test/subreddit_watcher": testNotificationRateLimit,
+	"POST /v1/device/{apns}/test/trending_post":     testNotificationRateLimit,
+	"POST /v1/device/{apns}/test/username_mention":  testNotificationRateLimit,
+
+	// Sends us an email
+	"POST /v1/contact": {Device: defaultRateLimit.Device, IP: tokenBucket{Capacity: 3, Refill: 20 * time.Minute}},
+}
+
+// Takes cost tokens out of the bucket, topping it up first for the time since
+// it was last used. Returns 0 when there were enough to take, or how many
+// milliseconds until there are. A cost of -1 puts a token back.
+var takeTokenScript = redis.NewScript(`
+local capacity = tonumber(ARGV[1])
+local refill = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local cost = tonumber(ARGV[4])
+
+local bucket = redis.call("HMGET", KEYS[1], "tokens", "at")
+local tokens = tonumber(bucket[1]) or capacity
+local at = tonumber(bucket[2]) or now
+
+tokens = math.min(capacity, tokens + math.max(0, now - at) / refill)
+
+local wait = 0
+if tokens >= cost then
+	tokens = math.min(capacity, tokens - cost)
+else
+	wait = math.ceil((cost - tokens) * refill)
+end
+
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "at", now)
+redis.call("PEXPIRE", KEYS[1], math.ceil(capacity * refill))
+return wait
+`)
+
+// rateLimit looks up the limit of the route a request is for, along with its
+// path template.
+func rateLimit(r *http.Request) (string, routeRateLimit, bool) {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return "", routeRateLimit{}, false
+	}
+
+	tpl, _ := route.GetPathTemplate()
+
+	limit, ok := routeRateLimits[fmt.Sprintf("%s %s", r.Method, tpl)]
+	if !ok {
+		limit = defaultRateLimit
+	}
+	return tpl, limit, true
+}
+
+// rateLimitMiddleware turns away addresses that went through their route's
+// token bucket, see routeRateLimits. It runs before anything else touches the
+// database, so failed authentication costs the caller too. Redis errors fail open.
+func (a *api) rateLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tpl, limit, ok := rateLimit(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if wait := a.takeToken(r, tpl, "ip", remoteIP(r), limit.IP, 1); wait > 0 {
+			a.rateLimitedResponse(w, r, wait)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// deviceRateLimitMiddleware does the same for devices, once
+// deviceAuthMiddleware made sure the request comes from the device it's
+// charged to. A device going over its limit doesn't count against its
+// address, so it gets back the token rateLimitMiddleware took.
+func (a *api) deviceRateLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tpl, limit, ok := rateLimit(r)
+		apns := mux.Vars(r)["apns"]
+		if !ok || apns == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if wait := a.takeToken(r, tpl, "device", apns, limit.Device, 1); wait > 0 {
+			_ = a.takeToken(r, tpl, "ip", remoteIP(r), limit.IP, -1)
+			a.rateLimitedResponse(w, r, wait)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (a *api) rateLimitedResponse(w http.ResponseWriter, r *http.Request, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.FormatInt(int64(math.Ceil(wait.Seconds())), 10))
+	a.errorResponse(w, r, 429, errRateLimited)
+}
+
+// takeToken takes cost tokens from a caller's bucket for a route, and returns
+// how long they have to wait for them if there weren't enough left.
+func (a *api) takeToken(r *http.Request, route, scope, caller string, bucket tokenBucket, cost int64) time.Duration {
+	key := fmt.Sprintf("ratelimit:%s %s:%s:%s", r.Method, route, scope, caller)
+
+	wait, err := takeBucketTokens(r.Context(), a.redis, key, bucket, cost, time.Now())
+	if err != nil {
+		a.logger.Error("failed to check rate limit", zap.Error(err), zap.String("method", r.Method), zap.String("route", route))
+		return 0
+	}
+
+	if wait > 0 {
+		_ = a.statsd.Incr("apollo.api.rate_limited", []string{
+			fmt.Sprintf("method:%s", r.Method),
+			fmt.Sprintf("route:%s", route),
+			fmt.Sprintf("scope:%s", scope),
+		}, 1.0)
+	}
+	return wait
+}
+
+func takeBucketTokens(ctx context.Context, rdb *redis.Client, key string, bucket tokenBucket, cost int64, now time.Time) (time.Duration, error) {
+	wait, err := takeTokenScript.Run(ctx, rdb, []string{key},
+		bucket.Capacity,
+		bucket.Refill.Milliseconds(),
+		now.UnixMilli(),
+		cost,
+	).Int64()
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(wait) * time.Millisecond, nil
+}
+
+// remoteIP is the address the request came from. Behind the load balancer
+// that's the last address in X-Forwarded-For, the one it added itself; any
+// before it came from the client and could be anything.
+func remoteIP(r *http.Request) string {
+	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+		hops := strings.Split(xff, ",")
+		return strings.TrimSpace(hops[len(hops)-1])
+	}
+
+	if ip, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return ip
+	}
+	return r.RemoteAddr
+}
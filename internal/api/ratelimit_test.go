@@ -0,0 +1,193 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestRouteRateLimitsAreRouted(t *testing.T) {
+	t.Parallel()
+
+	routes := map[string]bool{}
+	err := (&api{}).Routes().Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		tpl, _ := route.GetPathTemplate()
+		methods, _ := route.GetMethods()
+		for _, method := range methods {
+			routes[method+" "+tpl] = true
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	for name, limit := range routeRateLimits {
+		assert.True(t, routes[name], "%s is rate limited but not routed", name)
+		assert.Positive(t, limit.Device.Capacity, name)
+		assert.Positive(t, limit.IP.Capacity, name)
+	}
+}
+
+func TestRemoteIP(t *testing.T) {
+	t.Parallel()
+
+	tt := map[string]struct {
+		forwardedFor string
+		remoteAddr   string
+		want         string
+	}{
+		"direct":           {"", "10.0.0.1:5432", "10.0.0.1"},
+		"behind proxy":     {"203.0.113.7", "10.0.0.1:5432", "203.0.113.7"},
+		"spoofed upstream": {"1.1.1.1, 203.0.113.7", "10.0.0.1:5432", "203.0.113.7"},
+		"no port":          {"", "10.0.0.1", "10.0.0.1"},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			r := httptest.NewRequest("GET", "/v1/health", nil)
+			r.RemoteAddr = tc.remoteAddr
+			if tc.forwardedFor != "" {
+				r.Header.Set("X-Forwarded-For", tc.forwardedFor)
+			}
+
+			assert.Equal(t, tc.want, remoteIP(r))
+		})
+	}
+}
+
+func TestTakeBucketTokens(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+
+	bucket := tokenBucket{Capacity: 2, Refill: time.Second}
+	now := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	take := func(key string, cost int64, at time.Time) time.Duration {
+		t.Helper()
+
+		wait, err := takeBucketTokens(ctx, rdb, key, bucket, cost, at)
+		require.NoError(t, err)
+		return wait
+	}
+
+	// Starts full, and empties one token at a time
+	assert.Zero(t, take("a", 1, now))
+	assert.Zero(t, take("a", 1, now))
+	assert.Equal(t, time.Second, take("a", 1, now))
+
+	// Only has part of a token back after a while
+	assert.Equal(t, 600*time.Millisecond, take("a", 1, now.Add(400*time.Millisecond)))
+
+	// And all of it once Refill passed
+	assert.Zero(t, take("a", 1, now.Add(time.Second)))
+
+	// Never fills up past its capacity
+	assert.Zero(t, take("b", 1, now.Add(time.Hour)))
+	assert.Zero(t, take("b", 1, now.Add(time.Hour)))
+	assert.Equal(t, time.Second, take("b", 1, now.Add(time.Hour)))
+
+	// Tokens can be put back, but not past capacity either
+	assert.Zero(t, take("b", -1, now.Add(time.Hour)))
+	assert.Zero(t, take("b", 1, now.Add(time.Hour)))
+	assert.Zero(t, take("c", -1, now))
+	assert.Zero(t, take("c", 1, now))
+	assert.Zero(t, take("c", 1, now))
+	assert.Equal(t, time.Second, take("c", 1, now))
+
+	// Buckets go away once they'd be full again
+	assert.Equal(t, 2*time.Second, mr.TTL("a"))
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	t.Parallel()
+
+	const apns = "313a182b63224821f5595f42aa019de850a0e7b776253659a9aac8140bb8a3f2"
+
+	newAPI := func(t *testing.T) (*api, *miniredis.Miniredis) {
+		t.Helper()
+
+		mr := miniredis.RunT(t)
+		return &api{
+			logger: zap.NewNop(),
+			redis:  redis.NewClient(&redis.Options{Addr: mr.Addr(), MaxRetries: -1}),
+		}, mr
+	}
+
+	router := func(a *api) *mux.Router {
+		r := mux.NewRouter()
+		r.HandleFunc("/v1/device/{apns}/test", func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}).Methods("POST")
+		r.Use(a.rateLimitMiddleware)
+		r.Use(a.deviceRateLimitMiddleware)
+		return r
+	}
+
+	call := func(r http.Handler) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/v1/device/"+apns+"/test", nil)
+		req.RemoteAddr = "203.0.113.7:5432"
+
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr
+	}
+
+	limit := routeRateLimits["POST /v1/device/{apns}/test"]
+
+	t.Run("over the device limit", func(t *testing.T) {
+		t.Parallel()
+
+		a, mr := newAPI(t)
+		r := router(a)
+
+		for i := int64(0); i < limit.Device.Capacity; i++ {
+			require.Equal(t, http.StatusOK, call(r).Code)
+		}
+
+		rr := call(r)
+		assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+		assert.Equal(t, "12", rr.Header().Get("Retry-After"))
+
+		// The address got back what it was charged for the rejected request
+		tokens := mr.HGet("ratelimit:POST /v1/device/{apns}/test:ip:203.0.113.7", "tokens")
+		assert.InDelta(t, float64(limit.IP.Capacity-limit.Device.Capacity), parseTokens(t, tokens), 0.5)
+	})
+
+	t.Run("fails open", func(t *testing.T) {
+		t.Parallel()
+
+		a, mr := newAPI(t)
+		r := router(a)
+		mr.Close()
+
+		for i := int64(0); i <= limit.Device.Capacity; i++ {
+			assert.Equal(t, http.StatusOK, call(r).Code)
+		}
+	})
+}
+
+// parseTokens reads how many tokens a bucket has left, which may be fractional.
+func parseTokens(t *testing.T, tokens string) float64 {
+	t.Helper()
+
+	var f float64
+	_, err := fmt.Sscan(tokens, &f)
+	require.NoError(t, err)
+	return f
+}
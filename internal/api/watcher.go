@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"strconv"
 	"strings"
@@ -14,136 +15,326 @@ import (
 	"github.com/gorilla/mux"
 
 	"github.com/christianselig/apollo-backend/internal/domain"
+	"github.com/christianselig/apollo-backend/internal/matcher"
 	"github.com/christianselig/apollo-backend/internal/reddit"
 )
 
+var errUserFollowersDisabled = errors.New("user has followers disabled")
+
 type watcherCriteria struct {
-	Author    string
-	Subreddit string
-	Upvotes   int64
-	Keyword   string
-	Flair     string
-	Domain    string
+	Author    string `json:"author,omitempty"`
+	Subreddit string `json:"subreddit,omitempty"`
+	Upvotes   int64  `json:"upvotes,omitempty"`
+	Keyword   string `json:"keyword,omitempty"`
+	Flair     string `json:"flair,omitempty"`
+	Domain    string `json:"domain,omitempty"` // one or more comma separated hosts
+
+	// Post metadata: media is "image", "video" or "gallery", kind is "self" or
+	// "link", nsfw is "only" or "exclude". MaxAge is in seconds.
+	Media       string `json:"media,omitempty"`
+	Kind        string `json:"kind,omitempty"`
+	NSFW        string `json:"nsfw,omitempty"`
+	MinComments int64  `json:"min_comments,omitempty"`
+	MaxAge      int64  `json:"max_age,omitempty"`
+}
+
+// newWatcherCriteria turns a watcher's criteria back into their request form.
+func newWatcherCriteria(watcher domain.Watcher) watcherCriteria {
+	wc := watcherCriteria{
+		Author:      watcher.Author,
+		Subreddit:   watcher.Subreddit,
+		Upvotes:     watcher.Upvotes,
+		Keyword:     watcher.Keyword,
+		Flair:       watcher.Flair,
+		Domain:      watcher.Domain,
+		MinComments: watcher.MinComments,
+		MaxAge:      int64(watcher.MaxAge.Seconds()),
+	}
+
+	if watcher.MediaKind != domain.AnyWatcherMedia {
+		wc.Media = watcher.MediaKind.String()
+	}
+	if watcher.PostKind != domain.AnyWatcherPostKind {
+		wc.Kind = watcher.PostKind.String()
+	}
+	if watcher.NSFW != domain.AnyWatcherNSFW {
+		wc.NSFW = watcher.NSFW.String()
+	}
+
+	return wc
+}
+
+func (wc watcherCriteria) Validate() error {
+	return validation.ValidateStruct(&wc,
+		validation.Field(&wc.Domain, validation.By(domain.ValidWatcherDomains)),
+		validation.Field(&wc.Media, validation.In("image", "video", "gallery")),
+		validation.Field(&wc.Kind, validation.In("self", "link")),
+		validation.Field(&wc.NSFW, validation.In("only", "exclude")),
+		validation.Field(&wc.MinComments, validation.Min(int64(0))),
+		validation.Field(&wc.MaxAge, validation.Min(int64(0)), validation.Max(int64(domain.MaxWatcherPostAge.Seconds()))),
+	)
+}
+
+func (wc watcherCriteria) apply(watcher *domain.Watcher) {
+	watcher.Author = strings.ToLower(wc.Author)
+	watcher.Subreddit = strings.ToLower(wc.Subreddit)
+	watcher.Upvotes = wc.Upvotes
+	watcher.Keyword = strings.ToLower(wc.Keyword)
+	watcher.Flair = strings.ToLower(wc.Flair)
+	watcher.Domain = strings.ToLower(wc.Domain)
+	wc.applyPostMetadata(watcher)
+}
+
+func (wc watcherCriteria) applyPostMetadata(watcher *domain.Watcher) {
+	switch wc.Media {
+	case "image":
+		watcher.MediaKind = domain.ImageWatcherMedia
+	case "video":
+		watcher.MediaKind = domain.VideoWatcherMedia
+	case "gallery":
+		watcher.MediaKind = domain.GalleryWatcherMedia
+	default:
+		watcher.MediaKind = domain.AnyWatcherMedia
+	}
+
+	switch wc.Kind {
+	case "self":
+		watcher.PostKind = domain.SelfWatcherPostKind
+	case "link":
+		watcher.PostKind = domain.LinkWatcherPostKind
+	default:
+		watcher.PostKind = domain.AnyWatcherPostKind
+	}
+
+	switch wc.NSFW {
+	case "only":
+		watcher.NSFW = domain.OnlyWatcherNSFW
+	case "exclude":
+		watcher.NSFW = domain.ExcludeWatcherNSFW
+	default:
+		watcher.NSFW = domain.AnyWatcherNSFW
+	}
+
+	watcher.MinComments = wc.MinComments
+	watcher.MaxAge = time.Duration(wc.MaxAge) * time.Second
+}
+
+// watcherRateLimit caps how often a watcher may notify. Durations are in seconds.
+type watcherRateLimit struct {
+	MaxNotifications int64 `json:"max_notifications,omitempty"`
+	Window           int64 `json:"window,omitempty"`
+	Cooldown         int64 `json:"cooldown,omitempty"`
+	Summarize        bool  `json:"summarize,omitempty"`
+}
+
+func (wrl watcherRateLimit) Validate() error {
+	return validation.ValidateStruct(&wrl,
+		validation.Field(&wrl.MaxNotifications, validation.Min(int64(0))),
+		// A capped watcher without a window would never keep count
+		validation.Field(&wrl.Window, validation.Required.When(wrl.MaxNotifications > 0), validation.Min(int64(0))),
+		validation.Field(&wrl.Cooldown, validation.Min(int64(0))),
+	)
+}
+
+func (wrl watcherRateLimit) apply(watcher *domain.Watcher) {
+	watcher.MaxNotifications = wrl.MaxNotifications
+	watcher.NotificationWindow = time.Duration(wrl.Window) * time.Second
+	watcher.Cooldown = time.Duration(wrl.Cooldown) * time.Second
+	watcher.SummarizeSuppressed = wrl.Summarize
 }
 
 type createWatcherRequest struct {
-	Type      string
-	User      string
-	Subreddit string
-	Label     string
-	Criteria  watcherCriteria
+	Type       string           `json:"type"`
+	User       string           `json:"user,omitempty"`
+	Subreddit  string           `json:"subreddit,omitempty"`
+	Subreddits []string         `json:"subreddits,omitempty"`
+	Feed       string           `json:"feed,omitempty"`
+	Label      string           `json:"label"`
+	Criteria   watcherCriteria  `json:"criteria"`
+	RateLimit  watcherRateLimit `json:"rate_limit"`
+
+	// Only used by trending watchers: "low", "normal" or "high"
+	Sensitivity string `json:"sensitivity,omitempty"`
+
+	// Only used by user watchers: "posts", "comments" or "both"
+	Activity string `json:"activity,omitempty"`
+
+	SnoozedUntil   time.Time `json:"snoozed_until"`
+	ExpiresAt      time.Time `json:"expires_at"`
+	NotifyOnExpiry bool      `json:"notify_on_expiry,omitempty"`
+
+	// Whether to push once when whatever the watcher watches stops being watchable
+	NotifyOnBroken bool `json:"notify_on_broken,omitempty"`
 }
 
 func (cwr *createWatcherRequest) Validate() error {
-	return validation.ValidateStruct(cwr,
+	if err := validation.ValidateStruct(cwr,
 		validation.Field(&cwr.Type, validation.Required),
 		validation.Field(&cwr.User, validation.Required.When(cwr.Type == "user")),
 		validation.Field(&cwr.Subreddit, validation.Required.When(cwr.Type == "subreddit" || cwr.Type == "trending")),
+		validation.Field(&cwr.Subreddits, validation.Required.When(cwr.Type == "multi"), validation.Length(2, domain.MaxMultiSubredditWatcherSubreddits)),
+		validation.Field(&cwr.Feed, validation.Required.When(cwr.Type == "feed")),
+		validation.Field(&cwr.Sensitivity, validation.In("low", "normal", "high")),
+		validation.Field(&cwr.Activity, validation.In("posts", "comments", "both")),
+		validation.Field(&cwr.Criteria, validation.By(func(interface{}) error {
+			// Feeds are far too busy to notify on every post
+			if cwr.Type == "feed" && cwr.Criteria.Keyword == "" {
+				return errors.New("feed watchers require a keyword")
+			}
+			return nil
+		})),
+		validation.Field(&cwr.RateLimit),
+	); err != nil {
+		return err
+	}
+
+	return cwr.validateSchedule()
+}
+
+func (cwr *createWatcherRequest) validateSchedule() error {
+	now := time.Now()
+
+	return validation.ValidateStruct(cwr,
+		validation.Field(&cwr.SnoozedUntil, validation.Max(now.Add(domain.MaxWatcherSnooze))),
+		validation.Field(&cwr.ExpiresAt, validation.Min(now)),
 	)
 }
 
-type watcherCreatedResponse struct {
-	ID int64 `json:"id"`
+func (cwr *createWatcherRequest) trendingSensitivity() domain.TrendingSensitivity {
+	switch cwr.Sensitivity {
+	case "low":
+		return domain.LowTrendingSensitivity
+	case "high":
+		return domain.HighTrendingSensitivity
+	default:
+		return domain.NormalTrendingSensitivity
+	}
 }
 
-func (a *api) createWatcherHandler(w http.ResponseWriter, r *http.Request) {
-	ctx, cancel := context.WithCancel(r.Context())
-	defer cancel()
+func (cwr *createWatcherRequest) userActivity() domain.UserWatcherActivity {
+	switch cwr.Activity {
+	case "comments":
+		return domain.UserWatcherComments
+	case "both":
+		return domain.UserWatcherPostsAndComments
+	default:
+		return domain.UserWatcherPosts
+	}
+}
 
-	vars := mux.Vars(r)
-	apns := vars["apns"]
-	redditID := vars["redditID"]
+func (cwr *createWatcherRequest) applySchedule(watcher *domain.Watcher) {
+	watcher.SnoozedUntil = cwr.SnoozedUntil
+	watcher.ExpiresAt = cwr.ExpiresAt
+	watcher.NotifyOnExpiry = cwr.NotifyOnExpiry
+	watcher.NotifyOnBroken = cwr.NotifyOnBroken
+}
 
-	cwr := &createWatcherRequest{
-		Criteria: watcherCriteria{},
+// applyScheduleEdit is applySchedule for edits. Unlike the rest of the request,
+// schedule fields that are left out keep what the watcher had, and null clears them.
+func (cwr *createWatcherRequest) applyScheduleEdit(watcher *domain.Watcher, fields map[string]json.RawMessage) {
+	if _, ok := fields["snoozed_until"]; ok {
+		watcher.SnoozedUntil = cwr.SnoozedUntil
 	}
-	if err := json.NewDecoder(r.Body).Decode(cwr); err != nil {
-		a.errorResponse(w, r, 500, err)
-		return
+	if _, ok := fields["expires_at"]; ok {
+		watcher.ExpiresAt = cwr.ExpiresAt
 	}
+	if _, ok := fields["notify_on_expiry"]; ok {
+		watcher.NotifyOnExpiry = cwr.NotifyOnExpiry
+	}
+	if _, ok := fields["notify_on_broken"]; ok {
+		watcher.NotifyOnBroken = cwr.NotifyOnBroken
+	}
+}
 
-	if err := cwr.Validate(); err != nil {
-		a.errorResponse(w, r, 422, err)
-		return
+// normalizedSubreddits returns the lowercased, deduplicated subreddits of a multi-subreddit watcher.
+func (cwr *createWatcherRequest) normalizedSubreddits() []string {
+	seen := map[string]bool{}
+	names := make([]string, 0, len(cwr.Subreddits))
+	for _, name := range cwr.Subreddits {
+		name = strings.ToLower(name)
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
 	}
+	return names
+}
 
+// watchableSubreddit checks that a subreddit can be watched and makes sure we have it stored.
+func (a *api) watchableSubreddit(ctx context.Context, ac *reddit.AuthenticatedClient, name string) (domain.Subreddit, int, error) {
+	srr, err := ac.SubredditAbout(ctx, name)
+	if err != nil {
+		switch err {
+		case reddit.ErrSubredditIsPrivate, reddit.ErrSubredditIsQuarantined:
+			return domain.Subreddit{}, 403, fmt.Errorf("error watching %s: %w", name, err)
+		default:
+			return domain.Subreddit{}, 422, err
+		}
+	}
+
+	if !srr.Public {
+		return domain.Subreddit{}, 403, fmt.Errorf("error watching %s: %w", name, reddit.ErrSubredditIsPrivate)
+	}
+
+	sr, err := a.subredditRepo.GetByName(ctx, name)
+	if err != nil {
+		if err != domain.ErrNotFound {
+			return domain.Subreddit{}, 500, err
+		}
+
+		// Might be that we don't know about that subreddit yet
+		sr = domain.Subreddit{SubredditID: srr.ID, Name: srr.Name}
+		if err := a.subredditRepo.CreateOrUpdate(ctx, &sr); err != nil {
+			return domain.Subreddit{}, 500, err
+		}
+	}
+
+	return sr, 200, nil
+}
+
+// watcherDeviceAccount looks up the device and the reddit account on it that a watcher belongs to.
+func (a *api) watcherDeviceAccount(ctx context.Context, apns, redditID string) (domain.Device, domain.Account, int, error) {
 	dev, err := a.deviceRepo.GetByAPNSToken(ctx, apns)
 	if err != nil {
-		a.errorResponse(w, r, 422, err)
-		return
+		return domain.Device{}, domain.Account{}, 422, err
 	}
 
 	accs, err := a.accountRepo.GetByAPNSToken(ctx, apns)
 	if err != nil {
-		a.errorResponse(w, r, 422, err)
-		return
+		return domain.Device{}, domain.Account{}, 422, err
 	}
 
 	if len(accs) == 0 {
-		err := errors.New("cannot create watchers without account")
-		a.errorResponse(w, r, 422, err)
-		return
+		return domain.Device{}, domain.Account{}, 422, errors.New("cannot create watchers without account")
 	}
 
-	account := accs[0]
-	found := false
 	for _, acc := range accs {
 		if acc.AccountID == redditID {
-			found = true
-			account = acc
+			return dev, acc, 200, nil
 		}
 	}
 
-	if !found {
-		err := errors.New("account not associated with device")
-		a.errorResponse(w, r, 401, err)
-		return
-	}
+	return domain.Device{}, domain.Account{}, 401, errors.New("account not associated with device")
+}
 
+// newWatcher builds a watcher for the device and account from a request, resolving
+// whatever it watches through the reddit API. On failure it returns the HTTP status to use.
+func (a *api) newWatcher(ctx context.Context, cwr *createWatcherRequest, dev domain.Device, account domain.Account) (domain.Watcher, int, error) {
 	watcher := domain.Watcher{
 		Label:     cwr.Label,
 		DeviceID:  dev.ID,
 		AccountID: account.ID,
-		Author:    strings.ToLower(cwr.Criteria.Author),
-		Subreddit: strings.ToLower(cwr.Criteria.Subreddit),
-		Upvotes:   cwr.Criteria.Upvotes,
-		Keyword:   strings.ToLower(cwr.Criteria.Keyword),
-		Flair:     strings.ToLower(cwr.Criteria.Flair),
-		Domain:    strings.ToLower(cwr.Criteria.Domain),
 	}
+	cwr.Criteria.apply(&watcher)
+	cwr.RateLimit.apply(&watcher)
+	cwr.applySchedule(&watcher)
 
 	if cwr.Type == "subreddit" || cwr.Type == "trending" {
 		ac := a.reddit.NewAuthenticatedClient(account.AccountID, account.RefreshToken, account.AccessToken)
-		srr, err := ac.SubredditAbout(ctx, cwr.Subreddit)
+		sr, status, err := a.watchableSubreddit(ctx, ac, cwr.Subreddit)
 		if err != nil {
-			a.errorResponse(w, r, 500, err)
-			return
-		}
-		if !srr.Public {
-			a.errorResponse(w, r, 403, reddit.ErrSubredditIsPrivate)
-			return
-		} else if err != nil {
-			switch err {
-			case reddit.ErrSubredditIsPrivate, reddit.ErrSubredditIsQuarantined:
-				err = fmt.Errorf("error watching %s: %w", cwr.Subreddit, err)
-				a.errorResponse(w, r, 403, err)
-			default:
-				a.errorResponse(w, r, 422, err)
-			}
-			return
-		}
-
-		sr, err := a.subredditRepo.GetByName(ctx, cwr.Subreddit)
-		if err != nil {
-			switch err {
-			case domain.ErrNotFound:
-				// Might be that we don't know about that subreddit yet
-				sr = domain.Subreddit{SubredditID: srr.ID, Name: srr.Name}
-				_ = a.subredditRepo.CreateOrUpdate(ctx, &sr)
-			default:
-				a.errorResponse(w, r, 500, err)
-				return
-			}
+			return domain.Watcher{}, status, err
 		}
 
 		switch cwr.Type {
@@ -152,6 +343,7 @@ func (a *api) createWatcherHandler(w http.ResponseWriter, r *http.Request) {
 		case "trending":
 			watcher.Label = "trending"
 			watcher.Type = domain.TrendingWatcher
+			watcher.TrendingSensitivity = cwr.trendingSensitivity()
 		}
 
 		watcher.WatcheeID = sr.ID
@@ -159,32 +351,99 @@ func (a *api) createWatcherHandler(w http.ResponseWriter, r *http.Request) {
 		ac := a.reddit.NewAuthenticatedClient(account.AccountID, account.RefreshToken, account.AccessToken)
 		urr, err := ac.UserAbout(ctx, cwr.User)
 		if err != nil {
-			a.errorResponse(w, r, 500, err)
-			return
+			return domain.Watcher{}, 500, err
 		}
 
 		if !urr.AcceptFollowers {
-			err := errors.New("user has followers disabled")
-			a.errorResponse(w, r, 403, err)
-			return
+			return domain.Watcher{}, 403, errUserFollowersDisabled
 		}
 
 		u := domain.User{UserID: urr.ID, Name: urr.Name}
 		err = a.userRepo.CreateOrUpdate(ctx, &u)
 
 		if err != nil {
-			a.errorResponse(w, r, 500, err)
-			return
+			return domain.Watcher{}, 500, err
 		}
 
 		watcher.Type = domain.UserWatcher
 		watcher.WatcheeID = u.ID
+		watcher.UserActivity = cwr.userActivity()
+	} else if cwr.Type == "multi" {
+		ac := a.reddit.NewAuthenticatedClient(account.AccountID, account.RefreshToken, account.AccessToken)
+		for _, name := range cwr.normalizedSubreddits() {
+			sr, status, err := a.watchableSubreddit(ctx, ac, name)
+			if err != nil {
+				return domain.Watcher{}, status, err
+			}
+
+			watcher.Subreddits = append(watcher.Subreddits, sr)
+		}
+
+		watcher.Type = domain.MultiSubredditWatcher
+	} else if cwr.Type == "feed" {
+		feed := domain.Feed{Path: cwr.Feed}
+		if err := feed.Validate(); err != nil {
+			return domain.Watcher{}, 422, err
+		}
+
+		// Make sure the feed exists and the account can see it
+		ac := a.reddit.NewAuthenticatedClient(account.AccountID, account.RefreshToken, account.AccessToken)
+		if _, err := ac.FeedNew(ctx, feed.NormalizedPath(), reddit.WithQuery("limit", "1")); err != nil {
+			err = fmt.Errorf("error watching %s: %w", feed.NormalizedPath(), err)
+			return domain.Watcher{}, 422, err
+		}
+
+		if err := a.feedRepo.CreateOrUpdate(ctx, &feed); err != nil {
+			return domain.Watcher{}, 500, err
+		}
+
+		watcher.Type = domain.FeedWatcher
+		watcher.WatcheeID = feed.ID
 	} else {
 		err := fmt.Errorf("unknown watcher type: %s", cwr.Type)
+		return domain.Watcher{}, 422, err
+	}
+
+	return watcher, 200, nil
+}
+
+type watcherCreatedResponse struct {
+	ID int64 `json:"id"`
+}
+
+func (a *api) createWatcherHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	vars := mux.Vars(r)
+	apns := vars["apns"]
+	redditID := vars["redditID"]
+
+	cwr := &createWatcherRequest{
+		Criteria: watcherCriteria{},
+	}
+	if err := json.NewDecoder(r.Body).Decode(cwr); err != nil {
+		a.errorResponse(w, r, 400, err)
+		return
+	}
+
+	if err := cwr.Validate(); err != nil {
 		a.errorResponse(w, r, 422, err)
 		return
 	}
 
+	dev, account, status, err := a.watcherDeviceAccount(ctx, apns, redditID)
+	if err != nil {
+		a.errorResponse(w, r, status, err)
+		return
+	}
+
+	watcher, status, err := a.newWatcher(ctx, cwr, dev, account)
+	if err != nil {
+		a.errorResponse(w, r, status, err)
+		return
+	}
+
 	if err := a.watcherRepo.Create(ctx, &watcher); err != nil {
 		a.errorResponse(w, r, 422, err)
 		return
@@ -195,6 +454,155 @@ func (a *api) createWatcherHandler(w http.ResponseWriter, r *http.Request) {
 	_ = json.NewEncoder(w).Encode(watcherCreatedResponse{ID: watcher.ID})
 }
 
+type watcherPreviewCheck struct {
+	Criterion string `json:"criterion"`
+	Expected  string `json:"expected"`
+	Actual    string `json:"actual"`
+	Matched   bool   `json:"matched"`
+}
+
+type watcherPreviewPost struct {
+	ID        string                `json:"id"`
+	Title     string                `json:"title"`
+	Subreddit string                `json:"subreddit"`
+	Author    string                `json:"author"`
+	Score     int64                 `json:"score"`
+	CreatedAt time.Time             `json:"created_at"`
+	Checks    []watcherPreviewCheck `json:"checks"`
+}
+
+type watcherPreviewResponse struct {
+	Evaluated int                  `json:"evaluated"`
+	Matches   []watcherPreviewPost `json:"matches"`
+}
+
+func (a *api) previewWatcherHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	vars := mux.Vars(r)
+	apns := vars["apns"]
+	redditID := vars["redditID"]
+
+	cwr := &createWatcherRequest{
+		Criteria: watcherCriteria{},
+	}
+	if err := json.NewDecoder(r.Body).Decode(cwr); err != nil {
+		a.errorResponse(w, r, 400, err)
+		return
+	}
+
+	if err := cwr.Validate(); err != nil {
+		a.errorResponse(w, r, 422, err)
+		return
+	}
+
+	_, account, status, err := a.watcherDeviceAccount(ctx, apns, redditID)
+	if err != nil {
+		a.errorResponse(w, r, status, err)
+		return
+	}
+
+	watcher := domain.Watcher{Label: cwr.Label}
+	cwr.Criteria.apply(&watcher)
+
+	ac := a.reddit.NewAuthenticatedClient(account.AccountID, account.RefreshToken, account.AccessToken)
+	opts := []reddit.RequestOption{
+		reddit.WithQuery("limit", "100"),
+		reddit.WithQuery("show", "all"),
+		reddit.WithQuery("always_show_media", "1"),
+	}
+
+	var posts *reddit.ListingResponse
+
+	switch cwr.Type {
+	case "subreddit":
+		watcher.Type = domain.SubredditWatcher
+		posts, err = ac.SubredditNew(ctx, cwr.Subreddit, opts...)
+	case "multi":
+		// Reddit serves the combined listing of several subreddits joined with a plus
+		watcher.Type = domain.MultiSubredditWatcher
+		posts, err = ac.SubredditNew(ctx, strings.Join(cwr.normalizedSubreddits(), "+"), opts...)
+	case "feed":
+		feed := domain.Feed{Path: cwr.Feed}
+		if err := feed.Validate(); err != nil {
+			a.errorResponse(w, r, 422, err)
+			return
+		}
+
+		watcher.Type = domain.FeedWatcher
+		posts, err = ac.FeedNew(ctx, feed.NormalizedPath(), opts...)
+	case "user":
+		watcher.Type = domain.UserWatcher
+		watcher.UserActivity = cwr.userActivity()
+
+		posts = &reddit.ListingResponse{}
+		if watcher.UserActivity.IncludesPosts() {
+			var ups *reddit.ListingResponse
+			if ups, err = ac.UserPosts(ctx, cwr.User); err == nil {
+				posts.Children = append(posts.Children, ups.Children...)
+			}
+		}
+		if err == nil && watcher.UserActivity.IncludesComments() {
+			var ucs *reddit.ListingResponse
+			if ucs, err = ac.UserComments(ctx, cwr.User); err == nil {
+				posts.Children = append(posts.Children, ucs.Children...)
+			}
+		}
+	default:
+		err := fmt.Errorf("cannot preview %s watchers", cwr.Type)
+		a.errorResponse(w, r, 422, err)
+		return
+	}
+
+	if err != nil {
+		switch err {
+		case reddit.ErrSubredditIsPrivate, reddit.ErrSubredditIsQuarantined:
+			a.errorResponse(w, r, 403, err)
+		default:
+			a.errorResponse(w, r, 422, err)
+		}
+		return
+	}
+
+	wpr := watcherPreviewResponse{
+		Evaluated: len(posts.Children),
+		Matches:   []watcherPreviewPost{},
+	}
+
+	for _, post := range posts.Children {
+		res := matcher.Match(&watcher, post)
+		if !res.Matched {
+			continue
+		}
+
+		title := post.Title
+		if post.Kind == "t1" {
+			title = post.LinkTitle
+		}
+
+		wpp := watcherPreviewPost{
+			ID:        post.ID,
+			Title:     title,
+			Subreddit: post.Subreddit,
+			Author:    post.Author,
+			Score:     post.Score,
+			CreatedAt: post.CreatedAt,
+			Checks:    make([]watcherPreviewCheck, len(res.Checks)),
+		}
+
+		for i, check := range res.Checks {
+			wpp.Checks[i] = watcherPreviewCheck(check)
+		}
+
+		wpr.Matches = append(wpr.Matches, wpp)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(wpr)
+}
+
 func (a *api) editWatcherHandler(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithCancel(r.Context())
 	defer cancel()
@@ -224,8 +632,36 @@ func (a *api) editWatcherHandler(w http.ResponseWriter, r *http.Request) {
 		Criteria: watcherCriteria{},
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(ewr); err != nil {
-		a.errorResponse(w, r, 500, err)
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		a.errorResponse(w, r, 400, err)
+		return
+	}
+
+	if err := json.Unmarshal(body, ewr); err != nil {
+		a.errorResponse(w, r, 400, err)
+		return
+	}
+
+	// Tells a schedule that was left out apart from one that was cleared
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		a.errorResponse(w, r, 400, err)
+		return
+	}
+
+	if err := ewr.validateSchedule(); err != nil {
+		a.errorResponse(w, r, 422, err)
+		return
+	}
+
+	if err := validation.ValidateStruct(ewr,
+		validation.Field(&ewr.Sensitivity, validation.In("low", "normal", "high")),
+		validation.Field(&ewr.Activity, validation.In("posts", "comments", "both")),
+		validation.Field(&ewr.Criteria),
+		validation.Field(&ewr.RateLimit),
+	); err != nil {
+		a.errorResponse(w, r, 422, err)
 		return
 	}
 
@@ -236,6 +672,21 @@ func (a *api) editWatcherHandler(w http.ResponseWriter, r *http.Request) {
 	watcher.Keyword = strings.ToLower(ewr.Criteria.Keyword)
 	watcher.Flair = strings.ToLower(ewr.Criteria.Flair)
 	watcher.Domain = strings.ToLower(ewr.Criteria.Domain)
+	ewr.Criteria.applyPostMetadata(&watcher)
+	ewr.applyScheduleEdit(&watcher, fields)
+
+	// Like schedules, rate limits left out of an edit are kept
+	if _, ok := fields["rate_limit"]; ok {
+		ewr.RateLimit.apply(&watcher)
+	}
+
+	if watcher.Type == domain.TrendingWatcher && ewr.Sensitivity != "" {
+		watcher.TrendingSensitivity = ewr.trendingSensitivity()
+	}
+
+	if watcher.Type == domain.UserWatcher && ewr.Activity != "" {
+		watcher.UserActivity = ewr.userActivity()
+	}
 
 	if watcher.Type == domain.SubredditWatcher {
 		lsr := strings.ToLower(watcher.Subreddit)
@@ -300,6 +751,29 @@ func (a *api) editWatcherHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	if watcher.Type == domain.MultiSubredditWatcher && len(ewr.Subreddits) > 0 {
+		if watcher.Account.AccountID != rid {
+			err := fmt.Errorf("wrong account for watcher %d", watcher.ID)
+			a.errorResponse(w, r, 422, err)
+			return
+		}
+
+		ac := a.reddit.NewAuthenticatedClient(watcher.Account.AccountID, watcher.Account.RefreshToken, watcher.Account.AccessToken)
+
+		subreddits := []domain.Subreddit{}
+		for _, name := range ewr.normalizedSubreddits() {
+			sr, status, err := a.watchableSubreddit(ctx, ac, name)
+			if err != nil {
+				a.errorResponse(w, r, status, err)
+				return
+			}
+
+			subreddits = append(subreddits, sr)
+		}
+
+		watcher.Subreddits = subreddits
+	}
+
 	if err := a.watcherRepo.Update(ctx, &watcher); err != nil {
 		a.errorResponse(w, r, 500, err)
 		return
@@ -333,6 +807,94 @@ func (a *api) deleteWatcherHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+type watcherHitItem struct {
+	ID        int64     `json:"id"`
+	PostID    string    `json:"post_id"`
+	Title     string    `json:"title"`
+	Subreddit string    `json:"subreddit"`
+	Author    string    `json:"author"`
+	MatchedAt time.Time `json:"matched_at"`
+	Status    string    `json:"status"`
+}
+
+type watcherHitsResponse struct {
+	Hits []watcherHitItem `json:"hits"`
+
+	// Pass as before to get the next page, missing once there's nothing left
+	Before int64 `json:"before,omitempty"`
+}
+
+func (a *api) listWatcherHitsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	vars := mux.Vars(r)
+	id, err := strconv.ParseInt(vars["watcherID"], 10, 64)
+	if err != nil {
+		a.errorResponse(w, r, 422, err)
+		return
+	}
+
+	var before int64
+	if val := r.URL.Query().Get("before"); val != "" {
+		if before, err = strconv.ParseInt(val, 10, 64); err != nil {
+			a.errorResponse(w, r, 422, err)
+			return
+		}
+	}
+
+	limit := domain.DefaultWatcherHitsLimit
+	if val := r.URL.Query().Get("limit"); val != "" {
+		if limit, err = strconv.Atoi(val); err != nil {
+			a.errorResponse(w, r, 422, err)
+			return
+		}
+	}
+
+	if limit < 1 || limit > domain.MaxWatcherHitsLimit {
+		err := fmt.Errorf("limit must be between 1 and %d", domain.MaxWatcherHitsLimit)
+		a.errorResponse(w, r, 422, err)
+		return
+	}
+
+	watcher, err := a.watcherRepo.GetByID(ctx, id)
+	if err != nil {
+		a.errorResponse(w, r, 422, err)
+		return
+	} else if watcher.Device.APNSToken != vars["apns"] {
+		err := fmt.Errorf("wrong device for watcher %d", watcher.ID)
+		a.errorResponse(w, r, 422, err)
+		return
+	}
+
+	hits, err := a.watcherRepo.GetHits(ctx, watcher.ID, before, limit)
+	if err != nil {
+		a.errorResponse(w, r, 500, err)
+		return
+	}
+
+	whr := watcherHitsResponse{Hits: make([]watcherHitItem, len(hits))}
+	for i, hit := range hits {
+		whr.Hits[i] = watcherHitItem{
+			ID:        hit.ID,
+			PostID:    hit.PostID,
+			Title:     hit.Title,
+			Subreddit: hit.Subreddit,
+			Author:    hit.Author,
+			MatchedAt: hit.MatchedAt,
+			Status:    hit.Status.String(),
+		}
+	}
+
+	if len(hits) == limit {
+		whr.Before = hits[len(hits)-1].ID
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(whr)
+}
+
 type watcherItem struct {
 	ID          int64     `json:"id"`
 	CreatedAt   time.Time `json:"created_at"`
@@ -345,6 +907,85 @@ type watcherItem struct {
 	Domain      string    `json:"domain,omitempty"`
 	Hits        int64     `json:"hits"`
 	Author      string    `json:"author,omitempty"`
+	Subreddits  []string  `json:"subreddits,omitempty"`
+	Sensitivity string    `json:"sensitivity,omitempty"`
+	Activity    string    `json:"activity,omitempty"`
+	Media       string    `json:"media,omitempty"`
+	Kind        string    `json:"kind,omitempty"`
+	NSFW        string    `json:"nsfw,omitempty"`
+	MinComments int64     `json:"min_comments,omitempty"`
+	MaxAge      int64     `json:"max_age,omitempty"`
+
+	MaxNotifications    int64 `json:"max_notifications,omitempty"`
+	NotificationWindow  int64 `json:"notification_window,omitempty"`
+	Cooldown            int64 `json:"cooldown,omitempty"`
+	SummarizeSuppressed bool  `json:"summarize_suppressed,omitempty"`
+
+	SnoozedUntil   *time.Time `json:"snoozed_until,omitempty"`
+	ExpiresAt      *time.Time `json:"expires_at,omitempty"`
+	NotifyOnExpiry bool       `json:"notify_on_expiry,omitempty"`
+
+	// Set when the watcher stopped working, see domain.WatcherBrokenReason
+	BrokenReason   string     `json:"broken_reason,omitempty"`
+	BrokenAt       *time.Time `json:"broken_at,omitempty"`
+	NotifyOnBroken bool       `json:"notify_on_broken,omitempty"`
+}
+
+// newWatcherItem describes a watcher the way it's listed to the app.
+func newWatcherItem(watcher domain.Watcher, now time.Time) watcherItem {
+	wc := newWatcherCriteria(watcher)
+	wi := watcherItem{
+		ID:          watcher.ID,
+		CreatedAt:   watcher.CreatedAt,
+		Type:        watcher.Type.String(),
+		Label:       watcher.Label,
+		SourceLabel: watcher.WatcheeLabel,
+		Keyword:     watcher.Keyword,
+		Flair:       watcher.Flair,
+		Domain:      watcher.Domain,
+		Hits:        watcher.Hits,
+		Author:      watcher.Author,
+		Upvotes:     watcher.Upvotes,
+		Media:       wc.Media,
+		Kind:        wc.Kind,
+		NSFW:        wc.NSFW,
+		MinComments: wc.MinComments,
+		MaxAge:      wc.MaxAge,
+
+		MaxNotifications:    watcher.MaxNotifications,
+		NotificationWindow:  int64(watcher.NotificationWindow.Seconds()),
+		Cooldown:            int64(watcher.Cooldown.Seconds()),
+		SummarizeSuppressed: watcher.SummarizeSuppressed,
+
+		NotifyOnExpiry: watcher.NotifyOnExpiry,
+		NotifyOnBroken: watcher.NotifyOnBroken,
+	}
+
+	if brokenAt := watcher.BrokenAt; watcher.Broken() {
+		wi.BrokenReason = watcher.BrokenReason.String()
+		wi.BrokenAt = &brokenAt
+	}
+
+	if snoozedUntil := watcher.SnoozedUntil; watcher.Snoozed(now) {
+		wi.SnoozedUntil = &snoozedUntil
+	}
+
+	if expiresAt := watcher.ExpiresAt; !expiresAt.IsZero() {
+		wi.ExpiresAt = &expiresAt
+	}
+
+	for _, sr := range watcher.Subreddits {
+		wi.Subreddits = append(wi.Subreddits, sr.Name)
+	}
+
+	switch watcher.Type {
+	case domain.TrendingWatcher:
+		wi.Sensitivity = watcher.TrendingSensitivity.String()
+	case domain.UserWatcher:
+		wi.Activity = watcher.UserActivity.String()
+	}
+
+	return wi
 }
 
 func (a *api) listWatchersHandler(w http.ResponseWriter, r *http.Request) {
@@ -360,25 +1001,189 @@ func (a *api) listWatchersHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	now := time.Now()
 	wis := make([]watcherItem, len(watchers))
 	for i, watcher := range watchers {
-		wi := watcherItem{
-			ID:          watcher.ID,
-			CreatedAt:   watcher.CreatedAt,
-			Type:        watcher.Type.String(),
-			Label:       watcher.Label,
-			SourceLabel: watcher.WatcheeLabel,
-			Keyword:     watcher.Keyword,
-			Flair:       watcher.Flair,
-			Domain:      watcher.Domain,
-			Hits:        watcher.Hits,
-			Author:      watcher.Author,
-			Upvotes:     watcher.Upvotes,
+		wis[i] = newWatcherItem(watcher, now)
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(wis)
+}
+
+const (
+	watcherExportVersion = 1
+
+	// Nobody should need more watchers than this on a single account
+	maxImportedWatchers = 100
+)
+
+// watcherExport is a portable copy of an account's watchers, so they can be
+// brought over to a new device once its APNs token changes.
+type watcherExport struct {
+	Version    int                    `json:"version"`
+	ExportedAt time.Time              `json:"exported_at"`
+	Watchers   []createWatcherRequest `json:"watchers"`
+}
+
+// exportedWatcher turns a watcher back into the request that would create it.
+func exportedWatcher(watcher domain.Watcher, now time.Time) createWatcherRequest {
+	cwr := createWatcherRequest{
+		Type:     watcher.Type.String(),
+		Label:    watcher.Label,
+		Criteria: newWatcherCriteria(watcher),
+		RateLimit: watcherRateLimit{
+			MaxNotifications: watcher.MaxNotifications,
+			Window:           int64(watcher.NotificationWindow.Seconds()),
+			Cooldown:         int64(watcher.Cooldown.Seconds()),
+			Summarize:        watcher.SummarizeSuppressed,
+		},
+		ExpiresAt:      watcher.ExpiresAt,
+		NotifyOnExpiry: watcher.NotifyOnExpiry,
+		NotifyOnBroken: watcher.NotifyOnBroken,
+	}
+
+	if watcher.Snoozed(now) {
+		cwr.SnoozedUntil = watcher.SnoozedUntil
+	}
+
+	switch watcher.Type {
+	case domain.SubredditWatcher:
+		cwr.Subreddit = watcher.WatcheeLabel
+	case domain.TrendingWatcher:
+		cwr.Subreddit = watcher.WatcheeLabel
+		cwr.Sensitivity = watcher.TrendingSensitivity.String()
+	case domain.UserWatcher:
+		cwr.User = watcher.WatcheeLabel
+		cwr.Activity = watcher.UserActivity.String()
+	case domain.FeedWatcher:
+		cwr.Feed = watcher.WatcheeLabel
+	case domain.MultiSubredditWatcher:
+		for _, sr := range watcher.Subreddits {
+			cwr.Subreddits = append(cwr.Subreddits, sr.Name)
+		}
+	}
+
+	return cwr
+}
+
+func (a *api) exportWatchersHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	vars := mux.Vars(r)
+	apns := vars["apns"]
+	redditID := vars["redditID"]
+
+	watchers, err := a.watcherRepo.GetByDeviceAPNSTokenAndAccountRedditID(ctx, apns, redditID)
+	if err != nil {
+		a.errorResponse(w, r, 400, err)
+		return
+	}
+
+	now := time.Now()
+	we := watcherExport{
+		Version:    watcherExportVersion,
+		ExportedAt: now,
+		Watchers:   []createWatcherRequest{},
+	}
+
+	for _, watcher := range watchers {
+		// Expired watchers are about to be pruned, no point bringing them along
+		if watcher.Expired(now) {
+			continue
 		}
 
-		wis[i] = wi
+		we.Watchers = append(we.Watchers, exportedWatcher(watcher, now))
 	}
+
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(we)
+}
+
+type watcherImportResult struct {
+	Index int    `json:"index"`
+	Label string `json:"label"`
+	ID    int64  `json:"id,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+type watcherImportResponse struct {
+	Imported int                   `json:"imported"`
+	Failed   int                   `json:"failed"`
+	Results  []watcherImportResult `json:"results"`
+}
+
+func (a *api) importWatchersHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	vars := mux.Vars(r)
+	apns := vars["apns"]
+	redditID := vars["redditID"]
+
+	we := &watcherExport{}
+	if err := json.NewDecoder(r.Body).Decode(we); err != nil {
+		a.errorResponse(w, r, 400, err)
+		return
+	}
+
+	if we.Version != watcherExportVersion {
+		err := fmt.Errorf("unsupported watcher export version: %d", we.Version)
+		a.errorResponse(w, r, 422, err)
+		return
+	}
+
+	if len(we.Watchers) > maxImportedWatchers {
+		err := fmt.Errorf("cannot import more than %d watchers at once", maxImportedWatchers)
+		a.errorResponse(w, r, 422, err)
+		return
+	}
+
+	dev, account, status, err := a.watcherDeviceAccount(ctx, apns, redditID)
+	if err != nil {
+		a.errorResponse(w, r, status, err)
+		return
+	}
+
+	wir := watcherImportResponse{Results: make([]watcherImportResult, len(we.Watchers))}
+
+	// Subreddits and users may have gone private or disappeared since the export,
+	// so every watcher is resolved again and failures don't stop the rest.
+	for i := range we.Watchers {
+		cwr := &we.Watchers[i]
+		res := watcherImportResult{Index: i, Label: cwr.Label}
+
+		watcher, err := a.importWatcher(ctx, cwr, dev, account)
+		if err != nil {
+			res.Error = err.Error()
+			wir.Failed++
+		} else {
+			res.ID = watcher.ID
+			wir.Imported++
+		}
+
+		wir.Results[i] = res
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(wis)
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(wir)
+}
+
+func (a *api) importWatcher(ctx context.Context, cwr *createWatcherRequest, dev domain.Device, account domain.Account) (domain.Watcher, error) {
+	if err := cwr.Validate(); err != nil {
+		return domain.Watcher{}, err
+	}
+
+	watcher, _, err := a.newWatcher(ctx, cwr, dev, account)
+	if err != nil {
+		return domain.Watcher{}, err
+	}
+
+	if err := a.watcherRepo.Create(ctx, &watcher); err != nil {
+		return domain.Watcher{}, err
+	}
+
+	return watcher, nil
 }
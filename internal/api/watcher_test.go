@@ -0,0 +1,85 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/christianselig/apollo-backend/internal/domain"
+)
+
+func TestApplyScheduleEdit(t *testing.T) {
+	t.Parallel()
+
+	snoozedUntil := time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC)
+	expiresAt := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	tt := map[string]struct {
+		body         string
+		snoozedUntil time.Time
+		expiresAt    time.Time
+		notify       bool
+	}{
+		"left out":      {`{"label":"deals"}`, snoozedUntil, expiresAt, true},
+		"cleared":       {`{"snoozed_until":null,"expires_at":null}`, time.Time{}, time.Time{}, true},
+		"changed":       {`{"expires_at":"2023-07-01T00:00:00Z","notify_on_expiry":false}`, snoozedUntil, time.Date(2023, 7, 1, 0, 0, 0, 0, time.UTC), false},
+		"only unsnooze": {`{"snoozed_until":null}`, time.Time{}, expiresAt, true},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			watcher := domain.Watcher{SnoozedUntil: snoozedUntil, ExpiresAt: expiresAt, NotifyOnExpiry: true}
+
+			ewr := &createWatcherRequest{}
+			require.NoError(t, json.Unmarshal([]byte(tc.body), ewr))
+
+			var fields map[string]json.RawMessage
+			require.NoError(t, json.Unmarshal([]byte(tc.body), &fields))
+
+			ewr.applyScheduleEdit(&watcher, fields)
+
+			assert.True(t, tc.snoozedUntil.Equal(watcher.SnoozedUntil), "snoozed_until is %v", watcher.SnoozedUntil)
+			assert.True(t, tc.expiresAt.Equal(watcher.ExpiresAt), "expires_at is %v", watcher.ExpiresAt)
+			assert.Equal(t, tc.notify, watcher.NotifyOnExpiry)
+		})
+	}
+}
+
+func TestWatcherRateLimitValidate(t *testing.T) {
+	t.Parallel()
+
+	tt := map[string]struct {
+		rl    watcherRateLimit
+		valid bool
+	}{
+		"none":              {watcherRateLimit{}, true},
+		"capped":            {watcherRateLimit{MaxNotifications: 3, Window: 3600}, true},
+		"cooldown only":     {watcherRateLimit{Cooldown: 600}, true},
+		"capped no window":  {watcherRateLimit{MaxNotifications: 3}, false},
+		"negative max":      {watcherRateLimit{MaxNotifications: -1, Window: 3600}, false},
+		"negative window":   {watcherRateLimit{MaxNotifications: 3, Window: -3600}, false},
+		"negative cooldown": {watcherRateLimit{Cooldown: -1}, false},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			err := tc.rl.Validate()
+			if tc.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
@@ -45,7 +45,18 @@ func APICmd(ctx context.Context) *cobra.Command {
 			}
 			defer redis.Close()
 
-			api := api.NewAPI(ctx, logger, statsd, redis, db)
+			qredis, err := cmdutil.NewRedisQueueClient(ctx, 4)
+			if err != nil {
+				return err
+			}
+			defer qredis.Close()
+
+			queue, err := cmdutil.NewQueueClient(logger, qredis, "api")
+			if err != nil {
+				return err
+			}
+
+			api := api.NewAPI(ctx, logger, statsd, redis, db, queue)
 			srv := api.Server(port)
 
 			go func() { _ = srv.ListenAndServe() }()
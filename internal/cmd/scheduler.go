@@ -2,10 +2,12 @@ package cmd
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"math"
 	"net/http"
 	_ "net/http/pprof"
+	"os"
 	"strconv"
 	"sync"
 	"time"
@@ -15,12 +17,17 @@ import (
 	"github.com/go-co-op/gocron"
 	"github.com/go-redis/redis/v8"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/sideshow/apns2"
+	"github.com/sideshow/apns2/payload"
+	"github.com/sideshow/apns2/token"
 	"github.com/spf13/cobra"
 	"go.uber.org/zap"
 
 	"github.com/christianselig/apollo-backend/internal/cmdutil"
 	"github.com/christianselig/apollo-backend/internal/domain"
 	"github.com/christianselig/apollo-backend/internal/repository"
+	"github.com/christianselig/apollo-backend/internal/tokencrypt"
+	"github.com/christianselig/apollo-backend/internal/worker"
 )
 
 const (
@@ -91,6 +98,11 @@ func SchedulerCmd(ctx context.Context) *cobra.Command {
 				return err
 			}
 
+			feedQueue, err := queue.OpenQueue("feeds")
+			if err != nil {
+				return err
+			}
+
 			userQueue, err := queue.OpenQueue("users")
 			if err != nil {
 				return err
@@ -106,16 +118,53 @@ func SchedulerCmd(ctx context.Context) *cobra.Command {
 				return err
 			}
 
+			milestonesQueue, err := queue.OpenQueue("milestones")
+			if err != nil {
+				return err
+			}
+
+			watcherHealthQueue, err := queue.OpenQueue("watcher-health")
+			if err != nil {
+				return err
+			}
+
+			// Only needed to let devices know about what got pruned, so
+			// everything else still runs without it
+			var apns *token.Token
+			if authKey, err := token.AuthKeyFromFile(os.Getenv("APPLE_KEY_PATH")); err != nil {
+				logger.Warn("could not load apns key, not sending notifications", zap.Error(err))
+			} else {
+				apns = &token.Token{
+					AuthKey: authKey,
+					KeyID:   os.Getenv("APPLE_KEY_ID"),
+					TeamID:  os.Getenv("APPLE_TEAM_ID"),
+				}
+			}
+
+			// Only needed to encrypt live activity tokens stored before we
+			// started doing so
+			tokens, err := tokencrypt.NewFromBase64(os.Getenv("LIVE_ACTIVITY_TOKEN_KEY"))
+			if err != nil {
+				logger.Warn("could not load live activity token key, not encrypting tokens", zap.Error(err))
+			}
+
 			s := gocron.NewScheduler(time.UTC)
 			s.SetMaxConcurrentJobs(8, gocron.WaitMode)
 
 			_, _ = s.Every(5).Seconds().Do(func() { enqueueAccounts(ctx, logger, statsd, db, redis, luaSha, notifQueue) })
 			_, _ = s.Every(5).Seconds().Do(func() { enqueueSubreddits(ctx, logger, statsd, db, []rmq.Queue{subredditQueue, trendingQueue}) })
+			_, _ = s.Every(5).Seconds().Do(func() { enqueueFeeds(ctx, logger, statsd, db, feedQueue) })
 			_, _ = s.Every(5).Seconds().Do(func() { enqueueUsers(ctx, logger, statsd, db, userQueue) })
 			_, _ = s.Every(5).Seconds().Do(func() { enqueueLiveActivities(ctx, logger, db, redis, luaSha, liveActivitiesQueue) })
 			_, _ = s.Every(5).Seconds().Do(func() { cleanQueues(logger, queue) })
 			_, _ = s.Every(5).Seconds().Do(func() { enqueueStuckAccounts(ctx, logger, statsd, db, stuckNotificationsQueue) })
+			_, _ = s.Every(5).Seconds().Do(func() { enqueueMilestoneAccounts(ctx, logger, statsd, db, milestonesQueue) })
 			_, _ = s.Every(1).Minute().Do(func() { reportStats(ctx, logger, statsd, db) })
+			_, _ = s.Every(1).Minute().Do(func() { pruneWatchers(ctx, logger, statsd, db, apns) })
+			_, _ = s.Every(1).Minute().Do(func() { pruneLiveActivities(ctx, logger, statsd, db, redis, apns) })
+			_, _ = s.Every(1).Minute().Do(func() { sealLiveActivityTokens(ctx, logger, statsd, db, tokens) })
+			_, _ = s.Every(1).Minute().Do(func() { enqueueWatcherHealthChecks(ctx, logger, statsd, db, watcherHealthQueue) })
+			_, _ = s.Every(1).Hour().Do(func() { pruneWatcherHits(ctx, logger, statsd, db) })
 			//_, _ = s.Every(1).Minute().Do(func() { pruneAccounts(ctx, logger, db) })
 			//_, _ = s.Every(1).Minute().Do(func() { pruneDevices(ctx, logger, db) })
 			s.StartAsync()
@@ -166,7 +215,7 @@ func enqueueLiveActivities(ctx context.Context, logger *zap.Logger, pool *pgxpoo
 		WHERE id IN (
 			SELECT id
 			FROM live_activities
-			WHERE next_check_at < $1
+			WHERE next_check_at < $1 AND NOT pending
 			ORDER BY next_check_at
 			FOR UPDATE SKIP LOCKED
 			LIMIT 1000
@@ -250,6 +299,249 @@ func pruneDevices(ctx context.Context, logger *zap.Logger, pool *pgxpool.Pool) {
 	}
 }
 
+func pruneWatchers(ctx context.Context, logger *zap.Logger, statsd *statsd.Client, pool *pgxpool.Pool, apns *token.Token) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	wr := repository.NewPostgresWatcher(pool)
+
+	expired, err := wr.DeleteExpired(ctx, time.Now())
+	if err != nil {
+		logger.Error("failed to clean expired watchers", zap.Error(err))
+		return
+	}
+
+	if len(expired) == 0 {
+		return
+	}
+
+	logger.Info("pruned expired watchers", zap.Int("count", len(expired)))
+	_ = statsd.Count("apollo.watcher.expired", int64(len(expired)), []string{}, 1)
+
+	if apns == nil {
+		return
+	}
+
+	production := apns2.NewTokenClient(apns).Production()
+	sandbox := apns2.NewTokenClient(apns)
+
+	for _, watcher := range expired {
+		if !watcher.NotifyOnExpiry {
+			continue
+		}
+
+		notification := &apns2.Notification{}
+		notification.Topic = "com.christianselig.Apollo"
+		notification.DeviceToken = watcher.Device.APNSToken
+		notification.Payload = payload.
+			NewPayload().
+			AlertTitle(fmt.Sprintf("⏰ \u201c%s\u201d Watcher", watcher.Label)).
+			AlertBody("This watcher has expired and was removed.").
+			Category("watcher-expired").
+			Custom("watcher_id", watcher.ID).
+			Custom("watcher_type", watcher.Type.String()).
+			Sound("traloop.wav")
+
+		client := production
+		if watcher.Device.Sandbox {
+			client = sandbox
+		}
+
+		res, err := client.Push(notification)
+		if err != nil || !res.Sent() {
+			_ = statsd.Incr("apns.notification.errors", []string{}, 1)
+			logger.Error("failed to send watcher expiry notification",
+				zap.Error(err),
+				zap.Int64("watcher#id", watcher.ID),
+				zap.String("apns", watcher.Device.APNSToken),
+			)
+		} else {
+			_ = statsd.Incr("apns.notification.sent", []string{}, 1)
+		}
+	}
+}
+
+func pruneLiveActivities(ctx context.Context, logger *zap.Logger, statsd *statsd.Client, pool *pgxpool.Pool, redisConn *redis.Client, apns *token.Token) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	lar := repository.NewPostgresLiveActivity(pool)
+
+	now := time.Now()
+	stale, err := lar.RemoveStale(ctx, now.Add(-domain.LiveActivityStaleAfter))
+	if err != nil {
+		logger.Error("failed to clean stale live activities", zap.Error(err))
+		return
+	}
+
+	if len(stale) == 0 {
+		return
+	}
+
+	logger.Info("pruned stale live activities", zap.Int("count", len(stale)))
+	_ = statsd.Count("apollo.live_activities.pruned", int64(len(stale)), []string{}, 1)
+
+	if apns == nil {
+		return
+	}
+
+	production := apns2.NewTokenClient(apns).Production()
+	sandbox := apns2.NewTokenClient(apns).Development()
+
+	// These should have been ended by the live activities worker, so make sure
+	// they don't linger on the lock screen, showing whatever they last did.
+	// Pending ones were never handed their own token, so there's nothing to
+	// send to.
+	for _, la := range stale {
+		if la.Pending {
+			continue
+		}
+
+		din := worker.DynamicIslandNotification{}
+		lastKey := fmt.Sprintf("live-activities:%s:%s:last", la.APNSToken, la.ThreadID)
+		if bb, err := redisConn.Get(ctx, lastKey).Bytes(); err == nil {
+			_ = json.Unmarshal(bb, &din)
+		}
+
+		bb, _ := json.Marshal(map[string]interface{}{
+			"aps": map[string]interface{}{
+				"content-state":  din,
+				"dismissal-date": now.Unix(),
+				"event":          "end",
+				"timestamp":      now.Unix(),
+			},
+		})
+
+		notification := &apns2.Notification{
+			DeviceToken: la.APNSToken,
+			Topic:       "com.christianselig.Apollo.push-type.liveactivity",
+			PushType:    "liveactivity",
+			Priority:    apns2.PriorityHigh,
+			Payload:     bb,
+		}
+
+		client := production
+		if la.Development {
+			client = sandbox
+		}
+
+		res, err := client.PushWithContext(ctx, notification)
+		if err != nil || !res.Sent() {
+			_ = statsd.Incr("apns.live_activities.errors", []string{"event:end"}, 1)
+			logger.Debug("failed to end stale live activity",
+				zap.Error(err),
+				zap.Int64("live_activity#id", la.ID),
+				zap.String("live_activity#thread_id", la.ThreadID),
+			)
+		} else {
+			_ = statsd.Incr("apns.notification.sent", []string{}, 1)
+		}
+	}
+}
+
+// sealLiveActivityTokens encrypts the reddit tokens of live activities stored
+// before we started doing so, a batch at a time.
+func sealLiveActivityTokens(ctx context.Context, logger *zap.Logger, statsd *statsd.Client, pool *pgxpool.Pool, tokens *tokencrypt.Sealer) {
+	if tokens == nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		logger.Error("failed to start sealing live activity tokens", zap.Error(err))
+		return
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	stmt := `SELECT id, access_token, refresh_token
+		FROM live_activities
+		WHERE (access_token <> '' AND access_token NOT LIKE $1)
+			OR (refresh_token <> '' AND refresh_token NOT LIKE $1)
+		FOR UPDATE SKIP LOCKED
+		LIMIT $2`
+
+	rows, err := tx.Query(ctx, stmt, tokencrypt.SealedPrefix+"%", batchSize)
+	if err != nil {
+		logger.Error("failed to fetch plaintext live activity tokens", zap.Error(err))
+		return
+	}
+
+	type plaintext struct {
+		id                        int64
+		accessToken, refreshToken string
+	}
+
+	pts := []plaintext{}
+	for rows.Next() {
+		var pt plaintext
+		if err := rows.Scan(&pt.id, &pt.accessToken, &pt.refreshToken); err != nil {
+			rows.Close()
+			logger.Error("failed to scan plaintext live activity tokens", zap.Error(err))
+			return
+		}
+		pts = append(pts, pt)
+	}
+	rows.Close()
+
+	if len(pts) == 0 {
+		return
+	}
+
+	seal := func(token string) (string, error) {
+		if tokencrypt.IsSealed(token) {
+			return token, nil
+		}
+		return tokens.Seal(token)
+	}
+
+	for _, pt := range pts {
+		accessToken, err := seal(pt.accessToken)
+		if err != nil {
+			logger.Error("failed to encrypt live activity tokens", zap.Error(err), zap.Int64("live_activity#id", pt.id))
+			return
+		}
+
+		refreshToken, err := seal(pt.refreshToken)
+		if err != nil {
+			logger.Error("failed to encrypt live activity tokens", zap.Error(err), zap.Int64("live_activity#id", pt.id))
+			return
+		}
+
+		stmt := `UPDATE live_activities SET access_token = $1, refresh_token = $2 WHERE id = $3`
+		if _, err := tx.Exec(ctx, stmt, accessToken, refreshToken, pt.id); err != nil {
+			logger.Error("failed to save encrypted live activity tokens", zap.Error(err), zap.Int64("live_activity#id", pt.id))
+			return
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		logger.Error("failed to commit encrypted live activity tokens", zap.Error(err))
+		return
+	}
+
+	logger.Info("encrypted live activity tokens", zap.Int("count", len(pts)))
+	_ = statsd.Count("apollo.live_activities.sealed_tokens", int64(len(pts)), []string{}, 1)
+}
+
+func pruneWatcherHits(ctx context.Context, logger *zap.Logger, statsd *statsd.Client, pool *pgxpool.Pool) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	wr := repository.NewPostgresWatcher(pool)
+
+	count, err := wr.DeleteHitsBefore(ctx, time.Now().Add(-domain.WatcherHitRetention))
+	if err != nil {
+		logger.Error("failed to clean old watcher hits", zap.Error(err))
+		return
+	}
+
+	logger.Info("pruned watcher hits", zap.Int64("count", count))
+	_ = statsd.Count("apollo.watcher.hits.pruned", count, []string{}, 1)
+}
+
 func cleanQueues(logger *zap.Logger, jobsConn rmq.Connection) {
 	cleaner := rmq.NewCleaner(jobsConn)
 	count, err := cleaner.Clean()
@@ -277,6 +569,7 @@ func reportStats(ctx context.Context, logger *zap.Logger, statsd *statsd.Client,
 			{"SELECT COUNT(*) FROM accounts", "apollo.registrations.accounts"},
 			{"SELECT COUNT(*) FROM devices", "apollo.registrations.devices"},
 			{"SELECT COUNT(*) FROM subreddits", "apollo.registrations.subreddits"},
+			{"SELECT COUNT(*) FROM feeds", "apollo.registrations.feeds"},
 			{"SELECT COUNT(*) FROM users", "apollo.registrations.users"},
 			{"SELECT COUNT(*) FROM live_activities", "apollo.registrations.live-activities"},
 		}
@@ -403,6 +696,61 @@ func enqueueSubreddits(ctx context.Context, logger *zap.Logger, statsd *statsd.C
 
 }
 
+func enqueueFeeds(ctx context.Context, logger *zap.Logger, statsd *statsd.Client, pool *pgxpool.Pool, queue rmq.Queue) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	now := time.Now()
+	next := now.Add(domain.FeedCheckInterval)
+
+	ids := []int64{}
+
+	defer func() {
+		tags := []string{"queue:feeds"}
+		_ = statsd.Histogram("apollo.queue.enqueued", float64(len(ids)), tags, 1)
+		_ = statsd.Histogram("apollo.queue.runtime", float64(time.Since(now).Milliseconds()), tags, 1)
+	}()
+
+	stmt := `
+			UPDATE feeds
+			SET next_check_at = $2
+			WHERE feeds.id IN(
+				SELECT id
+				FROM feeds
+				WHERE next_check_at < $1
+				ORDER BY next_check_at
+				FOR UPDATE SKIP LOCKED
+				LIMIT 100
+			)
+			RETURNING feeds.id`
+	rows, err := pool.Query(ctx, stmt, now, next)
+	if err != nil {
+		logger.Error("failed to fetch batch of feeds", zap.Error(err))
+		return
+	}
+	for rows.Next() {
+		var id int64
+		_ = rows.Scan(&id)
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	if len(ids) == 0 {
+		return
+	}
+
+	logger.Debug("enqueueing feed batch", zap.Int("count", len(ids)), zap.Time("start", now))
+
+	batchIds := make([]string, len(ids))
+	for i, id := range ids {
+		batchIds[i] = strconv.FormatInt(id, 10)
+	}
+
+	if err = queue.Publish(batchIds...); err != nil {
+		logger.Error("failed to enqueue feed batch", zap.Error(err))
+	}
+}
+
 func enqueueStuckAccounts(ctx context.Context, logger *zap.Logger, statsd *statsd.Client, pool *pgxpool.Pool, queue rmq.Queue) {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
@@ -459,6 +807,139 @@ func enqueueStuckAccounts(ctx context.Context, logger *zap.Logger, statsd *stats
 	}
 }
 
+func enqueueMilestoneAccounts(ctx context.Context, logger *zap.Logger, statsd *statsd.Client, pool *pgxpool.Pool, queue rmq.Queue) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	now := time.Now()
+	next := now.Add(domain.MilestoneCheckInterval)
+
+	ids := []int64{}
+
+	defer func() {
+		tags := []string{"queue:milestones"}
+		_ = statsd.Histogram("apollo.queue.enqueued", float64(len(ids)), tags, 1)
+		_ = statsd.Histogram("apollo.queue.runtime", float64(time.Since(now).Milliseconds()), tags, 1)
+	}()
+
+	stmt := `
+		UPDATE accounts
+		SET next_milestone_check_at = $2
+		WHERE accounts.id IN(
+			SELECT id
+			FROM accounts
+			WHERE (next_milestone_check_at IS NULL OR next_milestone_check_at < $1) AND
+			EXISTS (
+				SELECT 1
+				FROM devices_accounts
+				WHERE devices_accounts.account_id = accounts.id AND
+				(cardinality(upvote_milestones) > 0 OR cardinality(comment_milestones) > 0)
+			)
+			ORDER BY next_milestone_check_at NULLS FIRST
+			FOR UPDATE SKIP LOCKED
+			LIMIT 100
+		)
+		RETURNING accounts.id`
+	rows, err := pool.Query(ctx, stmt, now, next)
+	if err != nil {
+		logger.Error("failed to fetch batch of milestone accounts", zap.Error(err))
+		return
+	}
+	for rows.Next() {
+		var id int64
+		_ = rows.Scan(&id)
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	if len(ids) == 0 {
+		return
+	}
+
+	logger.Debug("enqueueing milestone account batch", zap.Int("count", len(ids)), zap.Time("start", now))
+
+	batchIds := make([]string, len(ids))
+	for i, id := range ids {
+		batchIds[i] = strconv.FormatInt(id, 10)
+	}
+
+	if err = queue.Publish(batchIds...); err != nil {
+		logger.Error("failed to enqueue milestone account batch", zap.Error(err))
+	}
+}
+
+func enqueueWatcherHealthChecks(ctx context.Context, logger *zap.Logger, statsd *statsd.Client, pool *pgxpool.Pool, queue rmq.Queue) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	now := time.Now()
+	next := now.Add(domain.WatcherHealthCheckInterval)
+
+	payloads := []string{}
+
+	defer func() {
+		tags := []string{"queue:watcher-health"}
+		_ = statsd.Histogram("apollo.queue.enqueued", float64(len(payloads)), tags, 1)
+		_ = statsd.Histogram("apollo.queue.runtime", float64(time.Since(now).Milliseconds()), tags, 1)
+	}()
+
+	stmts := map[string]string{
+		"subreddit": `
+			UPDATE subreddits
+			SET next_health_check_at = $2
+			WHERE subreddits.id IN(
+				SELECT id
+				FROM subreddits
+				WHERE (next_health_check_at IS NULL OR next_health_check_at < $1) AND
+				(
+					EXISTS (SELECT 1 FROM watchers WHERE watchers.type IN(0,2) AND watchers.watchee_id = subreddits.id) OR
+					EXISTS (SELECT 1 FROM watchers_subreddits WHERE watchers_subreddits.subreddit_id = subreddits.id)
+				)
+				ORDER BY next_health_check_at NULLS FIRST
+				FOR UPDATE SKIP LOCKED
+				LIMIT 100
+			)
+			RETURNING subreddits.id`,
+		"user": `
+			UPDATE users
+			SET next_health_check_at = $2
+			WHERE users.id IN(
+				SELECT id
+				FROM users
+				WHERE (next_health_check_at IS NULL OR next_health_check_at < $1) AND
+				EXISTS (SELECT 1 FROM watchers WHERE watchers.type = 1 AND watchers.watchee_id = users.id)
+				ORDER BY next_health_check_at NULLS FIRST
+				FOR UPDATE SKIP LOCKED
+				LIMIT 100
+			)
+			RETURNING users.id`,
+	}
+
+	for kind, stmt := range stmts {
+		rows, err := pool.Query(ctx, stmt, now, next)
+		if err != nil {
+			logger.Error("failed to fetch batch of watchees", zap.Error(err), zap.String("watchee#kind", kind))
+			return
+		}
+		for rows.Next() {
+			var id int64
+			_ = rows.Scan(&id)
+			payloads = append(payloads, fmt.Sprintf("%s:%d", kind, id))
+		}
+		rows.Close()
+	}
+
+	if len(payloads) == 0 {
+		return
+	}
+
+	logger.Debug("enqueueing watcher health check batch", zap.Int("count", len(payloads)), zap.Time("start", now))
+
+	if err := queue.Publish(payloads...); err != nil {
+		logger.Error("failed to enqueue watcher health check batch", zap.Error(err))
+	}
+}
+
 func enqueueAccounts(ctx context.Context, logger *zap.Logger, statsd *statsd.Client, pool *pgxpool.Pool, redisConn *redis.Client, luaSha string, queue rmq.Queue) {
 	if enqueueAccountsMutex.TryLock() {
 		defer enqueueAccountsMutex.Unlock()
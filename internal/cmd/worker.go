@@ -14,12 +14,15 @@ import (
 
 var (
 	queues = map[string]worker.NewWorkerFn{
+		"feeds":               worker.NewFeedsWorker,
 		"live-activities":     worker.NewLiveActivitiesWorker,
+		"milestones":          worker.NewMilestonesWorker,
 		"notifications":       worker.NewNotificationsWorker,
 		"stuck-notifications": worker.NewStuckNotificationsWorker,
 		"subreddits":          worker.NewSubredditsWorker,
 		"trending":            worker.NewTrendingWorker,
 		"users":               worker.NewUsersWorker,
+		"watcher-health":      worker.NewWatcherHealthWorker,
 	}
 )
 
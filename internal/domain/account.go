@@ -49,6 +49,7 @@ func (acct *Account) Validate() error {
 type AccountRepository interface {
 	GetByID(ctx context.Context, id int64) (Account, error)
 	GetByRedditID(ctx context.Context, id string) (Account, error)
+	GetByUsername(ctx context.Context, username string) (Account, error)
 	GetByAPNSToken(ctx context.Context, token string) ([]Account, error)
 
 	CreateOrUpdate(ctx context.Context, acc *Account) error
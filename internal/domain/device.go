@@ -2,6 +2,13 @@ package domain
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strconv"
 	"time"
 
 	validation "github.com/go-ozzo/ozzo-validation/v4"
@@ -11,14 +18,55 @@ const (
 	DeviceReceiptCheckPeriodDuration     = 4 * time.Hour
 	DeviceActiveAfterReceitCheckDuration = 30 * 24 * time.Hour // ~1 month
 	DeviceGracePeriodAfterReceiptExpiry  = 30 * 24 * time.Hour // ~1 month
+
+	// How far a signed request's timestamp can be from ours, so signatures can't be replayed later on
+	DeviceSignatureMaxSkew = 5 * time.Minute
+
+	// How long a device has to echo back the nonce we push to it before it's handed a secret
+	DeviceNonceTTL = 10 * time.Minute
 )
 
+// DeviceDedupeKey is a sorted set of the keys watcher workers set to avoid
+// notifying a device about the same post twice, scored by when they expire.
+// It lets them be cleared when the device migrates without scanning for them.
+func DeviceDedupeKey(deviceID int64) string {
+	return fmt.Sprintf("devices:%d:dedupe", deviceID)
+}
+
 type Device struct {
 	ID                   int64
 	APNSToken            string
 	Sandbox              bool
 	ExpiresAt            time.Time
 	GracePeriodExpiresAt time.Time
+
+	// Lets us start live activities on the device, like when a followed thread goes up
+	PushToStartToken string
+
+	// Secret the device authenticates its requests with, stored encrypted.
+	// Devices registered before we handed out secrets don't have one.
+	Secret string
+}
+
+// NewDeviceSecret generates a secret to hand to a device once it proved it
+// holds its token. Nonces pushed to devices are generated the same way.
+func NewDeviceSecret() (string, error) {
+	bb := make([]byte, 32)
+	if _, err := rand.Read(bb); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(bb), nil
+}
+
+// DeviceSignature is the hex encoded HMAC-SHA256 of a request under the device's
+// secret. It covers the method, the path, when it was signed and a SHA-256 of
+// the body, each on their own line.
+func DeviceSignature(secret, method, path string, timestamp int64, body []byte) string {
+	digest := sha256.Sum256(body)
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(method + "\n" + path + "\n" + strconv.FormatInt(timestamp, 10) + "\n" + hex.EncodeToString(digest[:])))
+	return hex.EncodeToString(mac.Sum(nil))
 }
 
 func (dev *Device) Validate() error {
@@ -33,13 +81,19 @@ type DeviceRepository interface {
 	GetInboxNotifiableByAccountID(ctx context.Context, id int64) ([]Device, error)
 	GetWatcherNotifiableByAccountID(ctx context.Context, id int64) ([]Device, error)
 	GetByAccountID(ctx context.Context, id int64) ([]Device, error)
+	GetMilestoneNotifiableByAccountID(ctx context.Context, id int64) ([]DeviceMilestones, error)
 
 	CreateOrUpdate(ctx context.Context, dev *Device) error
 	Update(ctx context.Context, dev *Device) error
 	Create(ctx context.Context, dev *Device) error
 	Delete(ctx context.Context, token string) error
+	SetPushToStartToken(ctx context.Context, dev *Device, token string) error
+	SetSecret(ctx context.Context, dev *Device, secret string) error
 	SetNotifiable(ctx context.Context, dev *Device, acct *Account, inbox, watcher, global bool) error
 	GetNotifiable(ctx context.Context, dev *Device, acct *Account) (bool, bool, bool, error)
+	SetMilestones(ctx context.Context, dev *Device, acct *Account, m Milestones) error
+	GetMilestones(ctx context.Context, dev *Device, acct *Account) (Milestones, error)
+	Migrate(ctx context.Context, from, to *Device) error
 
 	PruneStale(ctx context.Context, expiry time.Time) (int64, error)
 }
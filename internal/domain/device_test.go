@@ -0,0 +1,56 @@
+package domain_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/christianselig/apollo-backend/internal/domain"
+)
+
+func TestNewDeviceSecret(t *testing.T) {
+	t.Parallel()
+
+	a, err := domain.NewDeviceSecret()
+	assert.NoError(t, err)
+	assert.Len(t, a, 43)
+
+	b, err := domain.NewDeviceSecret()
+	assert.NoError(t, err)
+	assert.NotEqual(t, a, b)
+}
+
+func TestDeviceSignature(t *testing.T) {
+	t.Parallel()
+
+	signature := domain.DeviceSignature("secret", "POST", "/v1/device/abc/accounts", 1700000000, []byte(`[]`))
+	assert.Len(t, signature, 64)
+
+	tt := map[string]struct {
+		secret    string
+		method    string
+		path      string
+		timestamp int64
+		body      []byte
+		want      bool
+	}{
+		"same request":    {"secret", "POST", "/v1/device/abc/accounts", 1700000000, []byte(`[]`), true},
+		"other secret":    {"terces", "POST", "/v1/device/abc/accounts", 1700000000, []byte(`[]`), false},
+		"other method":    {"secret", "PATCH", "/v1/device/abc/accounts", 1700000000, []byte(`[]`), false},
+		"other path":      {"secret", "POST", "/v1/device/abd/accounts", 1700000000, []byte(`[]`), false},
+		"other timestamp": {"secret", "POST", "/v1/device/abc/accounts", 1700000001, []byte(`[]`), false},
+		"other body":      {"secret", "POST", "/v1/device/abc/accounts", 1700000000, []byte(`[{}]`), false},
+		"fields run into": {"secret", "POST\n/v1/device/abc/accounts", "", 1700000000, []byte(`[]`), false},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			got := domain.DeviceSignature(tc.secret, tc.method, tc.path, tc.timestamp, tc.body)
+			assert.Equal(t, tc.want, got == signature)
+		})
+	}
+}
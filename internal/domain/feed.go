@@ -0,0 +1,60 @@
+package domain
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+)
+
+const FeedCheckInterval = 1 * time.Minute
+
+var feedPathExp = regexp.MustCompile(`^(r/(all|popular)|user/[\w-]{3,20}/m/\w{2,50})$`)
+
+// Feed represents a listing that spans several subreddits, like r/all, r/popular
+// or a user's multireddit.
+type Feed struct {
+	ID          int64
+	NextCheckAt time.Time
+
+	// Reddit information, e.g. "r/all" or "user/iamthatis/m/apple"
+	Path string
+}
+
+func (f *Feed) NormalizedPath() string {
+	path := strings.ToLower(strings.Trim(f.Path, "/"))
+
+	// Multireddits are commonly shared with the short user prefix
+	if strings.HasPrefix(path, "u/") {
+		path = "user/" + path[2:]
+	}
+
+	return path
+}
+
+// Owner is the username of whoever a multireddit belongs to, and empty for
+// r/all and r/popular.
+func (f *Feed) Owner() string {
+	parts := strings.Split(f.NormalizedPath(), "/")
+	if len(parts) == 4 && parts[0] == "user" && parts[2] == "m" {
+		return parts[1]
+	}
+	return ""
+}
+
+func (f *Feed) Validate() error {
+	return validation.ValidateStruct(f,
+		validation.Field(&f.Path, validation.Required, validation.By(func(interface{}) error {
+			return validation.Validate(f.NormalizedPath(), validation.Match(feedPathExp))
+		})),
+	)
+}
+
+type FeedRepository interface {
+	GetByID(ctx context.Context, id int64) (Feed, error)
+	GetByPath(ctx context.Context, path string) (Feed, error)
+
+	CreateOrUpdate(ctx context.Context, f *Feed) error
+}
@@ -0,0 +1,75 @@
+package domain_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/christianselig/apollo-backend/internal/domain"
+)
+
+func TestFeedValidate(t *testing.T) {
+	t.Parallel()
+
+	tt := map[string]struct {
+		path string
+		err  bool
+	}{
+		"r/all":                     {"r/all", false},
+		"r/popular":                 {"r/popular", false},
+		"leading and trailing /":    {"/r/all/", false},
+		"multireddit":               {"user/iamthatis/m/apple", false},
+		"multireddit with u prefix": {"u/iamthatis/m/apple", false},
+		"regular subreddit":         {"r/pics", true},
+		"empty path":                {"", true},
+		"arbitrary path":            {"api/v1/me", true},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			f := &domain.Feed{Path: tc.path}
+			err := f.Validate()
+
+			if tc.err {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestFeedNormalizedPath(t *testing.T) {
+	t.Parallel()
+
+	f := &domain.Feed{Path: "/u/IAmThatIs/m/Apple/"}
+	assert.Equal(t, "user/iamthatis/m/apple", f.NormalizedPath())
+}
+
+func TestFeedOwner(t *testing.T) {
+	t.Parallel()
+
+	tt := map[string]struct {
+		path  string
+		owner string
+	}{
+		"r/all":                     {"r/all", ""},
+		"r/popular":                 {"r/popular", ""},
+		"multireddit":               {"user/iamthatis/m/apple", "iamthatis"},
+		"multireddit with u prefix": {"/u/IAmThatIs/m/Apple/", "iamthatis"},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			f := &domain.Feed{Path: tc.path}
+			assert.Equal(t, tc.owner, f.Owner())
+		})
+	}
+}
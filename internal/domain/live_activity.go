@@ -2,37 +2,165 @@ package domain
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
 	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
 )
 
 const (
+	// How long a live activity follows a thread, and the furthest out it can be extended to
 	LiveActivityDuration      = 75 * time.Minute
 	LiveActivityCheckInterval = 30 * time.Second
+
+	// How long past expiring an activity is left for the worker to end before it's pruned
+	LiveActivityStaleAfter = 15 * time.Minute
+
+	// Quiet threads get checked less and less often, up to this far apart
+	LiveActivityMaxCheckInterval = 5 * time.Minute
+
+	// How many high priority updates a live activity can send in an hour.
+	// Apple throttles activities going over their budget, so anything past
+	// this goes out at low priority instead.
+	LiveActivityHourlyBudget = 12
 )
 
+var threadIDRegexp = regexp.MustCompile(`^[a-z0-9]+$`)
+
+// LiveActivityAuthors restricts which commenters a live activity shows. Any
+// combination is allowed, and no restriction at all shows everyone.
+type LiveActivityAuthors int64
+
+const (
+	LiveActivityOP LiveActivityAuthors = 1 << iota
+	LiveActivityModerators
+	LiveActivityFlaired
+
+	liveActivityAllAuthors = LiveActivityOP | LiveActivityModerators | LiveActivityFlaired
+)
+
+var liveActivityAuthorNames = []struct {
+	authors LiveActivityAuthors
+	name    string
+}{
+	{LiveActivityOP, "op"},
+	{LiveActivityModerators, "moderator"},
+	{LiveActivityFlaired, "flaired"},
+}
+
+func (laa LiveActivityAuthors) Has(authors LiveActivityAuthors) bool {
+	return laa&authors != 0
+}
+
+// MarshalJSON encodes the restriction as a list like ["op", "moderator"].
+func (laa LiveActivityAuthors) MarshalJSON() ([]byte, error) {
+	names := []string{}
+	for _, lan := range liveActivityAuthorNames {
+		if laa.Has(lan.authors) {
+			names = append(names, lan.name)
+		}
+	}
+	return json.Marshal(names)
+}
+
+func (laa *LiveActivityAuthors) UnmarshalJSON(bb []byte) error {
+	var names []string
+	if err := json.Unmarshal(bb, &names); err != nil {
+		return err
+	}
+
+	var authors LiveActivityAuthors
+	for _, name := range names {
+		known := false
+		for _, lan := range liveActivityAuthorNames {
+			if lan.name == name {
+				authors |= lan.authors
+				known = true
+			}
+		}
+
+		if !known {
+			return fmt.Errorf("unknown live activity author %q", name)
+		}
+	}
+
+	*laa = authors
+	return nil
+}
+
 type LiveActivity struct {
 	ID          int64
 	APNSToken   string `json:"apns_token"`
 	Development bool   `json:"development"`
 
+	// The device the activity was started from, or zero for ones created
+	// before activities were tied to devices. Only it can see or change it.
+	DeviceID int64 `json:"-"`
+
 	RedditAccountID string `json:"reddit_account_id"`
 	AccessToken     string `json:"access_token"`
 	RefreshToken    string `json:"refresh_token"`
 	TokenExpiresAt  time.Time
 
-	ThreadID    string `json:"thread_id"`
-	Subreddit   string `json:"subreddit"`
+	ThreadID    string              `json:"thread_id"`
+	Subreddit   string              `json:"subreddit"`
+	Authors     LiveActivityAuthors `json:"authors"`
 	NextCheckAt time.Time
 	ExpiresAt   time.Time
+
+	// Pending activities were started by us through a push-to-start token, and
+	// are waiting on the app to hand over the activity's own token. Until then
+	// they have no token, and are known by their device and thread instead.
+	Pending bool `json:"-"`
+}
+
+// Ended returns whether the activity is past its expiry and only waiting on its final update.
+func (la *LiveActivity) Ended(now time.Time) bool {
+	return !la.ExpiresAt.IsZero() && !now.Before(la.ExpiresAt)
+}
+
+// LiveActivityBackoff returns how long to wait before checking a thread again
+// once it's had nothing new for that many checks in a row.
+func LiveActivityBackoff(quiet int64) time.Duration {
+	interval := LiveActivityCheckInterval
+	for i := int64(0); i < quiet && interval < LiveActivityMaxCheckInterval; i++ {
+		interval *= 2
+	}
+
+	if interval > LiveActivityMaxCheckInterval {
+		interval = LiveActivityMaxCheckInterval
+	}
+	return interval
+}
+
+func (la *LiveActivity) Validate() error {
+	return validation.ValidateStruct(la,
+		validation.Field(&la.APNSToken, validation.Required, validation.Length(64, 200)),
+		validation.Field(&la.RedditAccountID, validation.Required),
+		validation.Field(&la.RefreshToken, validation.Required),
+		validation.Field(&la.ThreadID, validation.Required, validation.Length(2, 16), validation.Match(threadIDRegexp)),
+		validation.Field(&la.Subreddit, validation.Required, validation.Length(2, 21), validation.Match(subredditNameRegexp)),
+		validation.Field(&la.Authors, validation.Min(LiveActivityAuthors(0)), validation.Max(liveActivityAllAuthors)),
+		validation.Field(&la.ExpiresAt, validation.Max(time.Now().Add(LiveActivityDuration))),
+	)
 }
 
 type LiveActivityRepository interface {
 	Get(ctx context.Context, apnsToken string) (LiveActivity, error)
+	GetByDeviceID(ctx context.Context, deviceID int64, apnsToken string) (LiveActivity, error)
+	GetPending(ctx context.Context, deviceID int64, threadID string) (LiveActivity, error)
 	List(ctx context.Context) ([]LiveActivity, error)
 
 	Create(ctx context.Context, la *LiveActivity) error
-	Update(ctx context.Context, la *LiveActivity) error
+	CreatePending(ctx context.Context, la *LiveActivity) error
+	Claim(ctx context.Context, la *LiveActivity, apnsToken string) error
+	UpdateTokens(ctx context.Context, la *LiveActivity) error
+	Reschedule(ctx context.Context, la *LiveActivity, next time.Time) error
+	UpdateSettings(ctx context.Context, la *LiveActivity) error
 
-	RemoveStale(ctx context.Context) error
+	RemoveStale(ctx context.Context, before time.Time) ([]LiveActivity, error)
 	Delete(ctx context.Context, apns_token string) error
+	DeleteByID(ctx context.Context, id int64) error
 }
@@ -0,0 +1,64 @@
+package domain
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+)
+
+// How many threads a device can follow at once
+const MaxLiveActivitySubscriptions = 10
+
+// LiveActivitySubscription starts a live activity on a device whenever a new
+// post in the subreddit has a title matching the pattern, like a subreddit's
+// daily game thread.
+type LiveActivitySubscription struct {
+	ID        int64
+	CreatedAt time.Time
+
+	DeviceID    int64
+	AccountID   int64
+	SubredditID int64
+
+	// TitlePattern is matched case insensitively against the whole title, with
+	// * standing in for anything, e.g. "Game Thread: * vs *".
+	TitlePattern string
+	Authors      LiveActivityAuthors
+
+	Device    Device
+	Account   Account
+	Subreddit string
+}
+
+// TitleMatches returns whether a post title matches the subscription's pattern.
+func (las *LiveActivitySubscription) TitleMatches(title string) bool {
+	parts := strings.Split(strings.TrimSpace(las.TitlePattern), "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+
+	re, err := regexp.Compile(`(?i)^` + strings.Join(parts, ".*") + `$`)
+	if err != nil {
+		return false
+	}
+	return re.MatchString(strings.TrimSpace(title))
+}
+
+func (las *LiveActivitySubscription) Validate() error {
+	return validation.ValidateStruct(las,
+		validation.Field(&las.TitlePattern, validation.Required, validation.Length(2, 100)),
+		validation.Field(&las.Authors, validation.Min(LiveActivityAuthors(0)), validation.Max(liveActivityAllAuthors)),
+	)
+}
+
+type LiveActivitySubscriptionRepository interface {
+	GetByID(ctx context.Context, id int64) (LiveActivitySubscription, error)
+	GetBySubredditID(ctx context.Context, id int64) ([]LiveActivitySubscription, error)
+	GetByDeviceAPNSTokenAndAccountRedditID(ctx context.Context, apns string, rid string) ([]LiveActivitySubscription, error)
+
+	Create(ctx context.Context, las *LiveActivitySubscription) error
+	Delete(ctx context.Context, id int64) error
+}
@@ -0,0 +1,68 @@
+package domain_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/christianselig/apollo-backend/internal/domain"
+)
+
+func TestLiveActivitySubscriptionTitleMatches(t *testing.T) {
+	t.Parallel()
+
+	tt := map[string]struct {
+		pattern string
+		title   string
+		want    bool
+	}{
+		"exact":                  {"Daily Discussion", "Daily Discussion", true},
+		"case insensitive":       {"daily discussion", "Daily Discussion", true},
+		"wildcards":              {"Game Thread: * vs *", "Game Thread: Lakers vs Celtics (10/18)", true},
+		"wildcard at the end":    {"Game Thread*", "Game Thread: Lakers vs Celtics", true},
+		"whole title":            {"Game Thread", "Post Game Thread: Lakers vs Celtics", false},
+		"leading wildcard":       {"*Game Thread*", "Post Game Thread: Lakers vs Celtics", true},
+		"regexp characters":      {"[Match Thread] *", "[Match Thread] Arsenal v Spurs", true},
+		"regexp characters only": {"[Match Thread] *", "M Arsenal v Spurs", false},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			las := &domain.LiveActivitySubscription{TitlePattern: tc.pattern}
+			assert.Equal(t, tc.want, las.TitleMatches(tc.title))
+		})
+	}
+}
+
+func TestLiveActivitySubscriptionValidate(t *testing.T) {
+	t.Parallel()
+
+	tt := map[string]struct {
+		las domain.LiveActivitySubscription
+		err bool
+	}{
+		"valid":            {domain.LiveActivitySubscription{TitlePattern: "Game Thread: *"}, false},
+		"missing pattern":  {domain.LiveActivitySubscription{}, true},
+		"too long pattern": {domain.LiveActivitySubscription{TitlePattern: strings.Repeat("a", 101)}, true},
+		"unknown authors":  {domain.LiveActivitySubscription{TitlePattern: "Game Thread: *", Authors: 8}, true},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			err := tc.las.Validate()
+			if tc.err {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+		})
+	}
+}
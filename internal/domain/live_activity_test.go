@@ -0,0 +1,131 @@
+package domain_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/christianselig/apollo-backend/internal/domain"
+)
+
+func TestLiveActivityValidate(t *testing.T) {
+	t.Parallel()
+
+	valid := func() domain.LiveActivity {
+		return domain.LiveActivity{
+			APNSToken:       strings.Repeat("a", 64),
+			RedditAccountID: "1ia22",
+			RefreshToken:    "refresh",
+			ThreadID:        "abc123",
+			Subreddit:       "apolloapp",
+		}
+	}
+
+	tt := map[string]struct {
+		modify func(*domain.LiveActivity)
+		err    bool
+	}{
+		"valid":              {func(*domain.LiveActivity) {}, false},
+		"missing apns token": {func(la *domain.LiveActivity) { la.APNSToken = "" }, true},
+		"missing refresh":    {func(la *domain.LiveActivity) { la.RefreshToken = "" }, true},
+		"thread with prefix": {func(la *domain.LiveActivity) { la.ThreadID = "t3_abc123" }, true},
+		"invalid subreddit":  {func(la *domain.LiveActivity) { la.Subreddit = "r/apolloapp" }, true},
+		"extended within":    {func(la *domain.LiveActivity) { la.ExpiresAt = time.Now().Add(time.Hour) }, false},
+		"extended beyond":    {func(la *domain.LiveActivity) { la.ExpiresAt = time.Now().Add(3 * time.Hour) }, true},
+		"missing reddit id":  {func(la *domain.LiveActivity) { la.RedditAccountID = "" }, true},
+		"op and moderators":  {func(la *domain.LiveActivity) { la.Authors = domain.LiveActivityOP | domain.LiveActivityModerators }, false},
+		"unknown authors":    {func(la *domain.LiveActivity) { la.Authors = 8 }, true},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			la := valid()
+			tc.modify(&la)
+			err := la.Validate()
+
+			if tc.err {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestLiveActivityEnded(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+
+	assert.False(t, (&domain.LiveActivity{}).Ended(now))
+	assert.False(t, (&domain.LiveActivity{ExpiresAt: now.Add(time.Minute)}).Ended(now))
+	assert.True(t, (&domain.LiveActivity{ExpiresAt: now}).Ended(now))
+}
+
+func TestLiveActivityAuthorsJSON(t *testing.T) {
+	t.Parallel()
+
+	tt := map[string]struct {
+		json    string
+		authors domain.LiveActivityAuthors
+		err     bool
+	}{
+		"everyone":          {`[]`, 0, false},
+		"op":                {`["op"]`, domain.LiveActivityOP, false},
+		"moderator flaired": {`["moderator","flaired"]`, domain.LiveActivityModerators | domain.LiveActivityFlaired, false},
+		"unknown":           {`["admin"]`, 0, true},
+		"not a list":        {`"op"`, 0, true},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			var authors domain.LiveActivityAuthors
+			err := json.Unmarshal([]byte(tc.json), &authors)
+
+			if tc.err {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tc.authors, authors)
+
+			bb, err := json.Marshal(authors)
+			assert.NoError(t, err)
+			assert.JSONEq(t, tc.json, string(bb))
+		})
+	}
+}
+
+func TestLiveActivityBackoff(t *testing.T) {
+	t.Parallel()
+
+	tt := map[string]struct {
+		quiet int64
+		want  time.Duration
+	}{
+		"not quiet":    {0, domain.LiveActivityCheckInterval},
+		"quiet once":   {1, time.Minute},
+		"quiet thrice": {3, 4 * time.Minute},
+		"capped":       {10, domain.LiveActivityMaxCheckInterval},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.want, domain.LiveActivityBackoff(tc.quiet))
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package domain
+
+import (
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+)
+
+const (
+	MilestoneCheckInterval = 5 * time.Minute // time between checks of an account's own posts
+	MaxMilestones          = 10              // thresholds a device can set per kind
+)
+
+// Milestones are the upvote and comment counts a device wants to hear about
+// when the account's own posts and comments pass them.
+type Milestones struct {
+	Upvotes  []int64
+	Comments []int64
+}
+
+func (m *Milestones) Validate() error {
+	return validation.ValidateStruct(m,
+		validation.Field(&m.Upvotes, validation.Length(0, MaxMilestones), validation.Each(validation.Required, validation.Min(int64(1)))),
+		validation.Field(&m.Comments, validation.Length(0, MaxMilestones), validation.Each(validation.Required, validation.Min(int64(1)))),
+	)
+}
+
+func (m *Milestones) Enabled() bool {
+	return len(m.Upvotes) > 0 || len(m.Comments) > 0
+}
+
+// DeviceMilestones is a device along with the milestones it has set for an account.
+type DeviceMilestones struct {
+	Device     Device
+	Milestones Milestones
+}
+
+// CrossedMilestone returns the highest of the milestones passed when a count
+// went from before to after, or zero if it didn't pass any.
+func CrossedMilestone(milestones []int64, before, after int64) int64 {
+	var crossed int64
+	for _, m := range milestones {
+		if before < m && m <= after && m > crossed {
+			crossed = m
+		}
+	}
+	return crossed
+}
@@ -0,0 +1,66 @@
+package domain_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/christianselig/apollo-backend/internal/domain"
+)
+
+func TestCrossedMilestone(t *testing.T) {
+	t.Parallel()
+
+	milestones := []int64{10000, 100, 1000}
+
+	tt := map[string]struct {
+		before int64
+		after  int64
+		want   int64
+	}{
+		"no change":            {50, 50, 0},
+		"below first":          {10, 99, 0},
+		"exactly at milestone": {99, 100, 100},
+		"already past":         {100, 150, 0},
+		"several at once":      {50, 1500, 1000},
+		"going down":           {1500, 50, 0},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.want, domain.CrossedMilestone(milestones, tc.before, tc.after))
+		})
+	}
+}
+
+func TestMilestonesValidate(t *testing.T) {
+	t.Parallel()
+
+	tt := map[string]struct {
+		milestones domain.Milestones
+		err        bool
+	}{
+		"empty":              {domain.Milestones{}, false},
+		"valid":              {domain.Milestones{Upvotes: []int64{100, 1000}, Comments: []int64{50}}, false},
+		"zero threshold":     {domain.Milestones{Upvotes: []int64{0}}, true},
+		"too many":           {domain.Milestones{Comments: []int64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11}}, true},
+		"negative threshold": {domain.Milestones{Comments: []int64{-5}}, true},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			err := tc.milestones.Validate()
+			if tc.err {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
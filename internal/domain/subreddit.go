@@ -10,7 +10,17 @@ import (
 	validation "github.com/go-ozzo/ozzo-validation/v4"
 )
 
-const SubredditCheckInterval = 2 * time.Minute
+const (
+	SubredditCheckInterval = 2 * time.Minute
+
+	// How long a subreddit can stay quiet before we make sure its high-water mark wasn't removed.
+	// It only holds up posts the mark's removal hid, posts short of an upvote or comment
+	// threshold are rechecked on every check until they're a day old.
+	SubredditHighWaterMarkRecheck = time.Hour
+
+	// How many posts short of a threshold are rechecked at most, newest first
+	SubredditPendingPostsLimit = 300
+)
 
 type Subreddit struct {
 	ID          int64
@@ -19,12 +29,24 @@ type Subreddit struct {
 	// Reddit information
 	SubredditID string
 	Name        string
+
+	// Newest post we've processed, so checks only need to fetch what came after it
+	LastPostID string
+	LastPostAt time.Time
 }
 
 func (sr *Subreddit) NormalizedName() string {
 	return strings.ToLower(sr.Name)
 }
 
+// HasHighWaterMark reports whether the subreddit's newest processed post is
+// recent enough to only fetch posts newer than it.
+func (sr *Subreddit) HasHighWaterMark(since time.Time) bool {
+	return sr.LastPostID != "" && sr.LastPostAt.After(since)
+}
+
+var subredditNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9]\w*$`)
+
 func validPrefix(value interface{}) error {
 	s, _ := value.(string)
 	if len(s) < 2 {
@@ -39,7 +61,7 @@ func validPrefix(value interface{}) error {
 
 func (sr *Subreddit) Validate() error {
 	return validation.ValidateStruct(sr,
-		validation.Field(&sr.Name, validation.Required, validation.Length(2, 21), validation.By(validPrefix), validation.Match(regexp.MustCompile(`^[a-zA-Z0-9]\w*$`))),
+		validation.Field(&sr.Name, validation.Required, validation.Length(2, 21), validation.By(validPrefix), validation.Match(subredditNameRegexp)),
 		validation.Field(&sr.SubredditID, validation.Required, validation.Length(4, 9)),
 	)
 }
@@ -49,4 +71,5 @@ type SubredditRepository interface {
 	GetByName(ctx context.Context, name string) (Subreddit, error)
 
 	CreateOrUpdate(ctx context.Context, sr *Subreddit) error
+	UpdateHighWaterMark(ctx context.Context, sr *Subreddit) error
 }
@@ -3,6 +3,7 @@ package domain_test
 import (
 	"errors"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -41,3 +42,29 @@ func TestValidate(t *testing.T) {
 		})
 	}
 }
+
+func TestSubredditHasHighWaterMark(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	since := now.Add(-24 * time.Hour)
+
+	tt := map[string]struct {
+		subreddit domain.Subreddit
+		want      bool
+	}{
+		"no mark":    {domain.Subreddit{}, false},
+		"recent":     {domain.Subreddit{LastPostID: "t3_abc", LastPostAt: now.Add(-time.Hour)}, true},
+		"too old":    {domain.Subreddit{LastPostID: "t3_abc", LastPostAt: now.Add(-48 * time.Hour)}, false},
+		"missing id": {domain.Subreddit{LastPostAt: now}, false},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.want, tc.subreddit.HasHighWaterMark(since))
+		})
+	}
+}
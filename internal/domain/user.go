@@ -17,6 +17,10 @@ type User struct {
 	// Reddit information
 	UserID string
 	Name   string
+
+	// Newest comment we've processed, so comments never get notified twice
+	LastCommentID string
+	LastCommentAt time.Time
 }
 
 func (u *User) NormalizedName() string {
@@ -35,5 +39,6 @@ type UserRepository interface {
 	GetByName(context.Context, string) (User, error)
 
 	CreateOrUpdate(context.Context, *User) error
+	UpdateCommentCursor(context.Context, *User) error
 	Delete(context.Context, int64) error
 }
@@ -2,6 +2,9 @@ package domain
 
 import (
 	"context"
+	"fmt"
+	"net/url"
+	"regexp"
 	"strings"
 	"time"
 
@@ -14,8 +17,181 @@ const (
 	SubredditWatcher WatcherType = iota
 	UserWatcher
 	TrendingWatcher
+	MultiSubredditWatcher
+	FeedWatcher
 )
 
+type TrendingSensitivity int64
+
+const (
+	NormalTrendingSensitivity TrendingSensitivity = iota
+	LowTrendingSensitivity
+	HighTrendingSensitivity
+)
+
+func (ts TrendingSensitivity) String() string {
+	switch ts {
+	case NormalTrendingSensitivity:
+		return "normal"
+	case LowTrendingSensitivity:
+		return "low"
+	case HighTrendingSensitivity:
+		return "high"
+	}
+
+	return "unknown"
+}
+
+// UserWatcherActivity is what a user watcher notifies about.
+type UserWatcherActivity int64
+
+const (
+	UserWatcherPosts UserWatcherActivity = iota
+	UserWatcherComments
+	UserWatcherPostsAndComments
+)
+
+func (uwa UserWatcherActivity) String() string {
+	switch uwa {
+	case UserWatcherPosts:
+		return "posts"
+	case UserWatcherComments:
+		return "comments"
+	case UserWatcherPostsAndComments:
+		return "both"
+	}
+
+	return "unknown"
+}
+
+func (uwa UserWatcherActivity) IncludesPosts() bool {
+	return uwa == UserWatcherPosts || uwa == UserWatcherPostsAndComments
+}
+
+func (uwa UserWatcherActivity) IncludesComments() bool {
+	return uwa == UserWatcherComments || uwa == UserWatcherPostsAndComments
+}
+
+// WatcherMediaKind restricts a watcher to posts carrying a certain kind of media.
+type WatcherMediaKind int64
+
+const (
+	AnyWatcherMedia WatcherMediaKind = iota
+	ImageWatcherMedia
+	VideoWatcherMedia
+	GalleryWatcherMedia
+)
+
+func (wmk WatcherMediaKind) String() string {
+	switch wmk {
+	case AnyWatcherMedia:
+		return "any"
+	case ImageWatcherMedia:
+		return "image"
+	case VideoWatcherMedia:
+		return "video"
+	case GalleryWatcherMedia:
+		return "gallery"
+	}
+
+	return "unknown"
+}
+
+// WatcherPostKind restricts a watcher to either self (text) posts or link posts.
+type WatcherPostKind int64
+
+const (
+	AnyWatcherPostKind WatcherPostKind = iota
+	SelfWatcherPostKind
+	LinkWatcherPostKind
+)
+
+func (wpk WatcherPostKind) String() string {
+	switch wpk {
+	case AnyWatcherPostKind:
+		return "any"
+	case SelfWatcherPostKind:
+		return "self"
+	case LinkWatcherPostKind:
+		return "link"
+	}
+
+	return "unknown"
+}
+
+// WatcherNSFW is how a watcher treats posts marked as NSFW.
+type WatcherNSFW int64
+
+const (
+	AnyWatcherNSFW WatcherNSFW = iota
+	OnlyWatcherNSFW
+	ExcludeWatcherNSFW
+)
+
+func (wn WatcherNSFW) String() string {
+	switch wn {
+	case AnyWatcherNSFW:
+		return "any"
+	case OnlyWatcherNSFW:
+		return "only"
+	case ExcludeWatcherNSFW:
+		return "exclude"
+	}
+
+	return "unknown"
+}
+
+// WatcherBrokenReason is why a watcher can't notify anymore, as found by the
+// periodic health checks of what it watches.
+type WatcherBrokenReason int64
+
+const (
+	WatcherNotBroken WatcherBrokenReason = iota
+	WatcherSubredditPrivate
+	WatcherSubredditQuarantined
+	WatcherSubredditUnavailable
+	WatcherUserSuspended
+	WatcherUserUnavailable
+	WatcherUserFollowersDisabled
+)
+
+func (wbr WatcherBrokenReason) String() string {
+	switch wbr {
+	case WatcherNotBroken:
+		return "none"
+	case WatcherSubredditPrivate:
+		return "subreddit_private"
+	case WatcherSubredditQuarantined:
+		return "subreddit_quarantined"
+	case WatcherSubredditUnavailable:
+		return "subreddit_unavailable"
+	case WatcherUserSuspended:
+		return "user_suspended"
+	case WatcherUserUnavailable:
+		return "user_unavailable"
+	case WatcherUserFollowersDisabled:
+		return "user_followers_disabled"
+	}
+
+	return "unknown"
+}
+
+const (
+	// How often subreddits and users with watchers get checked for still being watchable
+	WatcherHealthCheckInterval = 6 * time.Hour
+
+	MaxMultiSubredditWatcherSubreddits = 20
+	MaxWatcherNotificationWindow       = 24 * time.Hour
+	MaxWatcherCooldown                 = 24 * time.Hour
+	MaxWatcherSnooze                   = 30 * 24 * time.Hour
+	MaxWatcherPostAge                  = 7 * 24 * time.Hour
+	MaxWatcherDomains                  = 10
+)
+
+// Hosts like "i.redd.it", or reddit's "self.<subreddit>" with a trailing "*" allowed.
+// A bare word like "youtube" is what older watchers have, and is still allowed.
+var watcherDomainRegexp = regexp.MustCompile(`^[a-z0-9_-]+(\.[a-z0-9_-]+)*(\.\*)?$`)
+
 func (wt WatcherType) String() string {
 	switch wt {
 	case SubredditWatcher:
@@ -24,6 +200,10 @@ func (wt WatcherType) String() string {
 		return "user"
 	case TrendingWatcher:
 		return "trending"
+	case MultiSubredditWatcher:
+		return "multi"
+	case FeedWatcher:
+		return "feed"
 	}
 
 	return "unknown"
@@ -49,9 +229,68 @@ type Watcher struct {
 	Domain    string
 	Hits      int64
 
+	// How eagerly trending watchers consider a post to be trending
+	TrendingSensitivity TrendingSensitivity
+
+	// Whether user watchers follow posts, comments or both
+	UserActivity UserWatcherActivity
+
+	// Post metadata, zero values match every post
+	MediaKind   WatcherMediaKind
+	PostKind    WatcherPostKind
+	NSFW        WatcherNSFW
+	MinComments int64         // minimum number of comments
+	MaxAge      time.Duration // maximum time since the post was submitted
+
+	// Rate limiting
+	MaxNotifications    int64         // notifications allowed per window, 0 for unlimited
+	NotificationWindow  time.Duration // window MaxNotifications applies to
+	Cooldown            time.Duration // minimum time between two notifications
+	SummarizeSuppressed bool          // send a summary of suppressed hits once the window resets
+
+	// Scheduling, zero values mean never
+	SnoozedUntil   time.Time
+	ExpiresAt      time.Time
+	NotifyOnExpiry bool
+
+	// Health, set when what the watcher watches stops being watchable
+	BrokenReason   WatcherBrokenReason
+	BrokenAt       time.Time
+	NotifyOnBroken bool
+
 	// Related models
-	Device  Device
-	Account Account
+	Device     Device
+	Account    Account
+	Subreddits []Subreddit
+}
+
+// SubredditIDs returns the IDs of the subreddits a multi-subreddit watcher covers.
+func (w *Watcher) SubredditIDs() []int64 {
+	ids := make([]int64, len(w.Subreddits))
+	for i, sr := range w.Subreddits {
+		ids[i] = sr.ID
+	}
+	return ids
+}
+
+// RateLimited returns whether notifications for this watcher are subject to a rate limit or cooldown.
+func (w *Watcher) RateLimited() bool {
+	return w.MaxNotifications > 0 || w.Cooldown > 0
+}
+
+// Snoozed returns whether the watcher is paused at the given time.
+func (w *Watcher) Snoozed(now time.Time) bool {
+	return now.Before(w.SnoozedUntil)
+}
+
+// Broken returns whether the last health check found the watcher can't notify anymore.
+func (w *Watcher) Broken() bool {
+	return w.BrokenReason != WatcherNotBroken
+}
+
+// Expired returns whether the watcher has outlived its expiry at the given time.
+func (w *Watcher) Expired(now time.Time) bool {
+	return !w.ExpiresAt.IsZero() && !now.Before(w.ExpiresAt)
 }
 
 func (w *Watcher) KeywordMatches(haystack string) bool {
@@ -74,11 +313,109 @@ func (w *Watcher) KeywordMatches(haystack string) bool {
 	return true
 }
 
+// Domains returns the domains a watcher is restricted to. Entries may be
+// separated by commas, pluses or spaces, and are reduced to a bare host.
+func (w *Watcher) Domains() []string {
+	entries := strings.FieldsFunc(strings.ToLower(w.Domain), func(r rune) bool {
+		return r == '+' || r == ',' || r == ' '
+	})
+
+	domains := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		// People tend to paste whole links rather than hosts
+		if strings.Contains(entry, "://") {
+			if u, err := url.Parse(entry); err == nil {
+				entry = u.Hostname()
+			}
+		} else if i := strings.IndexByte(entry, '/'); i > 0 {
+			entry = entry[:i]
+		}
+
+		entry = strings.TrimPrefix(strings.Trim(entry, "./"), "www.")
+		if entry != "" {
+			domains = append(domains, entry)
+		}
+	}
+
+	return domains
+}
+
+// DomainMatches returns whether any of the given hosts falls under one of the
+// watcher's domains. A domain covers itself and all of its subdomains, so
+// "redd.it" covers "i.redd.it". A domain ending in ".*" covers every host
+// starting with what comes before it, which is mostly useful for reddit's
+// "self.<subreddit>" domains. A bare word without any dots, which is what
+// watchers had before domains were hosts, covers every host it's part of.
+func (w *Watcher) DomainMatches(hosts ...string) bool {
+	domains := w.Domains()
+	if len(domains) == 0 {
+		return true
+	}
+
+	for _, host := range hosts {
+		host = strings.ToLower(host)
+		if host == "" {
+			continue
+		}
+
+		for _, domain := range domains {
+			if prefix := strings.TrimSuffix(domain, "*"); prefix != domain {
+				if strings.HasPrefix(host, prefix) {
+					return true
+				}
+				continue
+			}
+
+			if !strings.Contains(domain, ".") {
+				if strings.Contains(host, domain) {
+					return true
+				}
+				continue
+			}
+
+			if host == domain || strings.HasSuffix(host, "."+domain) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// ValidWatcherDomains is a validation rule for a watcher's list of domains.
+func ValidWatcherDomains(value interface{}) error {
+	domains := (&Watcher{Domain: value.(string)}).Domains()
+	if len(domains) > MaxWatcherDomains {
+		return fmt.Errorf("at most %d domains are allowed", MaxWatcherDomains)
+	}
+
+	for _, domain := range domains {
+		if !watcherDomainRegexp.MatchString(domain) {
+			return fmt.Errorf("%q is not a valid domain", domain)
+		}
+	}
+
+	return nil
+}
+
 func (w *Watcher) Validate() error {
 	return validation.ValidateStruct(w,
 		validation.Field(&w.Label, validation.Required, validation.Length(1, 64)),
-		validation.Field(&w.Type, validation.In(SubredditWatcher, UserWatcher, TrendingWatcher)),
-		validation.Field(&w.WatcheeID, validation.Required),
+		validation.Field(&w.Type, validation.In(SubredditWatcher, UserWatcher, TrendingWatcher, MultiSubredditWatcher, FeedWatcher)),
+		validation.Field(&w.WatcheeID, validation.Required.When(w.Type != MultiSubredditWatcher)),
+		validation.Field(&w.Subreddits, validation.Required.When(w.Type == MultiSubredditWatcher), validation.Length(2, MaxMultiSubredditWatcherSubreddits)),
+		validation.Field(&w.Keyword, validation.Required.When(w.Type == FeedWatcher)),
+		validation.Field(&w.Domain, validation.Length(0, 128), validation.By(ValidWatcherDomains)),
+		validation.Field(&w.TrendingSensitivity, validation.In(NormalTrendingSensitivity, LowTrendingSensitivity, HighTrendingSensitivity)),
+		validation.Field(&w.UserActivity, validation.In(UserWatcherPosts, UserWatcherComments, UserWatcherPostsAndComments)),
+		validation.Field(&w.MediaKind, validation.In(AnyWatcherMedia, ImageWatcherMedia, VideoWatcherMedia, GalleryWatcherMedia)),
+		validation.Field(&w.PostKind, validation.In(AnyWatcherPostKind, SelfWatcherPostKind, LinkWatcherPostKind)),
+		validation.Field(&w.NSFW, validation.In(AnyWatcherNSFW, OnlyWatcherNSFW, ExcludeWatcherNSFW)),
+		validation.Field(&w.MinComments, validation.Min(int64(0))),
+		validation.Field(&w.MaxAge, validation.Min(time.Duration(0)), validation.Max(MaxWatcherPostAge)),
+		validation.Field(&w.MaxNotifications, validation.Min(int64(0))),
+		validation.Field(&w.NotificationWindow, validation.Required.When(w.MaxNotifications > 0), validation.Min(time.Duration(0)), validation.Max(MaxWatcherNotificationWindow)),
+		validation.Field(&w.Cooldown, validation.Min(time.Duration(0)), validation.Max(MaxWatcherCooldown)),
 	)
 }
 
@@ -87,11 +424,25 @@ type WatcherRepository interface {
 	GetBySubredditID(ctx context.Context, id int64) ([]Watcher, error)
 	GetByUserID(ctx context.Context, id int64) ([]Watcher, error)
 	GetByTrendingSubredditID(ctx context.Context, id int64) ([]Watcher, error)
+	GetByFeedID(ctx context.Context, id int64) ([]Watcher, error)
 	GetByDeviceAPNSTokenAndAccountRedditID(ctx context.Context, apns string, rid string) ([]Watcher, error)
 
+	// These include snoozed, expired and muted watchers, the others only return active ones
+	GetAllBySubredditID(ctx context.Context, id int64) ([]Watcher, error)
+	GetAllByUserID(ctx context.Context, id int64) ([]Watcher, error)
+
 	Create(ctx context.Context, watcher *Watcher) error
 	Update(ctx context.Context, watcher *Watcher) error
 	IncrementHits(ctx context.Context, id int64) error
 	Delete(ctx context.Context, id int64) error
+	DeleteExpired(ctx context.Context, before time.Time) ([]Watcher, error)
 	DeleteByTypeAndWatcheeID(context.Context, WatcherType, int64) error
+
+	// These record the outcome of a health check and return the watchers whose status changed
+	SetBrokenBySubredditID(ctx context.Context, id int64, reason WatcherBrokenReason) ([]Watcher, error)
+	SetBrokenByUserID(ctx context.Context, id int64, reason WatcherBrokenReason) ([]Watcher, error)
+
+	CreateHit(ctx context.Context, hit *WatcherHit) error
+	GetHits(ctx context.Context, watcherID int64, before int64, limit int) ([]WatcherHit, error)
+	DeleteHitsBefore(ctx context.Context, before time.Time) (int64, error)
 }
@@ -0,0 +1,42 @@
+package domain
+
+import "time"
+
+const (
+	WatcherHitRetention     = 30 * 24 * time.Hour // how long a watcher's hit history is kept
+	DefaultWatcherHitsLimit = 25
+	MaxWatcherHitsLimit     = 100
+)
+
+type WatcherHitStatus int64
+
+const (
+	WatcherHitDelivered WatcherHitStatus = iota
+	WatcherHitFailed
+	WatcherHitSuppressed
+)
+
+func (s WatcherHitStatus) String() string {
+	switch s {
+	case WatcherHitDelivered:
+		return "delivered"
+	case WatcherHitFailed:
+		return "failed"
+	case WatcherHitSuppressed:
+		return "suppressed"
+	}
+
+	return "unknown"
+}
+
+// WatcherHit is a post or comment a watcher matched, and what became of the notification.
+type WatcherHit struct {
+	ID        int64
+	WatcherID int64
+	PostID    string
+	Title     string
+	Subreddit string
+	Author    string
+	MatchedAt time.Time
+	Status    WatcherHitStatus
+}
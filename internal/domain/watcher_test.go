@@ -2,6 +2,7 @@ package domain_test
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
@@ -35,3 +36,172 @@ func TestWatcherKeywordMatches(t *testing.T) {
 		})
 	}
 }
+
+func TestWatcherDomains(t *testing.T) {
+	t.Parallel()
+
+	tt := map[string]struct {
+		domain string
+		want   []string
+	}{
+		"empty":      {"", []string{}},
+		"single":     {"YouTube.com", []string{"youtube.com"}},
+		"list":       {"github.com, gitlab.com+bitbucket.org", []string{"github.com", "gitlab.com", "bitbucket.org"}},
+		"www prefix": {"www.nytimes.com", []string{"nytimes.com"}},
+		"whole link": {"https://www.theverge.com/tech", []string{"theverge.com"}},
+		"with path":  {"nytimes.com/2023/", []string{"nytimes.com"}},
+		"bare word":  {"youtube", []string{"youtube"}},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			w := &domain.Watcher{Domain: tc.domain}
+			assert.Equal(t, tc.want, w.Domains())
+		})
+	}
+}
+
+func TestWatcherValidate(t *testing.T) {
+	t.Parallel()
+
+	subreddits := []domain.Subreddit{{ID: 1, Name: "pics"}, {ID: 2, Name: "aww"}}
+
+	tt := map[string]struct {
+		watcher domain.Watcher
+		err     bool
+	}{
+		"subreddit watcher":                   {domain.Watcher{Label: "pics", Type: domain.SubredditWatcher, WatcheeID: 1}, false},
+		"subreddit watcher without watchee":   {domain.Watcher{Label: "pics", Type: domain.SubredditWatcher}, true},
+		"multi watcher":                       {domain.Watcher{Label: "cute", Type: domain.MultiSubredditWatcher, Subreddits: subreddits}, false},
+		"multi watcher with single subreddit": {domain.Watcher{Label: "cute", Type: domain.MultiSubredditWatcher, Subreddits: subreddits[:1]}, true},
+		"feed watcher":                        {domain.Watcher{Label: "deals", Type: domain.FeedWatcher, WatcheeID: 1, Keyword: "keyboard"}, false},
+		"feed watcher without keyword":        {domain.Watcher{Label: "deals", Type: domain.FeedWatcher, WatcheeID: 1}, true},
+		"rate limited watcher":                {domain.Watcher{Label: "pics", Type: domain.SubredditWatcher, WatcheeID: 1, MaxNotifications: 5, NotificationWindow: time.Hour}, false},
+		"rate limited watcher without window": {domain.Watcher{Label: "pics", Type: domain.SubredditWatcher, WatcheeID: 1, MaxNotifications: 5}, true},
+		"window too long":                     {domain.Watcher{Label: "pics", Type: domain.SubredditWatcher, WatcheeID: 1, MaxNotifications: 5, NotificationWindow: 48 * time.Hour}, true},
+		"cooldown":                            {domain.Watcher{Label: "pics", Type: domain.SubredditWatcher, WatcheeID: 1, Cooldown: 10 * time.Minute}, false},
+		"cooldown too long":                   {domain.Watcher{Label: "pics", Type: domain.SubredditWatcher, WatcheeID: 1, Cooldown: 48 * time.Hour}, true},
+		"post metadata":                       {domain.Watcher{Label: "pics", Type: domain.SubredditWatcher, WatcheeID: 1, MediaKind: domain.VideoWatcherMedia, NSFW: domain.ExcludeWatcherNSFW, MinComments: 50, MaxAge: time.Hour}, false},
+		"unknown media kind":                  {domain.Watcher{Label: "pics", Type: domain.SubredditWatcher, WatcheeID: 1, MediaKind: domain.WatcherMediaKind(42)}, true},
+		"negative comment count":              {domain.Watcher{Label: "pics", Type: domain.SubredditWatcher, WatcheeID: 1, MinComments: -1}, true},
+		"domains":                             {domain.Watcher{Label: "pics", Type: domain.SubredditWatcher, WatcheeID: 1, Domain: "imgur.com,i.redd.it,self.*"}, false},
+		"invalid domain":                      {domain.Watcher{Label: "pics", Type: domain.SubredditWatcher, WatcheeID: 1, Domain: "img?ur.com"}, true},
+		"legacy bare word domain":             {domain.Watcher{Label: "pics", Type: domain.SubredditWatcher, WatcheeID: 1, Domain: "imgur"}, false},
+		"too many domains":                    {domain.Watcher{Label: "pics", Type: domain.SubredditWatcher, WatcheeID: 1, Domain: "a.io,b.io,c.io,d.io,e.io,f.io,g.io,h.io,i.io,j.io,k.io"}, true},
+		"max age too long":                    {domain.Watcher{Label: "pics", Type: domain.SubredditWatcher, WatcheeID: 1, MaxAge: 30 * 24 * time.Hour}, true},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			err := tc.watcher.Validate()
+
+			if tc.err {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestWatcherRateLimited(t *testing.T) {
+	t.Parallel()
+
+	tt := map[string]struct {
+		watcher domain.Watcher
+		want    bool
+	}{
+		"unlimited":         {domain.Watcher{}, false},
+		"max notifications": {domain.Watcher{MaxNotifications: 3, NotificationWindow: time.Hour}, true},
+		"cooldown":          {domain.Watcher{Cooldown: time.Minute}, true},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.want, tc.watcher.RateLimited())
+		})
+	}
+}
+
+func TestWatcherSnoozedAndExpired(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+
+	tt := map[string]struct {
+		watcher domain.Watcher
+		snoozed bool
+		expired bool
+	}{
+		"never":          {domain.Watcher{}, false, false},
+		"snoozed":        {domain.Watcher{SnoozedUntil: now.Add(time.Hour)}, true, false},
+		"snooze elapsed": {domain.Watcher{SnoozedUntil: now.Add(-time.Hour)}, false, false},
+		"expiring":       {domain.Watcher{ExpiresAt: now.Add(time.Hour)}, false, false},
+		"expired":        {domain.Watcher{ExpiresAt: now.Add(-time.Hour)}, false, true},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.snoozed, tc.watcher.Snoozed(now))
+			assert.Equal(t, tc.expired, tc.watcher.Expired(now))
+		})
+	}
+}
+
+func TestUserWatcherActivity(t *testing.T) {
+	t.Parallel()
+
+	tt := map[string]struct {
+		activity domain.UserWatcherActivity
+		posts    bool
+		comments bool
+	}{
+		"posts":    {domain.UserWatcherPosts, true, false},
+		"comments": {domain.UserWatcherComments, false, true},
+		"both":     {domain.UserWatcherPostsAndComments, true, true},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.posts, tc.activity.IncludesPosts())
+			assert.Equal(t, tc.comments, tc.activity.IncludesComments())
+		})
+	}
+}
+
+func TestWatcherHitStatusString(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "delivered", domain.WatcherHitDelivered.String())
+	assert.Equal(t, "failed", domain.WatcherHitFailed.String())
+	assert.Equal(t, "suppressed", domain.WatcherHitSuppressed.String())
+	assert.Equal(t, "unknown", domain.WatcherHitStatus(42).String())
+}
+
+func TestWatcherBroken(t *testing.T) {
+	t.Parallel()
+
+	assert.False(t, (&domain.Watcher{}).Broken())
+	assert.True(t, (&domain.Watcher{BrokenReason: domain.WatcherSubredditPrivate}).Broken())
+
+	assert.Equal(t, "none", domain.WatcherNotBroken.String())
+	assert.Equal(t, "subreddit_quarantined", domain.WatcherSubredditQuarantined.String())
+	assert.Equal(t, "user_followers_disabled", domain.WatcherUserFollowersDisabled.String())
+	assert.Equal(t, "unknown", domain.WatcherBrokenReason(42).String())
+}
@@ -0,0 +1,123 @@
+package matcher
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/christianselig/apollo-backend/internal/domain"
+	"github.com/christianselig/apollo-backend/internal/reddit"
+)
+
+// Check is the outcome of evaluating a single watcher criterion against a post.
+type Check struct {
+	Criterion string
+	Expected  string
+	Actual    string
+	Matched   bool
+}
+
+// Result holds every criterion a watcher has set and whether the post satisfied it.
+type Result struct {
+	Matched bool
+	Checks  []Check
+}
+
+func (r *Result) check(criterion, expected, actual string, matched bool) {
+	r.Checks = append(r.Checks, Check{criterion, expected, actual, matched})
+	r.Matched = r.Matched && matched
+}
+
+// MissedOnly reports whether the post failed to match only because of the
+// given criteria, and so could still match if those are ones it can grow into.
+func (r *Result) MissedOnly(criteria ...string) bool {
+	if r.Matched {
+		return false
+	}
+
+	for _, check := range r.Checks {
+		if check.Matched {
+			continue
+		}
+
+		missed := false
+		for _, criterion := range criteria {
+			missed = missed || check.Criterion == criterion
+		}
+		if !missed {
+			return false
+		}
+	}
+	return true
+}
+
+// Match runs a post through a watcher's criteria. Only criteria the watcher
+// has set are checked, so a watcher without any matches every post.
+func Match(watcher *domain.Watcher, post *reddit.Thing) Result {
+	res := Result{Matched: true}
+
+	if watcher.Keyword != "" {
+		// Comments don't have a title, so we look for keywords in what they say instead
+		text := post.Title
+		if post.Kind == "t1" {
+			text = post.Body
+		}
+
+		res.check("keyword", watcher.Keyword, text, watcher.KeywordMatches(strings.ToLower(text)))
+	}
+
+	if watcher.Author != "" {
+		res.check("author", watcher.Author, post.Author, strings.ToLower(post.Author) == watcher.Author)
+	}
+
+	if watcher.Subreddit != "" {
+		res.check("subreddit", watcher.Subreddit, post.Subreddit, strings.ToLower(post.Subreddit) == watcher.Subreddit)
+	}
+
+	if watcher.Upvotes > 0 {
+		res.check("upvotes", strconv.FormatInt(watcher.Upvotes, 10), strconv.FormatInt(post.Score, 10), post.Score >= watcher.Upvotes)
+	}
+
+	if watcher.Flair != "" {
+		res.check("flair", watcher.Flair, post.Flair, strings.Contains(strings.ToLower(post.Flair), watcher.Flair))
+	}
+
+	if watcher.Domain != "" {
+		// Self posts link back to reddit itself, so only go by what reddit says their domain is
+		hosts := []string{post.Domain}
+		if !post.IsSelf {
+			hosts = append(hosts, post.Host())
+		}
+
+		res.check("domain", watcher.Domain, strings.TrimSpace(strings.Join(hosts, " ")), watcher.DomainMatches(hosts...))
+	}
+
+	if watcher.MediaKind != domain.AnyWatcherMedia {
+		res.check("media", watcher.MediaKind.String(), post.MediaKind(), post.MediaKind() == watcher.MediaKind.String())
+	}
+
+	if watcher.PostKind != domain.AnyWatcherPostKind {
+		kind := domain.LinkWatcherPostKind
+		if post.IsSelf {
+			kind = domain.SelfWatcherPostKind
+		}
+
+		res.check("kind", watcher.PostKind.String(), kind.String(), kind == watcher.PostKind)
+	}
+
+	if watcher.NSFW != domain.AnyWatcherNSFW {
+		nsfw := strconv.FormatBool(post.Over18)
+		res.check("nsfw", watcher.NSFW.String(), nsfw, post.Over18 == (watcher.NSFW == domain.OnlyWatcherNSFW))
+	}
+
+	if watcher.MinComments > 0 {
+		res.check("comments", strconv.FormatInt(watcher.MinComments, 10), strconv.Itoa(post.NumComments), int64(post.NumComments) >= watcher.MinComments)
+	}
+
+	if watcher.MaxAge > 0 {
+		age := time.Since(post.CreatedAt).Truncate(time.Second)
+		res.check("age", watcher.MaxAge.String(), age.String(), age <= watcher.MaxAge)
+	}
+
+	return res
+}
@@ -0,0 +1,159 @@
+package matcher_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/christianselig/apollo-backend/internal/domain"
+	"github.com/christianselig/apollo-backend/internal/matcher"
+	"github.com/christianselig/apollo-backend/internal/reddit"
+)
+
+func TestMatch(t *testing.T) {
+	t.Parallel()
+
+	post := &reddit.Thing{
+		Author:      "iamthatis",
+		Title:       "Apollo 1.15 is out with Live Activities",
+		Subreddit:   "apolloapp",
+		Score:       420,
+		Flair:       "Announcement",
+		URL:         "https://www.reddit.com/r/apolloapp/comments/abc123",
+		Domain:      "self.apolloapp",
+		CreatedAt:   time.Now().Add(-2 * time.Hour),
+		NumComments: 64,
+		IsSelf:      true,
+	}
+
+	tt := map[string]struct {
+		watcher  domain.Watcher
+		matched  bool
+		criteria []string
+	}{
+		"no criteria":         {domain.Watcher{}, true, nil},
+		"keyword":             {domain.Watcher{Keyword: "live activities"}, true, []string{"keyword"}},
+		"missing keyword":     {domain.Watcher{Keyword: "widgets"}, false, []string{"keyword"}},
+		"author":              {domain.Watcher{Author: "iamthatis"}, true, []string{"author"}},
+		"wrong author":        {domain.Watcher{Author: "spez"}, false, []string{"author"}},
+		"subreddit":           {domain.Watcher{Subreddit: "apolloapp"}, true, []string{"subreddit"}},
+		"upvotes":             {domain.Watcher{Upvotes: 100}, true, []string{"upvotes"}},
+		"not enough upvotes":  {domain.Watcher{Upvotes: 1000}, false, []string{"upvotes"}},
+		"flair":               {domain.Watcher{Flair: "announce"}, true, []string{"flair"}},
+		"domain":              {domain.Watcher{Domain: "self.apolloapp"}, true, []string{"domain"}},
+		"self post on reddit": {domain.Watcher{Domain: "reddit.com"}, false, []string{"domain"}},
+		"media":               {domain.Watcher{MediaKind: domain.ImageWatcherMedia}, false, []string{"media"}},
+		"self post":           {domain.Watcher{PostKind: domain.SelfWatcherPostKind}, true, []string{"kind"}},
+		"link post":           {domain.Watcher{PostKind: domain.LinkWatcherPostKind}, false, []string{"kind"}},
+		"only nsfw":           {domain.Watcher{NSFW: domain.OnlyWatcherNSFW}, false, []string{"nsfw"}},
+		"exclude nsfw":        {domain.Watcher{NSFW: domain.ExcludeWatcherNSFW}, true, []string{"nsfw"}},
+		"comments":            {domain.Watcher{MinComments: 50}, true, []string{"comments"}},
+		"not enough comments": {domain.Watcher{MinComments: 100}, false, []string{"comments"}},
+		"recent enough":       {domain.Watcher{MaxAge: 3 * time.Hour}, true, []string{"age"}},
+		"too old":             {domain.Watcher{MaxAge: time.Hour}, false, []string{"age"}},
+		"one failing criterion": {
+			domain.Watcher{Keyword: "apollo", Author: "iamthatis", Upvotes: 1000},
+			false,
+			[]string{"keyword", "author", "upvotes"},
+		},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			res := matcher.Match(&tc.watcher, post)
+			assert.Equal(t, tc.matched, res.Matched)
+
+			var criteria []string
+			for _, check := range res.Checks {
+				criteria = append(criteria, check.Criterion)
+			}
+			assert.Equal(t, tc.criteria, criteria)
+		})
+	}
+}
+
+func TestMatchDomain(t *testing.T) {
+	t.Parallel()
+
+	tt := map[string]struct {
+		domain string
+		post   reddit.Thing
+		want   bool
+	}{
+		"exact host":             {"youtube.com", reddit.Thing{URL: "https://youtube.com/watch?v=abc123", Domain: "youtube.com"}, true},
+		"subdomain":              {"youtube.com", reddit.Thing{URL: "https://m.youtube.com/watch?v=abc123", Domain: "m.youtube.com"}, true},
+		"www is ignored":         {"www.youtube.com", reddit.Thing{URL: "https://youtube.com/watch?v=abc123", Domain: "youtube.com"}, true},
+		"suffix of another host": {"x.com", reddit.Thing{URL: "https://netflix.com/title/123", Domain: "netflix.com"}, false},
+		"only in query string":   {"x.com", reddit.Thing{URL: "https://example.org/share?via=x.com", Domain: "example.org"}, false},
+		"reddit hosted image":    {"i.redd.it", reddit.Thing{URL: "https://i.redd.it/abc123.png", Domain: "i.redd.it"}, true},
+		"any reddit media":       {"redd.it", reddit.Thing{URL: "https://v.redd.it/abc123", Domain: "v.redd.it"}, true},
+		"any self post":          {"self.*", reddit.Thing{IsSelf: true, URL: "https://www.reddit.com/r/apolloapp/comments/abc123", Domain: "self.apolloapp"}, true},
+		"self post of subreddit": {"self.apolloapp", reddit.Thing{IsSelf: true, Domain: "self.ApolloApp"}, true},
+		"one of several":         {"github.com, gitlab.com", reddit.Thing{URL: "https://gitlab.com/apollo", Domain: "gitlab.com"}, true},
+		"none of several":        {"github.com,gitlab.com", reddit.Thing{URL: "https://bitbucket.org/apollo", Domain: "bitbucket.org"}, false},
+		"legacy bare word":       {"youtube", reddit.Thing{URL: "https://m.youtube.com/watch?v=abc123", Domain: "m.youtube.com"}, true},
+		"legacy word elsewhere":  {"youtube", reddit.Thing{URL: "https://example.org/share?via=youtube", Domain: "example.org"}, false},
+		"legacy value with path": {"youtube.com/watch", reddit.Thing{URL: "https://youtube.com/watch?v=abc123", Domain: "youtube.com"}, true},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			watcher := &domain.Watcher{Domain: tc.domain}
+			assert.Equal(t, tc.want, matcher.Match(watcher, &tc.post).Matched)
+		})
+	}
+}
+
+func TestResultMissedOnly(t *testing.T) {
+	t.Parallel()
+
+	post := &reddit.Thing{
+		Title:       "Apollo 1.15 is out with Live Activities",
+		Score:       42,
+		NumComments: 8,
+	}
+
+	tt := map[string]struct {
+		watcher domain.Watcher
+		want    bool
+	}{
+		"matched":                 {domain.Watcher{Upvotes: 10}, false},
+		"not enough upvotes":      {domain.Watcher{Upvotes: 100}, true},
+		"not enough of both":      {domain.Watcher{Upvotes: 100, MinComments: 50}, true},
+		"wrong keyword":           {domain.Watcher{Keyword: "widgets", Upvotes: 100}, false},
+		"right keyword":           {domain.Watcher{Keyword: "apollo", MinComments: 50}, true},
+		"no threshold to grow to": {domain.Watcher{Keyword: "widgets"}, false},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			res := matcher.Match(&tc.watcher, post)
+			assert.Equal(t, tc.want, res.MissedOnly("upvotes", "comments"))
+		})
+	}
+}
+
+func TestMatchComment(t *testing.T) {
+	t.Parallel()
+
+	comment := &reddit.Thing{
+		Kind:      "t1",
+		Author:    "iamthatis",
+		Body:      "Should be fixed in the next update!",
+		Subreddit: "apolloapp",
+		LinkTitle: "Apollo 1.15 is out with Live Activities",
+	}
+
+	assert.True(t, matcher.Match(&domain.Watcher{Keyword: "next update"}, comment).Matched)
+	assert.False(t, matcher.Match(&domain.Watcher{Keyword: "live activities"}, comment).Matched)
+}
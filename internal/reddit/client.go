@@ -15,6 +15,7 @@ import (
 	"github.com/valyala/fastjson"
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/singleflight"
 )
 
 const (
@@ -34,6 +35,7 @@ type Client struct {
 	pool        *fastjson.ParserPool
 	statsd      statsd.ClientInterface
 	redis       *redis.Client
+	listings    *singleflight.Group
 	defaultOpts []RequestOption
 }
 
@@ -115,6 +117,7 @@ func NewClient(id, secret string, tracer trace.Tracer, statsd statsd.ClientInter
 		pool,
 		statsd,
 		redis,
+		&singleflight.Group{},
 		opts,
 	}
 }
@@ -220,6 +223,10 @@ func (rc *Client) request(ctx context.Context, r *Request, errmap map[int]error,
 		return empty, nil
 	}
 
+	return rc.parse(bb, rh)
+}
+
+func (rc *Client) parse(bb []byte, rh ResponseHandler) (interface{}, error) {
 	parser := rc.pool.Get()
 	defer rc.pool.Put(parser)
 
@@ -307,6 +314,20 @@ func (rac *AuthenticatedClient) ObfuscatedRefreshToken() string {
 }
 
 func (rac *AuthenticatedClient) request(ctx context.Context, r *Request, errmap map[int]error, rh ResponseHandler, empty interface{}) (interface{}, error) {
+	bb, err := rac.requestBytes(ctx, r, errmap)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.emptyResponseBytes > 0 && len(bb) == r.emptyResponseBytes {
+		return empty, nil
+	}
+
+	return rac.client.parse(bb, rh)
+}
+
+// requestBytes makes a request, retrying if needed, and returns the raw response body.
+func (rac *AuthenticatedClient) requestBytes(ctx context.Context, r *Request, errmap map[int]error) ([]byte, error) {
 	if rac.isRateLimited() {
 		return nil, ErrRateLimited
 	}
@@ -351,19 +372,7 @@ func (rac *AuthenticatedClient) request(ctx context.Context, r *Request, errmap
 		_ = rac.markRateLimited(rli)
 	}
 
-	if r.emptyResponseBytes > 0 && len(bb) == r.emptyResponseBytes {
-		return empty, nil
-	}
-
-	parser := rac.client.pool.Get()
-	defer rac.client.pool.Put(parser)
-
-	val, err := parser.ParseBytes(bb)
-	if err != nil {
-		return nil, err
-	}
-
-	return rh(val), nil
+	return bb, nil
 }
 
 //nolint:unparam
@@ -490,6 +499,24 @@ func (rac *AuthenticatedClient) UserPosts(ctx context.Context, user string, opts
 	return lr.(*ListingResponse), nil
 }
 
+func (rac *AuthenticatedClient) UserComments(ctx context.Context, user string, opts ...RequestOption) (*ListingResponse, error) {
+	url := fmt.Sprintf("https://oauth.reddit.com/u/%s/comments", user)
+	opts = append(rac.client.defaultOpts, opts...)
+	opts = append(opts, []RequestOption{
+		WithMethod("GET"),
+		WithToken(rac.accessToken),
+		WithURL(url),
+	}...)
+	req := NewRequest(opts...)
+
+	lr, err := rac.request(ctx, req, defaultErrorMap, NewListingResponse, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return lr.(*ListingResponse), nil
+}
+
 func (rac *AuthenticatedClient) UserAbout(ctx context.Context, user string, opts ...RequestOption) (*UserResponse, error) {
 	url := fmt.Sprintf("https://oauth.reddit.com/u/%s/about", user)
 	opts = append(rac.client.defaultOpts, opts...)
@@ -502,6 +529,9 @@ func (rac *AuthenticatedClient) UserAbout(ctx context.Context, user string, opts
 	ur, err := rac.request(ctx, req, defaultErrorMap, NewUserResponse, nil)
 
 	if err != nil {
+		if err == ErrSubredditNotFound {
+			return nil, ErrUserNotFound
+		}
 		return nil, err
 	}
 
@@ -549,7 +579,7 @@ func (rac *AuthenticatedClient) subredditPosts(ctx context.Context, subreddit st
 	}...)
 	req := NewRequest(opts...)
 
-	lr, err := rac.request(ctx, req, defaultErrorMap, NewListingResponse, nil)
+	lr, err := rac.cachedListing(ctx, subreddit, sort, req)
 	if err != nil {
 		return nil, err
 	}
@@ -569,6 +599,25 @@ func (rac *AuthenticatedClient) SubredditNew(ctx context.Context, subreddit stri
 	return rac.subredditPosts(ctx, subreddit, "new", opts...)
 }
 
+func (rac *AuthenticatedClient) FeedNew(ctx context.Context, path string, opts ...RequestOption) (*ListingResponse, error) {
+	url := fmt.Sprintf("https://oauth.reddit.com/%s/new", path)
+	opts = append(rac.client.defaultOpts, opts...)
+	opts = append(opts, []RequestOption{
+		WithTags([]string{"url:/feed/new"}),
+		WithMethod("GET"),
+		WithToken(rac.accessToken),
+		WithURL(url),
+	}...)
+	req := NewRequest(opts...)
+
+	lr, err := rac.request(ctx, req, defaultErrorMap, NewListingResponse, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return lr.(*ListingResponse), nil
+}
+
 func (rac *AuthenticatedClient) MessageInbox(ctx context.Context, opts ...RequestOption) (*ListingResponse, error) {
 	opts = append(rac.client.defaultOpts, opts...)
 	opts = append(opts, []RequestOption{
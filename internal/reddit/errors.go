@@ -31,6 +31,8 @@ var (
 	ErrSubredditIsQuarantined = errors.New("subreddit is quarantined")
 	// ErrSubredditNotFound .
 	ErrSubredditNotFound = errors.New("subreddit not found")
+	// ErrUserNotFound .
+	ErrUserNotFound = errors.New("user not found")
 	// ErrTooManyRequests .
 	ErrTooManyRequests = errors.New("too many requests")
 )
@@ -0,0 +1,133 @@
+package reddit
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// Long enough for the subreddit and trending workers to share a fetch, short enough to not miss much
+	ListingCacheTTL = 30 * time.Second
+
+	// How long a worker may hold on to fetching a listing before others give up waiting on it
+	listingLockTTL = 10 * time.Second
+
+	listingLockPollInterval = 50 * time.Millisecond
+
+	// How long a shared fetch, waiting on the lock included, can take at most
+	listingFetchTimeout = 2 * listingLockTTL
+)
+
+// releaseListingLock only lets go of the lock if it's still ours, it may have
+// expired and been taken by someone else during a slow fetch.
+var releaseListingLock = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+func listingCacheKey(subreddit, sort string, r *Request) string {
+	return fmt.Sprintf("reddit:listings:%s:%s:%s", strings.ToLower(subreddit), sort, r.query.Encode())
+}
+
+// cachedListing serves subreddit listings out of a short lived Redis cache.
+// Consumers within a process share a single fetch, and across processes the
+// first one to miss fetches while the others wait for it to fill the cache.
+// Listings are requested with show=all, so they're the same whoever asks.
+//
+// The fetch doesn't belong to whoever started it, so it runs on its own
+// context, and callers only stop waiting on it when theirs is done. It's also
+// made with the first caller's token, so only its bodies are shared: when it
+// fails, everyone else fetches with their own token rather than taking on an
+// error, like a revoked token, that isn't theirs.
+func (rac *AuthenticatedClient) cachedListing(ctx context.Context, subreddit, sort string, r *Request) (interface{}, error) {
+	if rac.client.redis == nil {
+		return rac.request(ctx, r, defaultErrorMap, NewListingResponse, nil)
+	}
+
+	key := listingCacheKey(subreddit, sort, r)
+	tags := []string{fmt.Sprintf("sort:%s", sort)}
+
+	owner := false
+	ch := rac.client.listings.DoChan(key, func() (interface{}, error) {
+		owner = true
+
+		ctx, cancel := context.WithTimeout(context.Background(), listingFetchTimeout)
+		defer cancel()
+
+		if bb, err := rac.client.redis.Get(ctx, key).Bytes(); err == nil {
+			_ = rac.client.statsd.Incr("reddit.cache.hits", tags, 0.1)
+			return bb, nil
+		}
+
+		token, err := listingLockToken()
+		if err != nil {
+			return nil, err
+		}
+
+		lock := key + ":lock"
+		for {
+			locked, err := rac.client.redis.SetNX(ctx, lock, token, listingLockTTL).Result()
+			if err != nil || locked {
+				break
+			}
+
+			// Someone else is fetching it, wait for them to fill the cache or give up on it
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(listingLockPollInterval):
+			}
+
+			if bb, err := rac.client.redis.Get(ctx, key).Bytes(); err == nil {
+				_ = rac.client.statsd.Incr("reddit.cache.hits", tags, 0.1)
+				return bb, nil
+			}
+		}
+		defer func() { _ = releaseListingLock.Run(ctx, rac.client.redis, []string{lock}, token).Err() }()
+
+		_ = rac.client.statsd.Incr("reddit.cache.misses", tags, 0.1)
+
+		bb, err := rac.requestBytes(ctx, r, defaultErrorMap)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := rac.client.redis.Set(ctx, key, bb, ListingCacheTTL).Err(); err != nil && err != redis.Nil {
+			_ = rac.client.statsd.Incr("reddit.cache.errors", tags, 0.1)
+		}
+
+		return bb, nil
+	})
+
+	var res singleflight.Result
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case res = <-ch:
+	}
+	if res.Err != nil {
+		if owner {
+			return nil, res.Err
+		}
+		return rac.request(ctx, r, defaultErrorMap, NewListingResponse, nil)
+	}
+
+	return rac.client.parse(res.Val.([]byte), NewListingResponse)
+}
+
+func listingLockToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,211 @@
+package reddit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-go/statsd"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+)
+
+const testListing = `{"kind":"Listing","data":{"after":"t3_abc123","before":null,"children":[]}}`
+
+type listingCacheTest struct {
+	rac   *AuthenticatedClient
+	redis *miniredis.Miniredis
+	req   *Request
+	key   string
+	hits  *int32
+}
+
+func newListingCacheTest(t *testing.T, delay time.Duration) listingCacheTest {
+	t.Helper()
+
+	var hits int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		time.Sleep(delay)
+		_, _ = w.Write([]byte(testListing))
+	}))
+	t.Cleanup(srv.Close)
+
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = rdb.Close() })
+
+	rc := NewClient("<ID>", "<SECRET>", otel.Tracer("test"), &statsd.NoOpClient{}, rdb, 1)
+	req := NewRequest(WithURL(srv.URL), WithQuery("limit", "100"))
+
+	return listingCacheTest{
+		rac:   rc.NewAuthenticatedClient("<ID>", "<REFRESH>", "<ACCESS>"),
+		redis: mr,
+		req:   req,
+		key:   listingCacheKey("apolloapp", "new", req),
+		hits:  &hits,
+	}
+}
+
+func (lct listingCacheTest) fetch(ctx context.Context) (*ListingResponse, error) {
+	lr, err := lct.rac.cachedListing(ctx, "apolloapp", "new", lct.req)
+	if err != nil {
+		return nil, err
+	}
+	return lr.(*ListingResponse), nil
+}
+
+func TestCachedListingHit(t *testing.T) {
+	t.Parallel()
+
+	lct := newListingCacheTest(t, 0)
+	require.NoError(t, lct.redis.Set(lct.key, testListing))
+
+	lr, err := lct.fetch(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "t3_abc123", lr.After)
+	assert.Equal(t, int32(0), atomic.LoadInt32(lct.hits))
+}
+
+func TestCachedListingMissFillsCache(t *testing.T) {
+	t.Parallel()
+
+	lct := newListingCacheTest(t, 0)
+
+	for i := 0; i < 2; i++ {
+		lr, err := lct.fetch(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, "t3_abc123", lr.After)
+	}
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(lct.hits))
+	assert.True(t, lct.redis.Exists(lct.key))
+	assert.False(t, lct.redis.Exists(lct.key+":lock"))
+}
+
+func TestCachedListingCoalesces(t *testing.T) {
+	t.Parallel()
+
+	lct := newListingCacheTest(t, 100*time.Millisecond)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			_, err := lct.fetch(context.Background())
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(lct.hits))
+}
+
+func TestCachedListingOutlivesFirstCaller(t *testing.T) {
+	t.Parallel()
+
+	lct := newListingCacheTest(t, 200*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() {
+		_, err := lct.fetch(ctx)
+		errs <- err
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	assert.ErrorIs(t, <-errs, context.Canceled)
+
+	// The fetch carries on for whoever else is waiting on it
+	lr, err := lct.fetch(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "t3_abc123", lr.After)
+	assert.Equal(t, int32(1), atomic.LoadInt32(lct.hits))
+}
+
+func TestCachedListingRefetchesOwnerErrors(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "Bearer <REVOKED>" {
+			time.Sleep(100 * time.Millisecond)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(testListing))
+	}))
+	t.Cleanup(srv.Close)
+
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = rdb.Close() })
+
+	rc := NewClient("<ID>", "<SECRET>", otel.Tracer("test"), &statsd.NoOpClient{}, rdb, 1)
+	fetch := func(token string) (interface{}, error) {
+		rac := rc.NewAuthenticatedClient(SkipRateLimiting, "<REFRESH>", token)
+		req := NewRequest(WithURL(srv.URL), WithQuery("limit", "100"), WithToken(token))
+		return rac.cachedListing(context.Background(), "apolloapp", "new", req)
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := fetch("<REVOKED>")
+		errs <- err
+	}()
+
+	// Join the revoked fetch while it's still in flight
+	time.Sleep(20 * time.Millisecond)
+	lr, err := fetch("<VALID>")
+	require.NoError(t, err)
+	assert.Equal(t, "t3_abc123", lr.(*ListingResponse).After)
+
+	assert.ErrorIs(t, <-errs, ErrOauthRevoked)
+}
+
+func TestCachedListingWaitsOnLock(t *testing.T) {
+	t.Parallel()
+
+	lct := newListingCacheTest(t, 0)
+	require.NoError(t, lct.redis.Set(lct.key+":lock", "someone-else"))
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		_ = lct.redis.Set(lct.key, testListing)
+	}()
+
+	lr, err := lct.fetch(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "t3_abc123", lr.After)
+	assert.Equal(t, int32(0), atomic.LoadInt32(lct.hits))
+
+	lock, err := lct.redis.Get(lct.key + ":lock")
+	require.NoError(t, err)
+	assert.Equal(t, "someone-else", lock)
+}
+
+func TestReleaseListingLock(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	lct := newListingCacheTest(t, 0)
+	rdb := lct.rac.client.redis
+	lock := lct.key + ":lock"
+
+	require.NoError(t, lct.redis.Set(lock, "theirs"))
+
+	require.NoError(t, releaseListingLock.Run(ctx, rdb, []string{lock}, "ours").Err())
+	assert.True(t, lct.redis.Exists(lock))
+
+	require.NoError(t, releaseListingLock.Run(ctx, rdb, []string{lock}, "theirs").Err())
+	assert.False(t, lct.redis.Exists(lock))
+}
@@ -2,6 +2,8 @@ package reddit
 
 import (
 	"fmt"
+	"net/url"
+	"path"
 	"strings"
 	"time"
 
@@ -97,6 +99,8 @@ type Thing struct {
 	Context       string    `json:"context"`
 	ParentID      string    `json:"parent_id"`
 	LinkTitle     string    `json:"link_title"`
+	LinkID        string    `json:"link_id"`
+	Permalink     string    `json:"permalink"`
 	Destination   string    `json:"dest"`
 	Subreddit     string    `json:"subreddit"`
 	SubredditType string    `json:"subreddit_type"`
@@ -104,10 +108,18 @@ type Thing struct {
 	SelfText      string    `json:"selftext"`
 	Title         string    `json:"title"`
 	URL           string    `json:"url"`
+	Domain        string    `json:"domain"`
 	Flair         string    `json:"flair"`
 	Thumbnail     string    `json:"thumbnail"`
 	Over18        bool      `json:"over_18"`
 	NumComments   int       `json:"num_comments"`
+	IsSelf        bool      `json:"is_self"`
+	IsVideo       bool      `json:"is_video"`
+	IsGallery     bool      `json:"is_gallery"`
+	PostHint      string    `json:"post_hint"`
+	IsSubmitter   bool      `json:"is_submitter"`
+	Distinguished string    `json:"distinguished"`
+	AuthorFlair   string    `json:"author_flair_text"`
 }
 
 func (t *Thing) FullName() string {
@@ -118,6 +130,41 @@ func (t *Thing) IsDeleted() bool {
 	return t.Author == "[deleted]"
 }
 
+// Host returns the host a post links to, as opposed to Domain which is what
+// reddit reports and is "self.<subreddit>" for self posts.
+func (t *Thing) Host() string {
+	u, err := url.Parse(t.URL)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// MediaKind returns what kind of media a post links to: "image", "video",
+// "gallery", or an empty string for anything else.
+func (t *Thing) MediaKind() string {
+	switch {
+	case t.IsGallery:
+		return "gallery"
+	case t.IsVideo, strings.HasSuffix(t.PostHint, ":video"):
+		return "video"
+	case t.PostHint == "image":
+		return "image"
+	}
+
+	// Older posts and crossposts don't always carry a hint, so fall back to the URL
+	if u, err := url.Parse(t.URL); err == nil {
+		switch strings.ToLower(path.Ext(u.Path)) {
+		case ".jpg", ".jpeg", ".png", ".gif", ".webp":
+			return "image"
+		case ".mp4", ".gifv":
+			return "video"
+		}
+	}
+
+	return ""
+}
+
 func NewThing(val *fastjson.Value) *Thing {
 	t := &Thing{}
 
@@ -135,6 +182,8 @@ func NewThing(val *fastjson.Value) *Thing {
 	t.Context = string(data.GetStringBytes("context"))
 	t.ParentID = string(data.GetStringBytes("parent_id"))
 	t.LinkTitle = string(data.GetStringBytes("link_title"))
+	t.LinkID = string(data.GetStringBytes("link_id"))
+	t.Permalink = string(data.GetStringBytes("permalink"))
 	t.Destination = string(data.GetStringBytes("dest"))
 	t.Subreddit = string(data.GetStringBytes("subreddit"))
 	t.SubredditType = string(data.GetStringBytes("subreddit_type"))
@@ -143,10 +192,18 @@ func NewThing(val *fastjson.Value) *Thing {
 	t.Title = string(data.GetStringBytes("title"))
 	t.SelfText = string(data.GetStringBytes("selftext"))
 	t.URL = string(data.GetStringBytes("url"))
+	t.Domain = string(data.GetStringBytes("domain"))
 	t.Flair = string(data.GetStringBytes("link_flair_text"))
 	t.Thumbnail = string(data.GetStringBytes("thumbnail"))
 	t.Over18 = data.GetBool("over_18")
 	t.NumComments = data.GetInt("num_comments")
+	t.IsSelf = data.GetBool("is_self")
+	t.IsVideo = data.GetBool("is_video")
+	t.IsGallery = data.GetBool("is_gallery")
+	t.PostHint = string(data.GetStringBytes("post_hint"))
+	t.IsSubmitter = data.GetBool("is_submitter")
+	t.Distinguished = string(data.GetStringBytes("distinguished"))
+	t.AuthorFlair = string(data.GetStringBytes("author_flair_text"))
 
 	return t
 }
@@ -207,6 +264,7 @@ type UserResponse struct {
 	Thing
 
 	AcceptFollowers bool
+	Suspended       bool
 	Name            string
 }
 
@@ -218,6 +276,7 @@ func NewUserResponse(val *fastjson.Value) interface{} {
 	ur.ID = string(data.GetStringBytes("id"))
 	ur.Name = string(data.GetStringBytes("name"))
 	ur.AcceptFollowers = data.GetBool("accept_followers")
+	ur.Suspended = data.GetBool("is_suspended")
 
 	return ur
 }
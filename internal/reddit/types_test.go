@@ -159,6 +159,7 @@ func TestUserResponseParsing(t *testing.T) {
 	assert.Equal(t, "1ia22", u.ID)
 	assert.Equal(t, "changelog", u.Name)
 	assert.Equal(t, true, u.AcceptFollowers)
+	assert.Equal(t, false, u.Suspended)
 }
 
 func TestUserPostsParsing(t *testing.T) {
@@ -178,6 +179,45 @@ func TestUserPostsParsing(t *testing.T) {
 	post := ps.Children[0]
 
 	assert.Equal(t, "public", post.SubredditType)
+	assert.True(t, post.IsSelf)
+	assert.Equal(t, "self.apolloapp", post.Domain)
+	assert.Equal(t, "", post.MediaKind())
+
+	assert.False(t, ps.Children[2].IsSelf)
+	assert.Equal(t, "link", ps.Children[2].PostHint)
+	assert.Equal(t, "producthunt.com", ps.Children[2].Domain)
+	assert.Equal(t, "www.producthunt.com", ps.Children[2].Host())
+	assert.Equal(t, "", ps.Children[2].MediaKind())
+
+	assert.Equal(t, "image", ps.Children[8].MediaKind())
+	assert.True(t, ps.Children[14].IsVideo)
+	assert.Equal(t, "video", ps.Children[14].MediaKind())
+	assert.Equal(t, "video", ps.Children[24].MediaKind())
+}
+
+func TestUserCommentsParsing(t *testing.T) {
+	t.Parallel()
+
+	bb, err := ioutil.ReadFile("testdata/user_comments.json")
+	assert.NoError(t, err)
+
+	parser := NewTestParser(t)
+	val, err := parser.ParseBytes(bb)
+	assert.NoError(t, err)
+
+	ret := reddit.NewListingResponse(val)
+	cs := ret.(*reddit.ListingResponse)
+	assert.NotNil(t, cs)
+	assert.Equal(t, 2, cs.Count)
+
+	comment := cs.Children[0]
+
+	assert.Equal(t, "t1", comment.Kind)
+	assert.Equal(t, "hfh3q2c", comment.ID)
+	assert.Equal(t, "t3_q2m2nc", comment.LinkID)
+	assert.Equal(t, "/r/apolloapp/comments/q2m2nc/hey_you_are_you_having_issues_around_apollo_and/hfh3q2c/", comment.Permalink)
+	assert.Equal(t, "Hey you! Are you having issues around Apollo and iOS 15 light/dark mode switching? Can you share any details?", comment.LinkTitle)
+	assert.Equal(t, time.Unix(1633460516, 0).UTC(), comment.CreatedAt)
 }
 
 func TestThreadResponseParsing(t *testing.T) {
@@ -199,6 +239,9 @@ func TestThreadResponseParsing(t *testing.T) {
 
 	assert.Equal(t, "The Deck is a lot more portable than the Pi though.", tr.Children[0].Body)
 	assert.Equal(t, "PhonicUK", tr.Children[1].Author)
+	assert.False(t, tr.Children[1].IsSubmitter)
+	assert.Equal(t, "", tr.Children[1].Distinguished)
+	assert.Equal(t, "256GB", tr.Children[11].AuthorFlair)
 }
 
 func TestEmptyThreadResponseParsing(t *testing.T) {
@@ -218,3 +261,30 @@ func TestEmptyThreadResponseParsing(t *testing.T) {
 	assert.Equal(t, "So many knives… so little time.", tr.Post.Title)
 	assert.Equal(t, 0, len(tr.Children))
 }
+
+func TestThingMediaKind(t *testing.T) {
+	t.Parallel()
+
+	tt := map[string]struct {
+		thing reddit.Thing
+		want  string
+	}{
+		"self post":          {reddit.Thing{IsSelf: true, PostHint: "self", URL: "https://www.reddit.com/r/apolloapp/comments/abc123"}, ""},
+		"link":               {reddit.Thing{PostHint: "link", URL: "https://apolloapp.io"}, ""},
+		"image hint":         {reddit.Thing{PostHint: "image", URL: "https://i.redd.it/abc123.png"}, "image"},
+		"image without hint": {reddit.Thing{URL: "https://i.imgur.com/abc123.JPG"}, "image"},
+		"hosted video":       {reddit.Thing{IsVideo: true, URL: "https://v.redd.it/abc123"}, "video"},
+		"embedded video":     {reddit.Thing{PostHint: "rich:video", URL: "https://youtu.be/abc123"}, "video"},
+		"gifv":               {reddit.Thing{URL: "https://i.imgur.com/abc123.gifv"}, "video"},
+		"gallery":            {reddit.Thing{IsGallery: true, URL: "https://www.reddit.com/gallery/abc123"}, "gallery"},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.want, tc.thing.MediaKind())
+		})
+	}
+}
@@ -9,6 +9,9 @@ import (
 	"go.opentelemetry.io/otel/trace"
 )
 
+// Postgres' error code for a unique constraint being violated
+const pgUniqueViolation = "23505"
+
 type Connection interface {
 	Exec(context.Context, string, ...interface{}) (pgconn.CommandTag, error)
 	Query(context.Context, string, ...interface{}) (pgx.Rows, error)
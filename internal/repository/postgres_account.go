@@ -95,6 +95,27 @@ func (p *postgresAccountRepository) GetByRedditID(ctx context.Context, id string
 
 	return accs[0], nil
 }
+
+func (p *postgresAccountRepository) GetByUsername(ctx context.Context, username string) (domain.Account, error) {
+	query := `
+		SELECT id, username, reddit_account_id, access_token, refresh_token, token_expires_at,
+			last_message_id, next_notification_check_at, next_stuck_notification_check_at,
+			check_count, development
+		FROM accounts
+		WHERE LOWER(username) = LOWER($1) AND is_deleted IS FALSE`
+
+	accs, err := p.fetch(ctx, query, username)
+	if err != nil {
+		return domain.Account{}, err
+	}
+
+	if len(accs) == 0 {
+		return domain.Account{}, domain.ErrNotFound
+	}
+
+	return accs[0], nil
+}
+
 func (p *postgresAccountRepository) CreateOrUpdate(ctx context.Context, acc *domain.Account) error {
 	query := `
 		INSERT INTO accounts (username, reddit_account_id, access_token, refresh_token, token_expires_at,
@@ -2,8 +2,11 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"time"
 
+	"github.com/jackc/pgx/v5"
+
 	"github.com/christianselig/apollo-backend/internal/domain"
 )
 
@@ -31,6 +34,8 @@ func (p *postgresDeviceRepository) fetch(ctx context.Context, query string, args
 			&dev.Sandbox,
 			&dev.ExpiresAt,
 			&dev.GracePeriodExpiresAt,
+			&dev.PushToStartToken,
+			&dev.Secret,
 		); err != nil {
 			return nil, err
 		}
@@ -41,7 +46,7 @@ func (p *postgresDeviceRepository) fetch(ctx context.Context, query string, args
 
 func (p *postgresDeviceRepository) GetByID(ctx context.Context, id int64) (domain.Device, error) {
 	query := `
-		SELECT id, apns_token, sandbox, expires_at, grace_period_expires_at
+		SELECT id, apns_token, sandbox, expires_at, grace_period_expires_at, push_to_start_token, secret
 		FROM devices
 		WHERE id = $1`
 
@@ -58,7 +63,7 @@ func (p *postgresDeviceRepository) GetByID(ctx context.Context, id int64) (domai
 
 func (p *postgresDeviceRepository) GetByAPNSToken(ctx context.Context, token string) (domain.Device, error) {
 	query := `
-		SELECT id, apns_token, sandbox, expires_at, grace_period_expires_at
+		SELECT id, apns_token, sandbox, expires_at, grace_period_expires_at, push_to_start_token, secret
 		FROM devices
 		WHERE apns_token = $1`
 
@@ -75,7 +80,7 @@ func (p *postgresDeviceRepository) GetByAPNSToken(ctx context.Context, token str
 
 func (p *postgresDeviceRepository) GetByAccountID(ctx context.Context, id int64) ([]domain.Device, error) {
 	query := `
-		SELECT devices.id, apns_token, sandbox, expires_at, grace_period_expires_at
+		SELECT devices.id, apns_token, sandbox, expires_at, grace_period_expires_at, push_to_start_token, secret
 		FROM devices
 		INNER JOIN devices_accounts ON devices.id = devices_accounts.device_id
 		WHERE devices_accounts.account_id = $1`
@@ -85,7 +90,7 @@ func (p *postgresDeviceRepository) GetByAccountID(ctx context.Context, id int64)
 
 func (p *postgresDeviceRepository) GetInboxNotifiableByAccountID(ctx context.Context, id int64) ([]domain.Device, error) {
 	query := `
-		SELECT devices.id, apns_token, sandbox, expires_at, grace_period_expires_at
+		SELECT devices.id, apns_token, sandbox, expires_at, grace_period_expires_at, push_to_start_token, secret
 		FROM devices
 		INNER JOIN devices_accounts ON devices.id = devices_accounts.device_id
 		WHERE devices_accounts.account_id = $1 AND
@@ -97,7 +102,7 @@ func (p *postgresDeviceRepository) GetInboxNotifiableByAccountID(ctx context.Con
 
 func (p *postgresDeviceRepository) GetWatcherNotifiableByAccountID(ctx context.Context, id int64) ([]domain.Device, error) {
 	query := `
-		SELECT devices.id, apns_token, sandbox, expires_at, grace_period_expires_at
+		SELECT devices.id, apns_token, sandbox, expires_at, grace_period_expires_at, push_to_start_token, secret
 		FROM devices
 		INNER JOIN devices_accounts ON devices.id = devices_accounts.device_id
 		WHERE devices_accounts.account_id = $1 AND
@@ -107,6 +112,42 @@ func (p *postgresDeviceRepository) GetWatcherNotifiableByAccountID(ctx context.C
 	return p.fetch(ctx, query, id)
 }
 
+func (p *postgresDeviceRepository) GetMilestoneNotifiableByAccountID(ctx context.Context, id int64) ([]domain.DeviceMilestones, error) {
+	query := `
+		SELECT devices.id, apns_token, sandbox, expires_at, grace_period_expires_at, push_to_start_token, secret, upvote_milestones, comment_milestones
+		FROM devices
+		INNER JOIN devices_accounts ON devices.id = devices_accounts.device_id
+		WHERE devices_accounts.account_id = $1 AND
+		(cardinality(upvote_milestones) > 0 OR cardinality(comment_milestones) > 0) AND
+		grace_period_expires_at > NOW()`
+
+	rows, err := p.conn.Query(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var dms []domain.DeviceMilestones
+	for rows.Next() {
+		var dm domain.DeviceMilestones
+		if err := rows.Scan(
+			&dm.Device.ID,
+			&dm.Device.APNSToken,
+			&dm.Device.Sandbox,
+			&dm.Device.ExpiresAt,
+			&dm.Device.GracePeriodExpiresAt,
+			&dm.Device.PushToStartToken,
+			&dm.Device.Secret,
+			&dm.Milestones.Upvotes,
+			&dm.Milestones.Comments,
+		); err != nil {
+			return nil, err
+		}
+		dms = append(dms, dm)
+	}
+	return dms, nil
+}
+
 func (p *postgresDeviceRepository) CreateOrUpdate(ctx context.Context, dev *domain.Device) error {
 	query := `
 		INSERT INTO devices (apns_token, sandbox, expires_at, grace_period_expires_at)
@@ -160,6 +201,36 @@ func (p *postgresDeviceRepository) Update(ctx context.Context, dev *domain.Devic
 	return err
 }
 
+// SetPushToStartToken saves the token the device gave us for starting live activities remotely.
+func (p *postgresDeviceRepository) SetPushToStartToken(ctx context.Context, dev *domain.Device, token string) error {
+	query := `UPDATE devices SET push_to_start_token = $2 WHERE id = $1`
+
+	if _, err := p.conn.Exec(ctx, query, dev.ID, token); err != nil {
+		return err
+	}
+
+	dev.PushToStartToken = token
+	return nil
+}
+
+// SetSecret gives a device a new secret, as long as its current one is still
+// the one dev has. Otherwise someone else got there first, and it returns
+// domain.ErrConflict.
+func (p *postgresDeviceRepository) SetSecret(ctx context.Context, dev *domain.Device, secret string) error {
+	query := `UPDATE devices SET secret = $2 WHERE id = $1 AND secret = $3`
+
+	res, err := p.conn.Exec(ctx, query, dev.ID, secret, dev.Secret)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return domain.ErrConflict
+	}
+
+	dev.Secret = secret
+	return nil
+}
+
 func (p *postgresDeviceRepository) Delete(ctx context.Context, token string) error {
 	query := `DELETE FROM devices WHERE apns_token = $1`
 
@@ -195,6 +266,88 @@ func (p *postgresDeviceRepository) GetNotifiable(ctx context.Context, dev *domai
 	return inbox, watcher, global, nil
 }
 
+func (p *postgresDeviceRepository) SetMilestones(ctx context.Context, dev *domain.Device, acct *domain.Account, m domain.Milestones) error {
+	if err := m.Validate(); err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE devices_accounts
+		SET upvote_milestones = $1, comment_milestones = $2
+		WHERE device_id = $3 AND account_id = $4`
+
+	upvotes, comments := m.Upvotes, m.Comments
+	if upvotes == nil {
+		upvotes = []int64{}
+	}
+	if comments == nil {
+		comments = []int64{}
+	}
+
+	_, err := p.conn.Exec(ctx, query, upvotes, comments, dev.ID, acct.ID)
+	return err
+}
+
+func (p *postgresDeviceRepository) GetMilestones(ctx context.Context, dev *domain.Device, acct *domain.Account) (domain.Milestones, error) {
+	query := `
+		SELECT upvote_milestones, comment_milestones
+		FROM devices_accounts
+		WHERE device_id = $1 AND account_id = $2`
+
+	var m domain.Milestones
+	if err := p.conn.QueryRow(ctx, query, dev.ID, acct.ID).Scan(&m.Upvotes, &m.Comments); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return domain.Milestones{}, domain.ErrNotFound
+		}
+		return domain.Milestones{}, err
+	}
+
+	return m, nil
+}
+
+// Migrate moves the accounts, watchers, subscriptions and live activities of a
+// device over to another one and deletes it. It's a single statement so a
+// failure never leaves them split. A pending live activity for a thread the
+// other device already has one pending for goes with the device.
+func (p *postgresDeviceRepository) Migrate(ctx context.Context, from, to *domain.Device) error {
+	query := `
+		WITH moved_accounts AS (
+			INSERT INTO devices_accounts (account_id, device_id, watcher_notifiable, inbox_notifiable, global_mute, upvote_milestones, comment_milestones)
+			SELECT account_id, $2, watcher_notifiable, inbox_notifiable, global_mute, upvote_milestones, comment_milestones
+			FROM devices_accounts
+			WHERE device_id = $1
+			ON CONFLICT (account_id, device_id) DO
+				UPDATE SET
+					watcher_notifiable = EXCLUDED.watcher_notifiable,
+					inbox_notifiable = EXCLUDED.inbox_notifiable,
+					global_mute = EXCLUDED.global_mute,
+					upvote_milestones = EXCLUDED.upvote_milestones,
+					comment_milestones = EXCLUDED.comment_milestones
+		), moved_watchers AS (
+			UPDATE watchers
+			SET device_id = $2
+			WHERE device_id = $1
+		), moved_subscriptions AS (
+			UPDATE live_activity_subscriptions
+			SET device_id = $2
+			WHERE device_id = $1
+		), moved_live_activities AS (
+			UPDATE live_activities
+			SET device_id = $2
+			WHERE device_id = $1 AND NOT (pending AND thread_id IN (
+				SELECT thread_id FROM live_activities WHERE device_id = $2 AND pending
+			))
+		), moved_secret AS (
+			UPDATE devices
+			SET secret = (SELECT secret FROM devices WHERE id = $1)
+			WHERE id = $2 AND secret = ''
+		)
+		DELETE FROM devices WHERE id = $1`
+
+	_, err := p.conn.Exec(ctx, query, from.ID, to.ID)
+	return err
+}
+
 func (p *postgresDeviceRepository) PruneStale(ctx context.Context, expiry time.Time) (int64, error) {
 	query := `DELETE FROM devices WHERE grace_period_expires_at < $1`
 
@@ -131,3 +131,121 @@ func TestPostgresDevice_Update(t *testing.T) {
 		})
 	}
 }
+
+func TestPostgresDevice_Migrate(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	conn := testhelper.NewTestPgxConn(t)
+
+	tx, err := conn.Begin(ctx)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = tx.Rollback(ctx)
+	})
+
+	repo := repository.NewPostgresDevice(tx)
+	accounts := repository.NewPostgresAccount(tx)
+	subreddits := repository.NewPostgresSubreddit(tx)
+	watchers := repository.NewPostgresWatcher(tx)
+	subscriptions := repository.NewPostgresLiveActivitySubscription(tx)
+	liveActivities := repository.NewPostgresLiveActivity(tx)
+
+	from := &domain.Device{APNSToken: testToken}
+	require.NoError(t, repo.Create(ctx, from))
+	require.NoError(t, repo.SetSecret(ctx, from, "v1:sealed"))
+
+	b := make([]byte, 32)
+	_, err = rand.Read(b)
+	require.NoError(t, err)
+
+	to := &domain.Device{APNSToken: hex.EncodeToString(b)}
+	require.NoError(t, repo.Create(ctx, to))
+
+	acct := &domain.Account{Username: "changelog", AccountID: "1ia22"}
+	require.NoError(t, accounts.Create(ctx, acct))
+	require.NoError(t, accounts.Associate(ctx, acct, from))
+	require.NoError(t, repo.SetNotifiable(ctx, from, acct, false, true, true))
+
+	milestones := domain.Milestones{Upvotes: []int64{100, 1000}, Comments: []int64{10}}
+	require.NoError(t, repo.SetMilestones(ctx, from, acct, milestones))
+
+	sr := &domain.Subreddit{SubredditID: "2qh1i", Name: "apolloapp"}
+	require.NoError(t, subreddits.CreateOrUpdate(ctx, sr))
+
+	watcher := &domain.Watcher{
+		Label:     "Changelogs",
+		DeviceID:  from.ID,
+		AccountID: acct.ID,
+		Type:      domain.SubredditWatcher,
+		WatcheeID: sr.ID,
+	}
+	require.NoError(t, watchers.Create(ctx, watcher))
+
+	las := &domain.LiveActivitySubscription{
+		DeviceID:     from.ID,
+		AccountID:    acct.ID,
+		SubredditID:  sr.ID,
+		TitlePattern: "Changelog *",
+	}
+	require.NoError(t, subscriptions.Create(ctx, las))
+
+	la := &domain.LiveActivity{
+		APNSToken:       testLiveActivityToken,
+		DeviceID:        from.ID,
+		RedditAccountID: acct.AccountID,
+		ThreadID:        "abc123",
+		Subreddit:       "apolloapp",
+	}
+	require.NoError(t, liveActivities.Create(ctx, la))
+
+	require.NoError(t, repo.Migrate(ctx, from, to))
+
+	_, err = repo.GetByID(ctx, from.ID)
+	assert.Equal(t, domain.ErrNotFound, err)
+
+	dev, err := repo.GetByID(ctx, to.ID)
+	require.NoError(t, err)
+	assert.Equal(t, to.APNSToken, dev.APNSToken)
+	assert.Equal(t, "v1:sealed", dev.Secret)
+
+	accs, err := accounts.GetByAPNSToken(ctx, to.APNSToken)
+	require.NoError(t, err)
+	require.Len(t, accs, 1)
+	assert.Equal(t, acct.ID, accs[0].ID)
+
+	inbox, watcherNotifiable, global, err := repo.GetNotifiable(ctx, &dev, acct)
+	require.NoError(t, err)
+	assert.False(t, inbox)
+	assert.True(t, watcherNotifiable)
+	assert.True(t, global)
+
+	got, err := repo.GetMilestones(ctx, &dev, acct)
+	require.NoError(t, err)
+	assert.Equal(t, milestones, got)
+
+	gotWatcher, err := watchers.GetByID(ctx, watcher.ID)
+	require.NoError(t, err)
+	assert.Equal(t, to.ID, gotWatcher.DeviceID)
+
+	gotSubscription, err := subscriptions.GetByID(ctx, las.ID)
+	require.NoError(t, err)
+	assert.Equal(t, to.ID, gotSubscription.DeviceID)
+
+	gotActivity, err := liveActivities.GetByDeviceID(ctx, to.ID, testLiveActivityToken)
+	require.NoError(t, err)
+	assert.Equal(t, la.ID, gotActivity.ID)
+}
+
+func TestPostgresDevice_GetMilestones(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	repo := NewTestPostgresDevice(t)
+
+	dev := &domain.Device{APNSToken: testToken}
+	require.NoError(t, repo.Create(ctx, dev))
+
+	_, err := repo.GetMilestones(ctx, dev, &domain.Account{})
+	assert.Equal(t, domain.ErrNotFound, err)
+}
@@ -0,0 +1,93 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/christianselig/apollo-backend/internal/domain"
+)
+
+type postgresFeedRepository struct {
+	conn Connection
+}
+
+func NewPostgresFeed(conn Connection) domain.FeedRepository {
+	return &postgresFeedRepository{conn: conn}
+}
+
+func (p *postgresFeedRepository) fetch(ctx context.Context, query string, args ...interface{}) ([]domain.Feed, error) {
+	rows, err := p.conn.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var fs []domain.Feed
+	for rows.Next() {
+		var f domain.Feed
+		if err := rows.Scan(
+			&f.ID,
+			&f.Path,
+			&f.NextCheckAt,
+		); err != nil {
+			return nil, err
+		}
+		fs = append(fs, f)
+	}
+	return fs, nil
+}
+
+func (p *postgresFeedRepository) GetByID(ctx context.Context, id int64) (domain.Feed, error) {
+	query := `
+		SELECT id, path, next_check_at
+		FROM feeds
+		WHERE id = $1`
+
+	fs, err := p.fetch(ctx, query, id)
+
+	if err != nil {
+		return domain.Feed{}, err
+	}
+	if len(fs) == 0 {
+		return domain.Feed{}, domain.ErrNotFound
+	}
+	return fs[0], nil
+}
+
+func (p *postgresFeedRepository) GetByPath(ctx context.Context, path string) (domain.Feed, error) {
+	query := `
+		SELECT id, path, next_check_at
+		FROM feeds
+		WHERE path = $1`
+
+	f := domain.Feed{Path: path}
+
+	fs, err := p.fetch(ctx, query, f.NormalizedPath())
+
+	if err != nil {
+		return domain.Feed{}, err
+	}
+	if len(fs) == 0 {
+		return domain.Feed{}, domain.ErrNotFound
+	}
+	return fs[0], nil
+}
+
+func (p *postgresFeedRepository) CreateOrUpdate(ctx context.Context, f *domain.Feed) error {
+	if err := f.Validate(); err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO feeds (path, next_check_at)
+		VALUES ($1, NOW())
+		ON CONFLICT(path) DO UPDATE SET path = EXCLUDED.path
+		RETURNING id`
+
+	f.Path = f.NormalizedPath()
+
+	return p.conn.QueryRow(
+		ctx,
+		query,
+		f.Path,
+	).Scan(&f.ID)
+}
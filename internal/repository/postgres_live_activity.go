@@ -2,8 +2,12 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"time"
 
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+
 	"github.com/christianselig/apollo-backend/internal/domain"
 )
 
@@ -37,6 +41,9 @@ func (p *postgresLiveActivityRepository) fetch(ctx context.Context, query string
 			&la.NextCheckAt,
 			&la.ExpiresAt,
 			&la.Development,
+			&la.Authors,
+			&la.Pending,
+			&la.DeviceID,
 		); err != nil {
 			return nil, err
 		}
@@ -47,7 +54,7 @@ func (p *postgresLiveActivityRepository) fetch(ctx context.Context, query string
 
 func (p *postgresLiveActivityRepository) Get(ctx context.Context, apnsToken string) (domain.LiveActivity, error) {
 	query := `
-		SELECT id, apns_token, reddit_account_id, access_token, refresh_token, token_expires_at, thread_id, subreddit, next_check_at, expires_at, development
+		SELECT id, COALESCE(apns_token, ''), reddit_account_id, access_token, refresh_token, token_expires_at, thread_id, subreddit, next_check_at, expires_at, development, authors, pending, COALESCE(device_id, 0)
 		FROM live_activities
 		WHERE apns_token = $1`
 
@@ -62,9 +69,43 @@ func (p *postgresLiveActivityRepository) Get(ctx context.Context, apnsToken stri
 	return las[0], nil
 }
 
+func (p *postgresLiveActivityRepository) GetByDeviceID(ctx context.Context, deviceID int64, apnsToken string) (domain.LiveActivity, error) {
+	query := `
+		SELECT id, COALESCE(apns_token, ''), reddit_account_id, access_token, refresh_token, token_expires_at, thread_id, subreddit, next_check_at, expires_at, development, authors, pending, COALESCE(device_id, 0)
+		FROM live_activities
+		WHERE device_id = $1 AND apns_token = $2`
+
+	las, err := p.fetch(ctx, query, deviceID, apnsToken)
+
+	if err != nil {
+		return domain.LiveActivity{}, err
+	}
+	if len(las) == 0 {
+		return domain.LiveActivity{}, domain.ErrNotFound
+	}
+	return las[0], nil
+}
+
+func (p *postgresLiveActivityRepository) GetPending(ctx context.Context, deviceID int64, threadID string) (domain.LiveActivity, error) {
+	query := `
+		SELECT id, COALESCE(apns_token, ''), reddit_account_id, access_token, refresh_token, token_expires_at, thread_id, subreddit, next_check_at, expires_at, development, authors, pending, COALESCE(device_id, 0)
+		FROM live_activities
+		WHERE device_id = $1 AND thread_id = $2 AND pending`
+
+	las, err := p.fetch(ctx, query, deviceID, threadID)
+
+	if err != nil {
+		return domain.LiveActivity{}, err
+	}
+	if len(las) == 0 {
+		return domain.LiveActivity{}, domain.ErrNotFound
+	}
+	return las[0], nil
+}
+
 func (p *postgresLiveActivityRepository) List(ctx context.Context) ([]domain.LiveActivity, error) {
 	query := `
-		SELECT id, apns_token, reddit_account_id, access_token, refresh_token, token_expires_at, thread_id, subreddit, next_check_at, expires_at, development
+		SELECT id, COALESCE(apns_token, ''), reddit_account_id, access_token, refresh_token, token_expires_at, thread_id, subreddit, next_check_at, expires_at, development, authors, pending, COALESCE(device_id, 0)
 		FROM live_activities
 		WHERE expires_at > NOW()`
 
@@ -73,8 +114,8 @@ func (p *postgresLiveActivityRepository) List(ctx context.Context) ([]domain.Liv
 
 func (p *postgresLiveActivityRepository) Create(ctx context.Context, la *domain.LiveActivity) error {
 	query := `
-		INSERT INTO live_activities (apns_token, reddit_account_id, access_token, refresh_token, token_expires_at, thread_id, subreddit, next_check_at, expires_at, development)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		INSERT INTO live_activities (apns_token, reddit_account_id, access_token, refresh_token, token_expires_at, thread_id, subreddit, next_check_at, expires_at, development, authors, device_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, NULLIF($12, 0))
 		ON CONFLICT (apns_token) DO UPDATE SET expires_at = $9
 		RETURNING id`
 
@@ -89,35 +130,170 @@ func (p *postgresLiveActivityRepository) Create(ctx context.Context, la *domain.
 		time.Now(),
 		time.Now().Add(domain.LiveActivityDuration),
 		la.Development,
+		int64(la.Authors),
+		la.DeviceID,
 	).Scan(&la.ID)
 }
 
-func (p *postgresLiveActivityRepository) Update(ctx context.Context, la *domain.LiveActivity) error {
+// UpdateTokens saves the reddit tokens of an activity after a refresh, and
+// nothing else.
+func (p *postgresLiveActivityRepository) UpdateTokens(ctx context.Context, la *domain.LiveActivity) error {
 	query := `
 		UPDATE live_activities
-		SET access_token = $1, refresh_token = $2, token_expires_at = $3, next_check_at = $4
-		WHERE id = $5`
+		SET access_token = $1, refresh_token = $2, token_expires_at = $3
+		WHERE id = $4`
 
 	_, err := p.conn.Exec(ctx, query,
 		la.AccessToken,
 		la.RefreshToken,
 		la.TokenExpiresAt,
-		la.NextCheckAt,
 		la.ID,
 	)
 	return err
 }
 
-func (p *postgresLiveActivityRepository) RemoveStale(ctx context.Context) error {
-	query := `DELETE FROM live_activities WHERE expires_at < NOW()`
+// Reschedule moves the next check of an activity to the given time, never past
+// when it expires. It only does so if the check is still when the activity was
+// loaded, so it doesn't undo an edit made in the meantime, and returns
+// domain.ErrConflict otherwise.
+func (p *postgresLiveActivityRepository) Reschedule(ctx context.Context, la *domain.LiveActivity, next time.Time) error {
+	query := `
+		UPDATE live_activities
+		SET next_check_at = LEAST($1, expires_at)
+		WHERE id = $2 AND next_check_at = $3
+		RETURNING next_check_at`
 
-	_, err := p.conn.Exec(ctx, query)
+	err := p.conn.QueryRow(ctx, query, next, la.ID, la.NextCheckAt).Scan(&la.NextCheckAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return domain.ErrConflict
+	}
 	return err
 }
 
+// CreatePending saves an activity we're about to start through the device's
+// push-to-start token. A device only has one pending activity per thread, and
+// it's never replaced, so this returns domain.ErrConflict if there's one already.
+func (p *postgresLiveActivityRepository) CreatePending(ctx context.Context, la *domain.LiveActivity) error {
+	query := `
+		INSERT INTO live_activities (reddit_account_id, access_token, refresh_token, token_expires_at, thread_id, subreddit, next_check_at, expires_at, development, authors, pending, device_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, TRUE, $11)
+		ON CONFLICT (device_id, thread_id) WHERE pending DO NOTHING
+		RETURNING id`
+
+	la.APNSToken = ""
+	la.Pending = true
+	la.NextCheckAt = time.Now()
+	la.ExpiresAt = la.NextCheckAt.Add(domain.LiveActivityDuration)
+
+	err := p.conn.QueryRow(ctx, query,
+		la.RedditAccountID,
+		la.AccessToken,
+		la.RefreshToken,
+		la.TokenExpiresAt,
+		la.ThreadID,
+		la.Subreddit,
+		la.NextCheckAt,
+		la.ExpiresAt,
+		la.Development,
+		int64(la.Authors),
+		la.DeviceID,
+	).Scan(&la.ID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return domain.ErrConflict
+	}
+	return err
+}
+
+// Claim gives a pending activity its own token, so its updates can start going
+// out. It returns domain.ErrConflict if another activity has that token already.
+func (p *postgresLiveActivityRepository) Claim(ctx context.Context, la *domain.LiveActivity, apnsToken string) error {
+	query := `
+		UPDATE live_activities
+		SET apns_token = $1, pending = FALSE, next_check_at = $2
+		WHERE id = $3 AND pending`
+
+	now := time.Now()
+
+	res, err := p.conn.Exec(ctx, query, apnsToken, now, la.ID)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgUniqueViolation {
+			return domain.ErrConflict
+		}
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return domain.ErrNotFound
+	}
+
+	la.APNSToken = apnsToken
+	la.Pending = false
+	la.NextCheckAt = now
+	return nil
+}
+
+// UpdateSettings saves what the activity follows and for how long, and has it checked right away.
+func (p *postgresLiveActivityRepository) UpdateSettings(ctx context.Context, la *domain.LiveActivity) error {
+	query := `
+		UPDATE live_activities
+		SET thread_id = $1, subreddit = $2, authors = $3, expires_at = $4, next_check_at = $5
+		WHERE id = $6`
+
+	la.NextCheckAt = time.Now()
+
+	_, err := p.conn.Exec(ctx, query,
+		la.ThreadID,
+		la.Subreddit,
+		int64(la.Authors),
+		la.ExpiresAt,
+		la.NextCheckAt,
+		la.ID,
+	)
+	return err
+}
+
+// RemoveStale deletes activities that expired before the given time, and hands back what they were.
+func (p *postgresLiveActivityRepository) RemoveStale(ctx context.Context, before time.Time) ([]domain.LiveActivity, error) {
+	query := `
+		DELETE FROM live_activities
+		WHERE expires_at < $1
+		RETURNING id, COALESCE(apns_token, ''), thread_id, subreddit, expires_at, development, pending`
+
+	rows, err := p.conn.Query(ctx, query, before)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var las []domain.LiveActivity
+	for rows.Next() {
+		var la domain.LiveActivity
+		if err := rows.Scan(
+			&la.ID,
+			&la.APNSToken,
+			&la.ThreadID,
+			&la.Subreddit,
+			&la.ExpiresAt,
+			&la.Development,
+			&la.Pending,
+		); err != nil {
+			return nil, err
+		}
+		las = append(las, la)
+	}
+	return las, nil
+}
+
 func (p *postgresLiveActivityRepository) Delete(ctx context.Context, apns_token string) error {
 	query := `DELETE FROM live_activities WHERE apns_token = $1`
 
 	_, err := p.conn.Exec(ctx, query, apns_token)
 	return err
 }
+
+func (p *postgresLiveActivityRepository) DeleteByID(ctx context.Context, id int64) error {
+	query := `DELETE FROM live_activities WHERE id = $1`
+
+	_, err := p.conn.Exec(ctx, query, id)
+	return err
+}
@@ -0,0 +1,175 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/christianselig/apollo-backend/internal/domain"
+)
+
+type postgresLiveActivitySubscriptionRepository struct {
+	conn Connection
+}
+
+func NewPostgresLiveActivitySubscription(conn Connection) domain.LiveActivitySubscriptionRepository {
+	return &postgresLiveActivitySubscriptionRepository{conn: conn}
+}
+
+func (p *postgresLiveActivitySubscriptionRepository) fetch(ctx context.Context, query string, args ...interface{}) ([]domain.LiveActivitySubscription, error) {
+	rows, err := p.conn.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var subs []domain.LiveActivitySubscription
+	for rows.Next() {
+		var las domain.LiveActivitySubscription
+		if err := rows.Scan(
+			&las.ID,
+			&las.CreatedAt,
+			&las.DeviceID,
+			&las.AccountID,
+			&las.SubredditID,
+			&las.TitlePattern,
+			&las.Authors,
+			&las.Device.ID,
+			&las.Device.APNSToken,
+			&las.Device.Sandbox,
+			&las.Device.PushToStartToken,
+			&las.Account.ID,
+			&las.Account.AccountID,
+			&las.Account.AccessToken,
+			&las.Account.RefreshToken,
+			&las.Account.TokenExpiresAt,
+			&las.Subreddit,
+		); err != nil {
+			return nil, err
+		}
+		subs = append(subs, las)
+	}
+	return subs, nil
+}
+
+func (p *postgresLiveActivitySubscriptionRepository) GetByID(ctx context.Context, id int64) (domain.LiveActivitySubscription, error) {
+	query := `
+		SELECT
+			live_activity_subscriptions.id,
+			live_activity_subscriptions.created_at,
+			live_activity_subscriptions.device_id,
+			live_activity_subscriptions.account_id,
+			live_activity_subscriptions.subreddit_id,
+			live_activity_subscriptions.title_pattern,
+			live_activity_subscriptions.authors,
+			devices.id,
+			devices.apns_token,
+			devices.sandbox,
+			devices.push_to_start_token,
+			accounts.id,
+			accounts.reddit_account_id,
+			accounts.access_token,
+			accounts.refresh_token,
+			accounts.token_expires_at,
+			subreddits.name
+		FROM live_activity_subscriptions
+		INNER JOIN devices ON live_activity_subscriptions.device_id = devices.id
+		INNER JOIN accounts ON live_activity_subscriptions.account_id = accounts.id
+		INNER JOIN subreddits ON live_activity_subscriptions.subreddit_id = subreddits.id
+		WHERE live_activity_subscriptions.id = $1`
+
+	subs, err := p.fetch(ctx, query, id)
+	if err != nil {
+		return domain.LiveActivitySubscription{}, err
+	}
+	if len(subs) == 0 {
+		return domain.LiveActivitySubscription{}, domain.ErrNotFound
+	}
+	return subs[0], nil
+}
+
+func (p *postgresLiveActivitySubscriptionRepository) GetBySubredditID(ctx context.Context, id int64) ([]domain.LiveActivitySubscription, error) {
+	query := `
+		SELECT
+			live_activity_subscriptions.id,
+			live_activity_subscriptions.created_at,
+			live_activity_subscriptions.device_id,
+			live_activity_subscriptions.account_id,
+			live_activity_subscriptions.subreddit_id,
+			live_activity_subscriptions.title_pattern,
+			live_activity_subscriptions.authors,
+			devices.id,
+			devices.apns_token,
+			devices.sandbox,
+			devices.push_to_start_token,
+			accounts.id,
+			accounts.reddit_account_id,
+			accounts.access_token,
+			accounts.refresh_token,
+			accounts.token_expires_at,
+			subreddits.name
+		FROM live_activity_subscriptions
+		INNER JOIN devices ON live_activity_subscriptions.device_id = devices.id
+		INNER JOIN accounts ON live_activity_subscriptions.account_id = accounts.id
+		INNER JOIN subreddits ON live_activity_subscriptions.subreddit_id = subreddits.id
+		WHERE live_activity_subscriptions.subreddit_id = $1`
+
+	return p.fetch(ctx, query, id)
+}
+
+func (p *postgresLiveActivitySubscriptionRepository) GetByDeviceAPNSTokenAndAccountRedditID(ctx context.Context, apns string, rid string) ([]domain.LiveActivitySubscription, error) {
+	query := `
+		SELECT
+			live_activity_subscriptions.id,
+			live_activity_subscriptions.created_at,
+			live_activity_subscriptions.device_id,
+			live_activity_subscriptions.account_id,
+			live_activity_subscriptions.subreddit_id,
+			live_activity_subscriptions.title_pattern,
+			live_activity_subscriptions.authors,
+			devices.id,
+			devices.apns_token,
+			devices.sandbox,
+			devices.push_to_start_token,
+			accounts.id,
+			accounts.reddit_account_id,
+			accounts.access_token,
+			accounts.refresh_token,
+			accounts.token_expires_at,
+			subreddits.name
+		FROM live_activity_subscriptions
+		INNER JOIN devices ON live_activity_subscriptions.device_id = devices.id
+		INNER JOIN accounts ON live_activity_subscriptions.account_id = accounts.id
+		INNER JOIN subreddits ON live_activity_subscriptions.subreddit_id = subreddits.id
+		WHERE devices.apns_token = $1 AND accounts.reddit_account_id = $2
+		ORDER BY live_activity_subscriptions.id`
+
+	return p.fetch(ctx, query, apns, rid)
+}
+
+func (p *postgresLiveActivitySubscriptionRepository) Create(ctx context.Context, las *domain.LiveActivitySubscription) error {
+	if err := las.Validate(); err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO live_activity_subscriptions (created_at, device_id, account_id, subreddit_id, title_pattern, authors)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id`
+
+	las.CreatedAt = time.Now()
+
+	return p.conn.QueryRow(ctx, query,
+		las.CreatedAt,
+		las.DeviceID,
+		las.AccountID,
+		las.SubredditID,
+		las.TitlePattern,
+		int64(las.Authors),
+	).Scan(&las.ID)
+}
+
+func (p *postgresLiveActivitySubscriptionRepository) Delete(ctx context.Context, id int64) error {
+	query := `DELETE FROM live_activity_subscriptions WHERE id = $1`
+	_, err := p.conn.Exec(ctx, query, id)
+	return err
+}
@@ -0,0 +1,191 @@
+package repository_test
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/christianselig/apollo-backend/internal/domain"
+	"github.com/christianselig/apollo-backend/internal/repository"
+	"github.com/christianselig/apollo-backend/internal/testhelper"
+)
+
+const testLiveActivityToken = "80c6b1f0a3c1c1fbd1b4d9f8a7c2f5e3d6b9a8c7f1e2d3c4b5a69788a9bacbdc"
+
+// NewTestPostgresLiveActivity returns live activities along with devices, as
+// live activities belong to one.
+func NewTestPostgresLiveActivity(t *testing.T) (domain.DeviceRepository, domain.LiveActivityRepository) {
+	t.Helper()
+
+	ctx := context.Background()
+	conn := testhelper.NewTestPgxConn(t)
+
+	tx, err := conn.Begin(ctx)
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		_ = tx.Rollback(ctx)
+	})
+
+	return repository.NewPostgresDevice(tx), repository.NewPostgresLiveActivity(tx)
+}
+
+func TestPostgresLiveActivity_GetByDeviceID(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	devices, repo := NewTestPostgresLiveActivity(t)
+
+	dev := &domain.Device{APNSToken: testToken}
+	require.NoError(t, devices.Create(ctx, dev))
+
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	require.NoError(t, err)
+
+	other := &domain.Device{APNSToken: hex.EncodeToString(b)}
+	require.NoError(t, devices.Create(ctx, other))
+
+	la := &domain.LiveActivity{
+		APNSToken:       testLiveActivityToken,
+		DeviceID:        dev.ID,
+		RedditAccountID: "1ia22",
+		ThreadID:        "abc123",
+		Subreddit:       "apolloapp",
+	}
+	require.NoError(t, repo.Create(ctx, la))
+
+	testCases := map[string]struct {
+		deviceID int64
+		err      error
+	}{
+		"own device":   {dev.ID, nil},
+		"other device": {other.ID, domain.ErrNotFound},
+		"no device":    {0, domain.ErrNotFound},
+	}
+
+	for scenario, tc := range testCases { //nolint:paralleltest
+		t.Run(scenario, func(t *testing.T) {
+			got, err := repo.GetByDeviceID(ctx, tc.deviceID, testLiveActivityToken)
+			if tc.err != nil {
+				assert.Equal(t, tc.err, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, la.ID, got.ID)
+			assert.Equal(t, dev.ID, got.DeviceID)
+		})
+	}
+}
+
+func TestPostgresLiveActivity_CreatePending(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	devices, repo := NewTestPostgresLiveActivity(t)
+
+	dev := &domain.Device{APNSToken: testToken}
+	require.NoError(t, devices.Create(ctx, dev))
+
+	la := &domain.LiveActivity{
+		DeviceID:        dev.ID,
+		RedditAccountID: "1ia22",
+		ThreadID:        "abc123",
+		Subreddit:       "apolloapp",
+	}
+	require.NoError(t, repo.CreatePending(ctx, la))
+
+	got, err := repo.GetPending(ctx, dev.ID, "abc123")
+	require.NoError(t, err)
+	assert.Equal(t, la.ID, got.ID)
+	assert.Equal(t, "", got.APNSToken)
+
+	// The one already pending for the thread is left alone
+	dupe := *la
+	dupe.Subreddit = "pics"
+	assert.Equal(t, domain.ErrConflict, repo.CreatePending(ctx, &dupe))
+
+	got, err = repo.GetPending(ctx, dev.ID, "abc123")
+	require.NoError(t, err)
+	assert.Equal(t, "apolloapp", got.Subreddit)
+}
+
+func TestPostgresLiveActivity_Claim(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	devices, repo := NewTestPostgresLiveActivity(t)
+
+	dev := &domain.Device{APNSToken: testToken}
+	require.NoError(t, devices.Create(ctx, dev))
+
+	la := &domain.LiveActivity{
+		DeviceID:        dev.ID,
+		RedditAccountID: "1ia22",
+		ThreadID:        "abc123",
+		Subreddit:       "apolloapp",
+	}
+	require.NoError(t, repo.CreatePending(ctx, la))
+	require.NoError(t, repo.Claim(ctx, la, testLiveActivityToken))
+
+	got, err := repo.GetByDeviceID(ctx, dev.ID, testLiveActivityToken)
+	require.NoError(t, err)
+	assert.Equal(t, la.ID, got.ID)
+	assert.False(t, got.Pending)
+
+	// It can't be claimed twice
+	assert.Equal(t, domain.ErrNotFound, repo.Claim(ctx, la, testLiveActivityToken))
+
+	other := &domain.LiveActivity{
+		DeviceID:        dev.ID,
+		RedditAccountID: "1ia22",
+		ThreadID:        "def456",
+		Subreddit:       "apolloapp",
+	}
+	require.NoError(t, repo.CreatePending(ctx, other))
+
+	// Last, as the unique violation aborts the transaction
+	assert.Equal(t, domain.ErrConflict, repo.Claim(ctx, other, testLiveActivityToken))
+}
+
+func TestPostgresLiveActivity_Reschedule(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	devices, repo := NewTestPostgresLiveActivity(t)
+
+	dev := &domain.Device{APNSToken: testToken}
+	require.NoError(t, devices.Create(ctx, dev))
+
+	require.NoError(t, repo.Create(ctx, &domain.LiveActivity{
+		APNSToken:       testLiveActivityToken,
+		DeviceID:        dev.ID,
+		RedditAccountID: "1ia22",
+		ThreadID:        "abc123",
+		Subreddit:       "apolloapp",
+	}))
+
+	la, err := repo.Get(ctx, testLiveActivityToken)
+	require.NoError(t, err)
+
+	// Never past when it expires
+	require.NoError(t, repo.Reschedule(ctx, &la, la.ExpiresAt.Add(time.Hour)))
+	assert.WithinDuration(t, la.ExpiresAt, la.NextCheckAt, time.Millisecond)
+
+	// Someone ended it while we were checking on it
+	stale := la
+	la.ExpiresAt = time.Now()
+	require.NoError(t, repo.UpdateSettings(ctx, &la))
+
+	assert.Equal(t, domain.ErrConflict, repo.Reschedule(ctx, &stale, time.Now().Add(time.Hour)))
+
+	got, err := repo.Get(ctx, testLiveActivityToken)
+	require.NoError(t, err)
+	assert.WithinDuration(t, la.NextCheckAt, got.NextCheckAt, time.Millisecond)
+}
@@ -3,6 +3,7 @@ package repository
 import (
 	"context"
 	"strings"
+	"time"
 
 	"github.com/christianselig/apollo-backend/internal/domain"
 )
@@ -25,14 +26,20 @@ func (p *postgresSubredditRepository) fetch(ctx context.Context, query string, a
 	var srs []domain.Subreddit
 	for rows.Next() {
 		var sr domain.Subreddit
+		var lastPostAt *time.Time
 		if err := rows.Scan(
 			&sr.ID,
 			&sr.SubredditID,
 			&sr.Name,
 			&sr.NextCheckAt,
+			&sr.LastPostID,
+			&lastPostAt,
 		); err != nil {
 			return nil, err
 		}
+		if lastPostAt != nil {
+			sr.LastPostAt = *lastPostAt
+		}
 		srs = append(srs, sr)
 	}
 	return srs, nil
@@ -40,7 +47,7 @@ func (p *postgresSubredditRepository) fetch(ctx context.Context, query string, a
 
 func (p *postgresSubredditRepository) GetByID(ctx context.Context, id int64) (domain.Subreddit, error) {
 	query := `
-		SELECT id, subreddit_id, name, next_check_at
+		SELECT id, subreddit_id, name, next_check_at, COALESCE(last_post_id, ''), last_post_at
 		FROM subreddits
 		WHERE id = $1`
 
@@ -57,7 +64,7 @@ func (p *postgresSubredditRepository) GetByID(ctx context.Context, id int64) (do
 
 func (p *postgresSubredditRepository) GetByName(ctx context.Context, name string) (domain.Subreddit, error) {
 	query := `
-		SELECT id, subreddit_id, name, next_check_at
+		SELECT id, subreddit_id, name, next_check_at, COALESCE(last_post_id, ''), last_post_at
 		FROM subreddits
 		WHERE name = $1`
 
@@ -92,3 +99,13 @@ func (p *postgresSubredditRepository) CreateOrUpdate(ctx context.Context, sr *do
 		sr.NormalizedName(),
 	).Scan(&sr.ID)
 }
+
+func (p *postgresSubredditRepository) UpdateHighWaterMark(ctx context.Context, sr *domain.Subreddit) error {
+	query := `
+		UPDATE subreddits
+		SET last_post_id = $2, last_post_at = $3
+		WHERE id = $1`
+
+	_, err := p.conn.Exec(ctx, query, sr.ID, sr.LastPostID, nullTime(sr.LastPostAt))
+	return err
+}
@@ -3,6 +3,7 @@ package repository
 import (
 	"context"
 	"strings"
+	"time"
 
 	"github.com/christianselig/apollo-backend/internal/domain"
 )
@@ -25,14 +26,20 @@ func (p *postgresUserRepository) fetch(ctx context.Context, query string, args .
 	var uu []domain.User
 	for rows.Next() {
 		var u domain.User
+		var lastCommentAt *time.Time
 		if err := rows.Scan(
 			&u.ID,
 			&u.UserID,
 			&u.Name,
 			&u.NextCheckAt,
+			&u.LastCommentID,
+			&lastCommentAt,
 		); err != nil {
 			return nil, err
 		}
+		if lastCommentAt != nil {
+			u.LastCommentAt = *lastCommentAt
+		}
 		uu = append(uu, u)
 	}
 	return uu, nil
@@ -40,7 +47,7 @@ func (p *postgresUserRepository) fetch(ctx context.Context, query string, args .
 
 func (p *postgresUserRepository) GetByID(ctx context.Context, id int64) (domain.User, error) {
 	query := `
-		SELECT id, user_id, name, next_check_at
+		SELECT id, user_id, name, next_check_at, COALESCE(last_comment_id, ''), last_comment_at
 		FROM users
 		WHERE id = $1`
 
@@ -57,7 +64,7 @@ func (p *postgresUserRepository) GetByID(ctx context.Context, id int64) (domain.
 
 func (p *postgresUserRepository) GetByName(ctx context.Context, name string) (domain.User, error) {
 	query := `
-		SELECT id, user_id, name, next_check_at
+		SELECT id, user_id, name, next_check_at, COALESCE(last_comment_id, ''), last_comment_at
 		FROM users
 		WHERE name = $1`
 
@@ -94,6 +101,16 @@ func (p *postgresUserRepository) CreateOrUpdate(ctx context.Context, u *domain.U
 	).Scan(&u.ID)
 }
 
+func (p *postgresUserRepository) UpdateCommentCursor(ctx context.Context, u *domain.User) error {
+	query := `
+		UPDATE users
+		SET last_comment_id = $2, last_comment_at = $3
+		WHERE id = $1`
+
+	_, err := p.conn.Exec(ctx, query, u.ID, u.LastCommentID, nullTime(u.LastCommentAt))
+	return err
+}
+
 func (p *postgresUserRepository) Delete(ctx context.Context, id int64) error {
 	query := `DELETE FROM users WHERE id = $1`
 	_, err := p.conn.Exec(ctx, query, id)
@@ -2,6 +2,7 @@ package repository
 
 import (
 	"context"
+	"strings"
 	"time"
 
 	"github.com/christianselig/apollo-backend/internal/domain"
@@ -25,7 +26,11 @@ func (p *postgresWatcherRepository) fetch(ctx context.Context, query string, arg
 	var watchers []domain.Watcher
 	for rows.Next() {
 		var watcher domain.Watcher
-		var subredditLabel, userLabel string
+		var subredditLabel, userLabel, feedLabel string
+		var subredditIDs []int64
+		var subredditNames []string
+		var notificationWindow, cooldown, maxAge int64
+		var snoozedUntil, expiresAt, brokenAt *time.Time
 
 		if err := rows.Scan(
 			&watcher.ID,
@@ -43,24 +48,67 @@ func (p *postgresWatcherRepository) fetch(ctx context.Context, query string, arg
 			&watcher.Flair,
 			&watcher.Domain,
 			&watcher.Hits,
+			&watcher.TrendingSensitivity,
+			&watcher.UserActivity,
+			&watcher.MediaKind,
+			&watcher.PostKind,
+			&watcher.NSFW,
+			&watcher.MinComments,
+			&maxAge,
+			&watcher.MaxNotifications,
+			&notificationWindow,
+			&cooldown,
+			&watcher.SummarizeSuppressed,
+			&snoozedUntil,
+			&expiresAt,
+			&watcher.NotifyOnExpiry,
+			&watcher.BrokenReason,
+			&brokenAt,
+			&watcher.NotifyOnBroken,
 			&watcher.Device.ID,
 			&watcher.Device.APNSToken,
 			&watcher.Device.Sandbox,
 			&watcher.Account.ID,
 			&watcher.Account.AccountID,
+			&watcher.Account.Username,
 			&watcher.Account.AccessToken,
 			&watcher.Account.RefreshToken,
 			&subredditLabel,
 			&userLabel,
+			&feedLabel,
+			&subredditIDs,
+			&subredditNames,
 		); err != nil {
 			return nil, err
 		}
 
+		watcher.NotificationWindow = time.Duration(notificationWindow) * time.Second
+		watcher.Cooldown = time.Duration(cooldown) * time.Second
+		watcher.MaxAge = time.Duration(maxAge) * time.Second
+
+		if snoozedUntil != nil {
+			watcher.SnoozedUntil = *snoozedUntil
+		}
+		if expiresAt != nil {
+			watcher.ExpiresAt = *expiresAt
+		}
+		if brokenAt != nil {
+			watcher.BrokenAt = *brokenAt
+		}
+
+		for i, id := range subredditIDs {
+			watcher.Subreddits = append(watcher.Subreddits, domain.Subreddit{ID: id, Name: subredditNames[i]})
+		}
+
 		switch watcher.Type {
 		case domain.SubredditWatcher, domain.TrendingWatcher:
 			watcher.WatcheeLabel = subredditLabel
 		case domain.UserWatcher:
 			watcher.WatcheeLabel = userLabel
+		case domain.FeedWatcher:
+			watcher.WatcheeLabel = feedLabel
+		case domain.MultiSubredditWatcher:
+			watcher.WatcheeLabel = strings.Join(subredditNames, ",")
 		}
 
 		watchers = append(watchers, watcher)
@@ -86,20 +134,54 @@ func (p *postgresWatcherRepository) GetByID(ctx context.Context, id int64) (doma
 			watchers.flair,
 			watchers.domain,
 			watchers.hits,
+			watchers.trending_sensitivity,
+			watchers.user_activity,
+			watchers.media_kind,
+			watchers.post_kind,
+			watchers.nsfw,
+			watchers.min_comments,
+			watchers.max_age,
+			watchers.max_notifications,
+			watchers.notification_window,
+			watchers.cooldown,
+			watchers.summarize_suppressed,
+			watchers.snoozed_until,
+			watchers.expires_at,
+			watchers.notify_on_expiry,
+			watchers.broken_reason,
+			watchers.broken_at,
+			watchers.notify_on_broken,
 			devices.id,
 			devices.apns_token,
 			devices.sandbox,
 			accounts.id,
 			accounts.reddit_account_id,
+			accounts.username,
 			accounts.access_token,
 			accounts.refresh_token,
 			COALESCE(subreddits.name, '') AS subreddit_label,
-			COALESCE(users.name, '') AS user_label
+			COALESCE(users.name, '') AS user_label,
+			COALESCE(feeds.path, '') AS feed_label,
+			ARRAY(
+				SELECT watchers_subreddits.subreddit_id
+				FROM watchers_subreddits
+				INNER JOIN subreddits ON watchers_subreddits.subreddit_id = subreddits.id
+				WHERE watchers_subreddits.watcher_id = watchers.id
+				ORDER BY subreddits.name
+			) AS subreddit_ids,
+			ARRAY(
+				SELECT subreddits.name
+				FROM watchers_subreddits
+				INNER JOIN subreddits ON watchers_subreddits.subreddit_id = subreddits.id
+				WHERE watchers_subreddits.watcher_id = watchers.id
+				ORDER BY subreddits.name
+			) AS subreddit_names
 		FROM watchers
 		INNER JOIN devices ON watchers.device_id = devices.id
 		INNER JOIN accounts ON watchers.account_id = accounts.id
 		LEFT JOIN subreddits ON watchers.type IN(0,2) AND watchers.watchee_id = subreddits.id
 		LEFT JOIN users ON watchers.type = 1 AND watchers.watchee_id = users.id
+		LEFT JOIN feeds ON watchers.type = 4 AND watchers.watchee_id = feeds.id
 		WHERE watchers.id = $1`
 
 	watchers, err := p.fetch(ctx, query, id)
@@ -114,6 +196,12 @@ func (p *postgresWatcherRepository) GetByID(ctx context.Context, id int64) (doma
 }
 
 func (p *postgresWatcherRepository) GetByTypeAndWatcheeID(ctx context.Context, typ domain.WatcherType, id int64) ([]domain.Watcher, error) {
+	return p.getByTypeAndWatcheeID(ctx, typ, id, true)
+}
+
+// getByTypeAndWatcheeID returns the watchers of a subreddit, user or feed. Only
+// the ones that can notify right now are active, health checks want them all.
+func (p *postgresWatcherRepository) getByTypeAndWatcheeID(ctx context.Context, typ domain.WatcherType, id int64, active bool) ([]domain.Watcher, error) {
 	query := `
 		SELECT
 			watchers.id,
@@ -131,27 +219,74 @@ func (p *postgresWatcherRepository) GetByTypeAndWatcheeID(ctx context.Context, t
 			watchers.flair,
 			watchers.domain,
 			watchers.hits,
+			watchers.trending_sensitivity,
+			watchers.user_activity,
+			watchers.media_kind,
+			watchers.post_kind,
+			watchers.nsfw,
+			watchers.min_comments,
+			watchers.max_age,
+			watchers.max_notifications,
+			watchers.notification_window,
+			watchers.cooldown,
+			watchers.summarize_suppressed,
+			watchers.snoozed_until,
+			watchers.expires_at,
+			watchers.notify_on_expiry,
+			watchers.broken_reason,
+			watchers.broken_at,
+			watchers.notify_on_broken,
 			devices.id,
 			devices.apns_token,
 			devices.sandbox,
 			accounts.id,
 			accounts.reddit_account_id,
+			accounts.username,
 			accounts.access_token,
 			accounts.refresh_token,
 			COALESCE(subreddits.name, '') AS subreddit_label,
-			COALESCE(users.name, '') AS user_label
+			COALESCE(users.name, '') AS user_label,
+			COALESCE(feeds.path, '') AS feed_label,
+			ARRAY(
+				SELECT watchers_subreddits.subreddit_id
+				FROM watchers_subreddits
+				INNER JOIN subreddits ON watchers_subreddits.subreddit_id = subreddits.id
+				WHERE watchers_subreddits.watcher_id = watchers.id
+				ORDER BY subreddits.name
+			) AS subreddit_ids,
+			ARRAY(
+				SELECT subreddits.name
+				FROM watchers_subreddits
+				INNER JOIN subreddits ON watchers_subreddits.subreddit_id = subreddits.id
+				WHERE watchers_subreddits.watcher_id = watchers.id
+				ORDER BY subreddits.name
+			) AS subreddit_names
 		FROM watchers
 		INNER JOIN devices ON watchers.device_id = devices.id
 		INNER JOIN accounts ON watchers.account_id = accounts.id
 		INNER JOIN devices_accounts ON devices.id = devices_accounts.device_id AND accounts.id = devices_accounts.account_id
 		LEFT JOIN subreddits ON watchers.type IN(0,2) AND watchers.watchee_id = subreddits.id
 		LEFT JOIN users ON watchers.type = 1 AND watchers.watchee_id = users.id
-		WHERE watchers.type = $1 AND
-		watchers.watchee_id = $2 AND
-		devices_accounts.watcher_notifiable = TRUE AND
-		devices_accounts.global_mute = FALSE`
+		LEFT JOIN feeds ON watchers.type = 4 AND watchers.watchee_id = feeds.id
+		WHERE (
+			(watchers.type = $1 AND watchers.watchee_id = $2) OR
+			($3 AND watchers.type = 3 AND EXISTS (
+				SELECT 1
+				FROM watchers_subreddits
+				WHERE watchers_subreddits.watcher_id = watchers.id AND
+				watchers_subreddits.subreddit_id = $2
+			))
+		) AND (NOT $4 OR (
+			(watchers.snoozed_until IS NULL OR watchers.snoozed_until <= NOW()) AND
+			(watchers.expires_at IS NULL OR watchers.expires_at > NOW()) AND
+			devices_accounts.watcher_notifiable = TRUE AND
+			devices_accounts.global_mute = FALSE
+		))`
 
-	return p.fetch(ctx, query, int64(typ), id)
+	// Multi-subreddit watchers get checked alongside every subreddit they cover
+	includeMulti := typ == domain.SubredditWatcher
+
+	return p.fetch(ctx, query, int64(typ), id, includeMulti, active)
 }
 
 func (p *postgresWatcherRepository) GetByTrendingSubredditID(ctx context.Context, id int64) ([]domain.Watcher, error) {
@@ -162,10 +297,32 @@ func (p *postgresWatcherRepository) GetBySubredditID(ctx context.Context, id int
 	return p.GetByTypeAndWatcheeID(ctx, domain.SubredditWatcher, id)
 }
 
+func (p *postgresWatcherRepository) GetByFeedID(ctx context.Context, id int64) ([]domain.Watcher, error) {
+	return p.GetByTypeAndWatcheeID(ctx, domain.FeedWatcher, id)
+}
+
 func (p *postgresWatcherRepository) GetByUserID(ctx context.Context, id int64) ([]domain.Watcher, error) {
 	return p.GetByTypeAndWatcheeID(ctx, domain.UserWatcher, id)
 }
 
+func (p *postgresWatcherRepository) GetAllBySubredditID(ctx context.Context, id int64) ([]domain.Watcher, error) {
+	watchers, err := p.getByTypeAndWatcheeID(ctx, domain.SubredditWatcher, id, false)
+	if err != nil {
+		return nil, err
+	}
+
+	trending, err := p.getByTypeAndWatcheeID(ctx, domain.TrendingWatcher, id, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(watchers, trending...), nil
+}
+
+func (p *postgresWatcherRepository) GetAllByUserID(ctx context.Context, id int64) ([]domain.Watcher, error) {
+	return p.getByTypeAndWatcheeID(ctx, domain.UserWatcher, id, false)
+}
+
 func (p *postgresWatcherRepository) GetByDeviceAPNSTokenAndAccountRedditID(ctx context.Context, apns string, rid string) ([]domain.Watcher, error) {
 	query := `
 		SELECT
@@ -184,20 +341,54 @@ func (p *postgresWatcherRepository) GetByDeviceAPNSTokenAndAccountRedditID(ctx c
 			watchers.flair,
 			watchers.domain,
 			watchers.hits,
+			watchers.trending_sensitivity,
+			watchers.user_activity,
+			watchers.media_kind,
+			watchers.post_kind,
+			watchers.nsfw,
+			watchers.min_comments,
+			watchers.max_age,
+			watchers.max_notifications,
+			watchers.notification_window,
+			watchers.cooldown,
+			watchers.summarize_suppressed,
+			watchers.snoozed_until,
+			watchers.expires_at,
+			watchers.notify_on_expiry,
+			watchers.broken_reason,
+			watchers.broken_at,
+			watchers.notify_on_broken,
 			devices.id,
 			devices.apns_token,
 			devices.sandbox,
 			accounts.id,
 			accounts.reddit_account_id,
+			accounts.username,
 			accounts.access_token,
 			accounts.refresh_token,
 			COALESCE(subreddits.name, '') AS subreddit_label,
-			COALESCE(users.name, '') AS user_label
+			COALESCE(users.name, '') AS user_label,
+			COALESCE(feeds.path, '') AS feed_label,
+			ARRAY(
+				SELECT watchers_subreddits.subreddit_id
+				FROM watchers_subreddits
+				INNER JOIN subreddits ON watchers_subreddits.subreddit_id = subreddits.id
+				WHERE watchers_subreddits.watcher_id = watchers.id
+				ORDER BY subreddits.name
+			) AS subreddit_ids,
+			ARRAY(
+				SELECT subreddits.name
+				FROM watchers_subreddits
+				INNER JOIN subreddits ON watchers_subreddits.subreddit_id = subreddits.id
+				WHERE watchers_subreddits.watcher_id = watchers.id
+				ORDER BY subreddits.name
+			) AS subreddit_names
 		FROM watchers
 		INNER JOIN accounts ON watchers.account_id = accounts.id
 		INNER JOIN devices ON watchers.device_id = devices.id
 		LEFT JOIN subreddits ON watchers.type IN(0,2) AND watchers.watchee_id = subreddits.id
 		LEFT JOIN users ON watchers.type = 1 AND watchers.watchee_id = users.id
+		LEFT JOIN feeds ON watchers.type = 4 AND watchers.watchee_id = feeds.id
 		WHERE
 			devices.apns_token = $1 AND
 			accounts.reddit_account_id = $2`
@@ -213,10 +404,19 @@ func (p *postgresWatcherRepository) Create(ctx context.Context, watcher *domain.
 	now := time.Now()
 
 	query := `
-		INSERT INTO watchers
-			(created_at, last_notified_at, label, device_id, account_id, type, watchee_id, author, subreddit, upvotes, keyword, flair, domain)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
-		RETURNING id`
+		WITH watcher AS (
+			INSERT INTO watchers
+				(created_at, last_notified_at, label, device_id, account_id, type, watchee_id, author, subreddit, upvotes, keyword, flair, domain,
+				max_notifications, notification_window, cooldown, summarize_suppressed, snoozed_until, expires_at, notify_on_expiry, trending_sensitivity, user_activity,
+				media_kind, post_kind, nsfw, min_comments, max_age, notify_on_broken)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24, $25, $26, $27, $28, $29)
+			RETURNING id
+		), watcher_subreddits AS (
+			INSERT INTO watchers_subreddits (watcher_id, subreddit_id)
+			SELECT watcher.id, UNNEST($14::integer[])
+			FROM watcher
+		)
+		SELECT id FROM watcher`
 
 	return p.conn.QueryRow(
 		ctx,
@@ -234,6 +434,22 @@ func (p *postgresWatcherRepository) Create(ctx context.Context, watcher *domain.
 		watcher.Keyword,
 		watcher.Flair,
 		watcher.Domain,
+		watcher.SubredditIDs(),
+		watcher.MaxNotifications,
+		int64(watcher.NotificationWindow.Seconds()),
+		int64(watcher.Cooldown.Seconds()),
+		watcher.SummarizeSuppressed,
+		nullTime(watcher.SnoozedUntil),
+		nullTime(watcher.ExpiresAt),
+		watcher.NotifyOnExpiry,
+		int64(watcher.TrendingSensitivity),
+		int64(watcher.UserActivity),
+		int64(watcher.MediaKind),
+		int64(watcher.PostKind),
+		int64(watcher.NSFW),
+		watcher.MinComments,
+		int64(watcher.MaxAge.Seconds()),
+		watcher.NotifyOnBroken,
 	).Scan(&watcher.ID)
 }
 
@@ -242,17 +458,49 @@ func (p *postgresWatcherRepository) Update(ctx context.Context, watcher *domain.
 		return err
 	}
 
+	// Whatever broke the watcher no longer matters once it watches something else
 	query := `
-		UPDATE watchers
-		SET watchee_id = $2,
-			author = $3,
-			subreddit = $4,
-			upvotes = $5,
-			keyword = $6,
-			flair = $7,
-			domain = $8,
-			label = $9
-		WHERE id = $1`
+		WITH watcher AS (
+			UPDATE watchers
+			SET watchee_id = $2,
+				author = $3,
+				subreddit = $4,
+				upvotes = $5,
+				keyword = $6,
+				flair = $7,
+				domain = $8,
+				label = $9,
+				max_notifications = $11,
+				notification_window = $12,
+				cooldown = $13,
+				summarize_suppressed = $14,
+				snoozed_until = $15,
+				expires_at = $16,
+				notify_on_expiry = $17,
+				trending_sensitivity = $18,
+				user_activity = $19,
+				media_kind = $20,
+				post_kind = $21,
+				nsfw = $22,
+				min_comments = $23,
+				max_age = $24,
+				notify_on_broken = $25,
+				broken_reason = CASE WHEN watchee_id = $2 THEN broken_reason ELSE 0 END,
+				broken_at = CASE WHEN watchee_id = $2 THEN broken_at ELSE NULL END
+			WHERE id = $1
+			RETURNING id, type
+		), removed_subreddits AS (
+			DELETE FROM watchers_subreddits
+			USING watcher
+			WHERE watchers_subreddits.watcher_id = watcher.id AND
+			watcher.type = 3 AND
+			NOT (watchers_subreddits.subreddit_id = ANY($10::integer[]))
+		)
+		INSERT INTO watchers_subreddits (watcher_id, subreddit_id)
+		SELECT watcher.id, UNNEST($10::integer[])
+		FROM watcher
+		WHERE watcher.type = 3
+		ON CONFLICT (watcher_id, subreddit_id) DO NOTHING`
 
 	_, err := p.conn.Exec(
 		ctx,
@@ -266,6 +514,22 @@ func (p *postgresWatcherRepository) Update(ctx context.Context, watcher *domain.
 		watcher.Flair,
 		watcher.Domain,
 		watcher.Label,
+		watcher.SubredditIDs(),
+		watcher.MaxNotifications,
+		int64(watcher.NotificationWindow.Seconds()),
+		int64(watcher.Cooldown.Seconds()),
+		watcher.SummarizeSuppressed,
+		nullTime(watcher.SnoozedUntil),
+		nullTime(watcher.ExpiresAt),
+		watcher.NotifyOnExpiry,
+		int64(watcher.TrendingSensitivity),
+		int64(watcher.UserActivity),
+		int64(watcher.MediaKind),
+		int64(watcher.PostKind),
+		int64(watcher.NSFW),
+		watcher.MinComments,
+		int64(watcher.MaxAge.Seconds()),
+		watcher.NotifyOnBroken,
 	)
 
 	return err
@@ -283,8 +547,204 @@ func (p *postgresWatcherRepository) Delete(ctx context.Context, id int64) error
 	return err
 }
 
+func (p *postgresWatcherRepository) DeleteExpired(ctx context.Context, before time.Time) ([]domain.Watcher, error) {
+	query := `
+		WITH expired AS (
+			DELETE FROM watchers
+			WHERE expires_at IS NOT NULL AND expires_at <= $1
+			RETURNING id, label, type, device_id, notify_on_expiry
+		)
+		SELECT expired.id, expired.label, expired.type, expired.notify_on_expiry, devices.id, devices.apns_token, devices.sandbox
+		FROM expired
+		INNER JOIN devices ON expired.device_id = devices.id`
+
+	rows, err := p.conn.Query(ctx, query, before)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var watchers []domain.Watcher
+	for rows.Next() {
+		var watcher domain.Watcher
+		if err := rows.Scan(
+			&watcher.ID,
+			&watcher.Label,
+			&watcher.Type,
+			&watcher.NotifyOnExpiry,
+			&watcher.Device.ID,
+			&watcher.Device.APNSToken,
+			&watcher.Device.Sandbox,
+		); err != nil {
+			return nil, err
+		}
+		watcher.DeviceID = watcher.Device.ID
+		watchers = append(watchers, watcher)
+	}
+	return watchers, nil
+}
+
 func (p *postgresWatcherRepository) DeleteByTypeAndWatcheeID(ctx context.Context, typ domain.WatcherType, id int64) error {
 	query := `DELETE FROM watchers WHERE type = $1 AND watchee_id = $2`
 	_, err := p.conn.Exec(ctx, query, int64(typ), id)
 	return err
 }
+
+// SetBrokenBySubredditID records the health of a subreddit on every watcher
+// covering it. Multi-subreddit watchers stay broken for as long as any of
+// their other subreddits is.
+func (p *postgresWatcherRepository) SetBrokenBySubredditID(ctx context.Context, id int64, reason domain.WatcherBrokenReason) ([]domain.Watcher, error) {
+	query := `
+		WITH subreddit AS (
+			UPDATE subreddits
+			SET broken_reason = $2
+			WHERE id = $1
+		), changed AS (
+			UPDATE watchers
+			SET broken_reason = $2, broken_at = $3
+			WHERE broken_reason <> $2 AND (
+				(type IN(0,2) AND watchee_id = $1) OR
+				(type = 3 AND EXISTS (
+					SELECT 1
+					FROM watchers_subreddits
+					WHERE watchers_subreddits.watcher_id = watchers.id AND
+					watchers_subreddits.subreddit_id = $1
+				) AND NOT EXISTS (
+					SELECT 1
+					FROM watchers_subreddits
+					INNER JOIN subreddits ON watchers_subreddits.subreddit_id = subreddits.id
+					WHERE watchers_subreddits.watcher_id = watchers.id AND
+					watchers_subreddits.subreddit_id <> $1 AND
+					subreddits.broken_reason <> 0
+				))
+			)
+			RETURNING id, label, type, device_id, notify_on_broken
+		)
+		SELECT changed.id, changed.label, changed.type, changed.notify_on_broken, devices.id, devices.apns_token, devices.sandbox
+		FROM changed
+		INNER JOIN devices ON changed.device_id = devices.id`
+
+	return p.fetchChangedHealth(ctx, query, id, reason)
+}
+
+func (p *postgresWatcherRepository) SetBrokenByUserID(ctx context.Context, id int64, reason domain.WatcherBrokenReason) ([]domain.Watcher, error) {
+	query := `
+		WITH changed AS (
+			UPDATE watchers
+			SET broken_reason = $2, broken_at = $3
+			WHERE broken_reason <> $2 AND type = 1 AND watchee_id = $1
+			RETURNING id, label, type, device_id, notify_on_broken
+		)
+		SELECT changed.id, changed.label, changed.type, changed.notify_on_broken, devices.id, devices.apns_token, devices.sandbox
+		FROM changed
+		INNER JOIN devices ON changed.device_id = devices.id`
+
+	return p.fetchChangedHealth(ctx, query, id, reason)
+}
+
+func (p *postgresWatcherRepository) fetchChangedHealth(ctx context.Context, query string, id int64, reason domain.WatcherBrokenReason) ([]domain.Watcher, error) {
+	var brokenAt time.Time
+	if reason != domain.WatcherNotBroken {
+		brokenAt = time.Now()
+	}
+
+	rows, err := p.conn.Query(ctx, query, id, int64(reason), nullTime(brokenAt))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var watchers []domain.Watcher
+	for rows.Next() {
+		var watcher domain.Watcher
+		if err := rows.Scan(
+			&watcher.ID,
+			&watcher.Label,
+			&watcher.Type,
+			&watcher.NotifyOnBroken,
+			&watcher.Device.ID,
+			&watcher.Device.APNSToken,
+			&watcher.Device.Sandbox,
+		); err != nil {
+			return nil, err
+		}
+		watcher.DeviceID = watcher.Device.ID
+		watcher.BrokenReason = reason
+		watcher.BrokenAt = brokenAt
+		watchers = append(watchers, watcher)
+	}
+	return watchers, rows.Err()
+}
+
+func (p *postgresWatcherRepository) CreateHit(ctx context.Context, hit *domain.WatcherHit) error {
+	query := `
+		INSERT INTO watcher_hits (watcher_id, post_id, title, subreddit, author, matched_at, status)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id`
+
+	return p.conn.QueryRow(
+		ctx,
+		query,
+		hit.WatcherID,
+		hit.PostID,
+		hit.Title,
+		hit.Subreddit,
+		hit.Author,
+		hit.MatchedAt,
+		int64(hit.Status),
+	).Scan(&hit.ID)
+}
+
+// GetHits returns a watcher's hits newest first. Pages are walked by passing the
+// ID of the last hit seen as before, zero starts from the newest.
+func (p *postgresWatcherRepository) GetHits(ctx context.Context, watcherID int64, before int64, limit int) ([]domain.WatcherHit, error) {
+	query := `
+		SELECT id, watcher_id, post_id, title, subreddit, author, matched_at, status
+		FROM watcher_hits
+		WHERE watcher_id = $1 AND ($2 = 0 OR id < $2)
+		ORDER BY id DESC
+		LIMIT $3`
+
+	rows, err := p.conn.Query(ctx, query, watcherID, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hits []domain.WatcherHit
+	for rows.Next() {
+		var hit domain.WatcherHit
+		var status int64
+		if err := rows.Scan(
+			&hit.ID,
+			&hit.WatcherID,
+			&hit.PostID,
+			&hit.Title,
+			&hit.Subreddit,
+			&hit.Author,
+			&hit.MatchedAt,
+			&status,
+		); err != nil {
+			return nil, err
+		}
+		hit.Status = domain.WatcherHitStatus(status)
+		hits = append(hits, hit)
+	}
+	return hits, nil
+}
+
+func (p *postgresWatcherRepository) DeleteHitsBefore(ctx context.Context, before time.Time) (int64, error) {
+	query := `DELETE FROM watcher_hits WHERE matched_at < $1`
+
+	res, err := p.conn.Exec(ctx, query, before)
+
+	return res.RowsAffected(), err
+}
+
+// nullTime stores zero times as NULL.
+func nullTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
@@ -0,0 +1,96 @@
+// Package tokencrypt encrypts reddit tokens we have to keep around, so they're
+// never stored in the clear.
+package tokencrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Sealed tokens carry a version prefix, so we can tell them apart from tokens
+// stored before encryption and change schemes later on.
+const SealedPrefix = "v1:"
+
+var ErrMalformed = errors.New("malformed sealed token")
+
+type Sealer struct {
+	aead cipher.AEAD
+}
+
+// New returns a sealer encrypting with AES-256-GCM under a 32 byte key.
+func New(key []byte) (*Sealer, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("token key must be 32 bytes, got %d", len(key))
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Sealer{aead}, nil
+}
+
+// NewFromBase64 returns a sealer using a base64 encoded key, like it's kept in the environment.
+func NewFromBase64(key string) (*Sealer, error) {
+	bb, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid token key: %w", err)
+	}
+
+	return New(bb)
+}
+
+func (s *Sealer) Seal(token string) (string, error) {
+	if token == "" {
+		return "", nil
+	}
+
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := s.aead.Seal(nonce, nonce, []byte(token), nil)
+	return SealedPrefix + base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// IsSealed says whether a token was encrypted, as opposed to stored before we
+// started doing so. Empty tokens have nothing to hide.
+func IsSealed(token string) bool {
+	return token == "" || strings.HasPrefix(token, SealedPrefix)
+}
+
+// Open decrypts a sealed token. Tokens stored before we started encrypting
+// them are handed back as they are.
+func (s *Sealer) Open(token string) (string, error) {
+	if !strings.HasPrefix(token, SealedPrefix) {
+		return token, nil
+	}
+
+	bb, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(token, SealedPrefix))
+	if err != nil {
+		return "", ErrMalformed
+	}
+
+	ns := s.aead.NonceSize()
+	if len(bb) < ns {
+		return "", ErrMalformed
+	}
+
+	plain, err := s.aead.Open(nil, bb[:ns], bb[ns:], nil)
+	if err != nil {
+		return "", ErrMalformed
+	}
+	return string(plain), nil
+}
@@ -0,0 +1,87 @@
+package tokencrypt_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/christianselig/apollo-backend/internal/tokencrypt"
+)
+
+func TestSealer(t *testing.T) {
+	t.Parallel()
+
+	key := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{42}, 32))
+	sealer, err := tokencrypt.NewFromBase64(key)
+	require.NoError(t, err)
+
+	other, err := tokencrypt.New(bytes.Repeat([]byte{7}, 32))
+	require.NoError(t, err)
+
+	sealed, err := sealer.Seal("1234-abcdef")
+	require.NoError(t, err)
+	assert.NotContains(t, sealed, "abcdef")
+
+	again, err := sealer.Seal("1234-abcdef")
+	require.NoError(t, err)
+	assert.NotEqual(t, sealed, again)
+
+	// Flip the last character so the tag no longer checks out
+	tampered := sealed[:len(sealed)-1] + "A"
+	if strings.HasSuffix(sealed, "A") {
+		tampered = sealed[:len(sealed)-1] + "B"
+	}
+
+	tt := map[string]struct {
+		sealer *tokencrypt.Sealer
+		token  string
+		want   string
+		err    error
+	}{
+		"sealed":        {sealer, sealed, "1234-abcdef", nil},
+		"stored before": {sealer, "1234-abcdef", "1234-abcdef", nil},
+		"empty":         {sealer, "", "", nil},
+		"wrong key":     {other, sealed, "", tokencrypt.ErrMalformed},
+		"tampered":      {sealer, tampered, "", tokencrypt.ErrMalformed},
+		"truncated":     {sealer, "v1:AAAA", "", tokencrypt.ErrMalformed},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			token, err := tc.sealer.Open(tc.token)
+			assert.Equal(t, tc.err, err)
+			assert.Equal(t, tc.want, token)
+		})
+	}
+}
+
+func TestIsSealed(t *testing.T) {
+	t.Parallel()
+
+	sealer, err := tokencrypt.New(bytes.Repeat([]byte{42}, 32))
+	require.NoError(t, err)
+
+	sealed, err := sealer.Seal("1234-abcdef")
+	require.NoError(t, err)
+
+	assert.True(t, tokencrypt.IsSealed(sealed))
+	assert.True(t, tokencrypt.IsSealed(""))
+	assert.False(t, tokencrypt.IsSealed("1234-abcdef"))
+}
+
+func TestNewKeySize(t *testing.T) {
+	t.Parallel()
+
+	_, err := tokencrypt.New([]byte(strings.Repeat("a", 16)))
+	assert.Error(t, err)
+
+	_, err = tokencrypt.NewFromBase64("not base64!")
+	assert.Error(t, err)
+}
@@ -0,0 +1,123 @@
+package trending
+
+import (
+	"sort"
+	"time"
+
+	"github.com/christianselig/apollo-backend/internal/domain"
+)
+
+const (
+	// How far back we look when measuring how fast a post is currently rising
+	Lookback = time.Hour
+
+	// Snapshots closer together than this are too noisy to measure a velocity from
+	minimumSpan = 5 * time.Minute
+
+	// Fresh posts get this much age at least so a handful of early votes don't look explosive
+	minimumAge = 15 * time.Minute
+
+	// Posts rising slower than this never trend, however quiet the subreddit is
+	MinimumVelocity = 10.0
+
+	// Number of posts needed to establish a subreddit's baseline
+	MinimumSample = 10
+)
+
+// Snapshot is a post's score and comment count at a point in time.
+type Snapshot struct {
+	At       time.Time
+	Score    int64
+	Comments int64
+}
+
+// Trend is how fast a post is gaining score and comments, per hour.
+type Trend struct {
+	ScoreVelocity   float64
+	CommentVelocity float64
+}
+
+// Velocity measures a post's trend from its snapshots, which must be sorted
+// oldest first. With only one usable snapshot it falls back to the average
+// rate since the post was created, discounted for posts older than Lookback.
+func Velocity(createdAt time.Time, history []Snapshot) Trend {
+	if len(history) == 0 {
+		return Trend{}
+	}
+
+	latest := history[len(history)-1]
+
+	earliest := latest
+	for _, s := range history {
+		if !s.At.Before(latest.At.Add(-Lookback)) {
+			earliest = s
+			break
+		}
+	}
+
+	if span := latest.At.Sub(earliest.At); span >= minimumSpan {
+		return Trend{
+			ScoreVelocity:   float64(latest.Score-earliest.Score) / span.Hours(),
+			CommentVelocity: float64(latest.Comments-earliest.Comments) / span.Hours(),
+		}
+	}
+
+	age := latest.At.Sub(createdAt)
+	if age < minimumAge {
+		age = minimumAge
+	}
+
+	// Posts get most of their score early on, so an older post's average rate
+	// says more about how it did back then than how it's doing now. Scale it
+	// down by how much older than the lookback window the post is.
+	scale := 1.0
+	if age > Lookback {
+		scale = Lookback.Hours() / age.Hours()
+	}
+
+	return Trend{
+		ScoreVelocity:   float64(latest.Score) / age.Hours() * scale,
+		CommentVelocity: float64(latest.Comments) / age.Hours() * scale,
+	}
+}
+
+// Baseline is the median score velocity of a subreddit's posts.
+func Baseline(trends []Trend) float64 {
+	if len(trends) == 0 {
+		return 0
+	}
+
+	velocities := make([]float64, len(trends))
+	for i, t := range trends {
+		velocities[i] = t.ScoreVelocity
+	}
+	sort.Float64s(velocities)
+
+	mid := len(velocities) / 2
+	if len(velocities)%2 == 0 {
+		return (velocities[mid-1] + velocities[mid]) / 2
+	}
+	return velocities[mid]
+}
+
+// Multiplier is how many times faster than the baseline a post has to rise to trend.
+func Multiplier(s domain.TrendingSensitivity) float64 {
+	switch s {
+	case domain.LowTrendingSensitivity:
+		return 5
+	case domain.HighTrendingSensitivity:
+		return 2
+	default:
+		return 3
+	}
+}
+
+// IsTrending reports whether a post rising at the given trend stands out
+// enough from the subreddit's baseline for a watcher with the given sensitivity.
+func IsTrending(t Trend, baseline float64, s domain.TrendingSensitivity) bool {
+	if t.ScoreVelocity < MinimumVelocity {
+		return false
+	}
+
+	return t.ScoreVelocity >= baseline*Multiplier(s)
+}
@@ -0,0 +1,120 @@
+package trending_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/christianselig/apollo-backend/internal/domain"
+	"github.com/christianselig/apollo-backend/internal/trending"
+)
+
+// series builds snapshots every interval starting at start, one per score.
+func series(start time.Time, interval time.Duration, scores ...int64) []trending.Snapshot {
+	snapshots := make([]trending.Snapshot, len(scores))
+	for i, score := range scores {
+		snapshots[i] = trending.Snapshot{At: start.Add(time.Duration(i) * interval), Score: score, Comments: score / 10}
+	}
+	return snapshots
+}
+
+func TestVelocity(t *testing.T) {
+	t.Parallel()
+
+	created := time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	tt := map[string]struct {
+		history  []trending.Snapshot
+		velocity float64
+		comments float64
+	}{
+		"no history":                {nil, 0, 0},
+		"single snapshot uses age":  {series(created.Add(30*time.Minute), time.Minute, 200), 400, 40},
+		"old post is discounted":    {series(created.Add(2*time.Hour), time.Minute, 200), 50, 5},
+		"young post uses min age":   {series(created.Add(time.Minute), time.Minute, 10), 40, 4},
+		"steady rise":               {series(created, 10*time.Minute, 0, 50, 100, 150), 300, 30},
+		"snapshots too close":       {series(created.Add(59*time.Minute), time.Minute, 100, 130), 130, 13},
+		"only uses lookback window": {series(created, 30*time.Minute, 0, 1000, 1000, 1100), 100, 10},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			trend := trending.Velocity(created, tc.history)
+			assert.InDelta(t, tc.velocity, trend.ScoreVelocity, 0.01)
+			assert.InDelta(t, tc.comments, trend.CommentVelocity, 0.01)
+		})
+	}
+}
+
+func TestVelocityFallbackFavorsNewPosts(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	// Both got 100 points an hour on average since they were posted
+	old := trending.Velocity(now.Add(-10*time.Hour), series(now, time.Minute, 1000))
+	fresh := trending.Velocity(now.Add(-30*time.Minute), series(now, time.Minute, 50))
+
+	assert.InDelta(t, 10, old.ScoreVelocity, 0.01)
+	assert.InDelta(t, 100, fresh.ScoreVelocity, 0.01)
+	assert.False(t, trending.IsTrending(old, 5, domain.NormalTrendingSensitivity))
+	assert.True(t, trending.IsTrending(fresh, 5, domain.NormalTrendingSensitivity))
+}
+
+func TestBaseline(t *testing.T) {
+	t.Parallel()
+
+	tt := map[string]struct {
+		velocities []float64
+		want       float64
+	}{
+		"empty": {nil, 0},
+		"odd":   {[]float64{50, 10, 30}, 30},
+		"even":  {[]float64{40, 10, 20, 30}, 25},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			trends := make([]trending.Trend, len(tc.velocities))
+			for i, v := range tc.velocities {
+				trends[i] = trending.Trend{ScoreVelocity: v}
+			}
+			assert.Equal(t, tc.want, trending.Baseline(trends))
+		})
+	}
+}
+
+func TestIsTrending(t *testing.T) {
+	t.Parallel()
+
+	tt := map[string]struct {
+		velocity    float64
+		baseline    float64
+		sensitivity domain.TrendingSensitivity
+		want        bool
+	}{
+		"below minimum velocity":    {5, 1, domain.HighTrendingSensitivity, false},
+		"normal above threshold":    {300, 100, domain.NormalTrendingSensitivity, true},
+		"normal below threshold":    {250, 100, domain.NormalTrendingSensitivity, false},
+		"high catches slower posts": {250, 100, domain.HighTrendingSensitivity, true},
+		"low needs faster posts":    {400, 100, domain.LowTrendingSensitivity, false},
+		"quiet subreddit":           {20, 0, domain.NormalTrendingSensitivity, true},
+	}
+
+	for scenario, tc := range tt {
+		tc := tc
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+
+			trend := trending.Trend{ScoreVelocity: tc.velocity}
+			assert.Equal(t, tc.want, trending.IsTrending(trend, tc.baseline, tc.sensitivity))
+		})
+	}
+}
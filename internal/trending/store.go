@@ -0,0 +1,88 @@
+package trending
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"github.com/christianselig/apollo-backend/internal/reddit"
+)
+
+// Posts only trend while they're young, so there's no point keeping history any longer
+const historyTTL = 48 * time.Hour
+
+// Store keeps score and comment snapshots of posts in Redis, one sorted set
+// per post scored by the time of the snapshot.
+type Store struct {
+	redis *redis.Client
+}
+
+func NewStore(redis *redis.Client) *Store {
+	return &Store{redis}
+}
+
+func snapshotsKey(postID string) string {
+	return fmt.Sprintf("trending:%s:snapshots", postID)
+}
+
+func encodeSnapshot(s Snapshot) string {
+	return fmt.Sprintf("%d:%d:%d", s.At.Unix(), s.Score, s.Comments)
+}
+
+func decodeSnapshot(member string) (Snapshot, error) {
+	parts := strings.Split(member, ":")
+	if len(parts) != 3 {
+		return Snapshot{}, fmt.Errorf("malformed snapshot %q", member)
+	}
+
+	var vals [3]int64
+	for i, part := range parts {
+		val, err := strconv.ParseInt(part, 10, 64)
+		if err != nil {
+			return Snapshot{}, fmt.Errorf("malformed snapshot %q: %w", member, err)
+		}
+		vals[i] = val
+	}
+
+	return Snapshot{At: time.Unix(vals[0], 0), Score: vals[1], Comments: vals[2]}, nil
+}
+
+// Record stores a snapshot of every post as of now and hands back each post's
+// history, oldest first, keyed by post ID.
+func (s *Store) Record(ctx context.Context, posts []*reddit.Thing, now time.Time) (map[string][]Snapshot, error) {
+	cutoff := strconv.FormatInt(now.Add(-historyTTL).Unix(), 10)
+
+	pipe := s.redis.Pipeline()
+	cmds := make([]*redis.StringSliceCmd, len(posts))
+
+	for i, post := range posts {
+		key := snapshotsKey(post.ID)
+		snapshot := Snapshot{At: now, Score: post.Score, Comments: int64(post.NumComments)}
+
+		pipe.ZAdd(ctx, key, &redis.Z{Score: float64(now.Unix()), Member: encodeSnapshot(snapshot)})
+		pipe.ZRemRangeByScore(ctx, key, "-inf", "("+cutoff)
+		pipe.Expire(ctx, key, historyTTL)
+		cmds[i] = pipe.ZRange(ctx, key, 0, -1)
+	}
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	histories := make(map[string][]Snapshot, len(posts))
+	for i, post := range posts {
+		for _, member := range cmds[i].Val() {
+			snapshot, err := decodeSnapshot(member)
+			if err != nil {
+				return nil, err
+			}
+			histories[post.ID] = append(histories[post.ID], snapshot)
+		}
+	}
+
+	return histories, nil
+}
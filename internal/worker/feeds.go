@@ -0,0 +1,254 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DataDog/datadog-go/statsd"
+	"github.com/adjust/rmq/v5"
+	"github.com/go-redis/redis/v8"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/sideshow/apns2"
+	"github.com/sideshow/apns2/token"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+
+	"github.com/christianselig/apollo-backend/internal/domain"
+	"github.com/christianselig/apollo-backend/internal/reddit"
+	"github.com/christianselig/apollo-backend/internal/repository"
+)
+
+type feedsWorker struct {
+	context.Context
+
+	logger *zap.Logger
+	tracer trace.Tracer
+	statsd *statsd.Client
+	db     *pgxpool.Pool
+	redis  *redis.Client
+	queue  rmq.Connection
+	reddit *reddit.Client
+	apns   *token.Token
+
+	consumers int
+
+	feedRepo    domain.FeedRepository
+	watcherRepo domain.WatcherRepository
+
+	limiter *watcherLimiter
+}
+
+func NewFeedsWorker(ctx context.Context, logger *zap.Logger, tracer trace.Tracer, statsd *statsd.Client, db *pgxpool.Pool, redis *redis.Client, queue rmq.Connection, consumers int) Worker {
+	reddit := reddit.NewClient(
+		os.Getenv("REDDIT_CLIENT_ID"),
+		os.Getenv("REDDIT_CLIENT_SECRET"),
+		tracer,
+		statsd,
+		redis,
+		consumers,
+	)
+
+	var apns *token.Token
+	{
+		authKey, err := token.AuthKeyFromFile(os.Getenv("APPLE_KEY_PATH"))
+		if err != nil {
+			panic(err)
+		}
+
+		apns = &token.Token{
+			AuthKey: authKey,
+			KeyID:   os.Getenv("APPLE_KEY_ID"),
+			TeamID:  os.Getenv("APPLE_TEAM_ID"),
+		}
+	}
+
+	return &feedsWorker{
+		ctx,
+		logger,
+		tracer,
+		statsd,
+		db,
+		redis,
+		queue,
+		reddit,
+		apns,
+		consumers,
+
+		repository.NewPostgresFeed(db),
+		repository.NewPostgresWatcher(db),
+
+		newWatcherLimiter(logger, statsd, redis),
+	}
+}
+
+func (fw *feedsWorker) Start() error {
+	queue, err := fw.queue.OpenQueue("feeds")
+	if err != nil {
+		return err
+	}
+
+	fw.logger.Info("starting up feeds worker", zap.Int("consumers", fw.consumers))
+
+	prefetchLimit := int64(fw.consumers * 2)
+
+	if err := queue.StartConsuming(prefetchLimit, pollDuration); err != nil {
+		return err
+	}
+
+	host, _ := os.Hostname()
+
+	for i := 0; i < fw.consumers; i++ {
+		name := fmt.Sprintf("consumer %s-%d", host, i)
+
+		consumer := NewFeedsConsumer(fw, i)
+		if _, err := queue.AddConsumer(name, consumer); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (fw *feedsWorker) Stop() {
+	<-fw.queue.StopAllConsuming() // wait for all Consume() calls to finish
+}
+
+type feedsConsumer struct {
+	*feedsWorker
+	tag int
+
+	apnsSandbox    *apns2.Client
+	apnsProduction *apns2.Client
+
+	notifier *postWatcherNotifier
+}
+
+func NewFeedsConsumer(fw *feedsWorker, tag int) *feedsConsumer {
+	apnsSandbox := apns2.NewTokenClient(fw.apns)
+	apnsProduction := apns2.NewTokenClient(fw.apns).Production()
+
+	return &feedsConsumer{
+		fw,
+		tag,
+		apnsSandbox,
+		apnsProduction,
+		&postWatcherNotifier{fw.logger, fw.statsd, fw.redis, fw.watcherRepo, fw.limiter, apnsSandbox, apnsProduction},
+	}
+}
+
+func (fc *feedsConsumer) Consume(delivery rmq.Delivery) {
+	ctx, cancel := context.WithCancel(fc)
+	defer cancel()
+
+	id, err := strconv.ParseInt(delivery.Payload(), 10, 64)
+	if err != nil {
+		fc.logger.Error("failed to parse feed id from payload", zap.Error(err), zap.String("payload", delivery.Payload()))
+		_ = delivery.Reject()
+		return
+	}
+
+	fc.logger.Debug("starting job", zap.Int64("feed#id", id))
+
+	defer func() { _ = delivery.Ack() }()
+
+	feed, err := fc.feedRepo.GetByID(ctx, id)
+	if err != nil {
+		fc.logger.Error("failed to fetch feed from database", zap.Error(err), zap.Int64("feed#id", id))
+		return
+	}
+
+	watchers, err := fc.watcherRepo.GetByFeedID(ctx, feed.ID)
+	if err != nil {
+		fc.logger.Error("failed to fetch watchers from database",
+			zap.Error(err),
+			zap.Int64("feed#id", id),
+			zap.String("feed#path", feed.Path),
+		)
+		return
+	}
+
+	if len(watchers) == 0 {
+		fc.logger.Debug("no watchers for feed, bailing early",
+			zap.Int64("feed#id", id),
+			zap.String("feed#path", feed.Path),
+		)
+		return
+	}
+
+	fc.limiter.Summarize(ctx, watchers, fc.apnsProduction, fc.apnsSandbox)
+
+	// Feeds move too fast to page through, so we only ever look at the newest 100 posts
+	watcher := pollingWatcher(feed, watchers)
+
+	rac := fc.reddit.NewAuthenticatedClient(watcher.Account.AccountID, watcher.Account.RefreshToken, watcher.Account.AccessToken)
+	fps, err := rac.FeedNew(ctx,
+		feed.Path,
+		reddit.WithQuery("limit", "100"),
+		reddit.WithQuery("show", "all"),
+		reddit.WithQuery("always_show_media", "1"),
+	)
+	if err != nil {
+		fc.logger.Error("failed to fetch new posts",
+			zap.Error(err),
+			zap.Int64("feed#id", id),
+			zap.String("feed#path", feed.Path),
+		)
+
+		switch err {
+		case reddit.ErrOauthRevoked:
+			fc.logger.Info("deleting watcher",
+				zap.Int64("feed#id", id),
+				zap.String("feed#path", feed.Path),
+				zap.Int64("watcher#id", watcher.ID),
+			)
+			_ = fc.watcherRepo.Delete(ctx, watcher.ID)
+		case reddit.ErrSubredditNotFound:
+			fc.logger.Info("feed deleted, deleting watchers",
+				zap.Int64("feed#id", id),
+				zap.String("feed#path", feed.Path),
+			)
+			_ = fc.watcherRepo.DeleteByTypeAndWatcheeID(ctx, domain.FeedWatcher, feed.ID)
+		}
+
+		return
+	}
+
+	posts, _ := newPosts(fps.Children, map[string]bool{}, time.Now().Add(-24*time.Hour), "")
+
+	fc.logger.Debug("checking posts for watcher hits",
+		zap.Int64("feed#id", id),
+		zap.String("feed#path", feed.Path),
+		zap.Int("count", len(posts)),
+	)
+
+	if err := fc.notifier.notify(ctx, posts, watchers,
+		zap.Int64("feed#id", id),
+		zap.String("feed#path", feed.Path),
+	); err != nil {
+		return
+	}
+
+	fc.logger.Debug("finishing job",
+		zap.Int64("feed#id", id),
+		zap.String("feed#path", feed.Path),
+	)
+}
+
+// pollingWatcher picks whose account to fetch a feed as. Private multireddits
+// can only be seen by their owner, so it's them whenever they watch it.
+func pollingWatcher(feed domain.Feed, watchers []domain.Watcher) domain.Watcher {
+	if owner := feed.Owner(); owner != "" {
+		for _, watcher := range watchers {
+			if strings.EqualFold(watcher.Account.Username, owner) {
+				return watcher
+			}
+		}
+	}
+
+	return watchers[rand.Intn(len(watchers))]
+}
@@ -0,0 +1,64 @@
+package worker
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/christianselig/apollo-backend/internal/domain"
+	"github.com/christianselig/apollo-backend/internal/repository"
+	"github.com/christianselig/apollo-backend/internal/testhelper"
+)
+
+func TestPollingWatcherPicksOwner(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	conn := testhelper.NewTestPgxConn(t)
+
+	tx, err := conn.Begin(ctx)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = tx.Rollback(ctx)
+	})
+
+	devices := repository.NewPostgresDevice(tx)
+	accounts := repository.NewPostgresAccount(tx)
+	feeds := repository.NewPostgresFeed(tx)
+	watchers := repository.NewPostgresWatcher(tx)
+
+	dev := &domain.Device{APNSToken: "313a182b63224821f5595f42aa019de850a0e7b776253659a9aac8140bb8a3f2"}
+	require.NoError(t, devices.Create(ctx, dev))
+
+	feed := &domain.Feed{Path: "/u/Changelog/m/apple"}
+	require.NoError(t, feeds.CreateOrUpdate(ctx, feed))
+
+	for _, acct := range []*domain.Account{
+		{Username: "someone", AccountID: "1ia21"},
+		{Username: "Changelog", AccountID: "1ia22"},
+		{Username: "someone_else", AccountID: "1ia23"},
+	} {
+		require.NoError(t, accounts.Create(ctx, acct))
+		require.NoError(t, accounts.Associate(ctx, acct, dev))
+
+		watcher := &domain.Watcher{
+			Label:     "Apple",
+			DeviceID:  dev.ID,
+			AccountID: acct.ID,
+			Type:      domain.FeedWatcher,
+			WatcheeID: feed.ID,
+			Keyword:   "iphone",
+		}
+		require.NoError(t, watchers.Create(ctx, watcher))
+	}
+
+	ws, err := watchers.GetByFeedID(ctx, feed.ID)
+	require.NoError(t, err)
+	require.Len(t, ws, 3)
+
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, "Changelog", pollingWatcher(*feed, ws).Account.Username)
+	}
+}
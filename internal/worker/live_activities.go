@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net/http"
 	"os"
 	"sort"
 	"time"
@@ -20,16 +21,55 @@ import (
 	"github.com/christianselig/apollo-backend/internal/domain"
 	"github.com/christianselig/apollo-backend/internal/reddit"
 	"github.com/christianselig/apollo-backend/internal/repository"
+	"github.com/christianselig/apollo-backend/internal/tokencrypt"
+)
+
+const (
+	// How many of the best new comments a live activity rotates through
+	liveActivityRotationSize = 5
+
+	// How much a post has to move between updates for it to be worth a high priority push
+	liveActivitySignificantScore    = 100
+	liveActivitySignificantComments = 25
 )
 
 type DynamicIslandNotification struct {
-	PostCommentCount int    `json:"postTotalComments"`
-	PostScore        int64  `json:"postScore"`
-	CommentID        string `json:"commentId,omitempty"`
-	CommentAuthor    string `json:"commentAuthor,omitempty"`
-	CommentBody      string `json:"commentBody,omitempty"`
-	CommentAge       int64  `json:"commentAge,omitempty"`
-	CommentScore     int64  `json:"commentScore,omitempty"`
+	PostCommentCount      int    `json:"postTotalComments"`
+	PostCommentCountDelta int    `json:"postTotalCommentsDelta"`
+	PostScore             int64  `json:"postScore"`
+	PostScoreDelta        int64  `json:"postScoreDelta"`
+	CommentID             string `json:"commentId,omitempty"`
+	CommentAuthor         string `json:"commentAuthor,omitempty"`
+	CommentBody           string `json:"commentBody,omitempty"`
+	CommentAge            int64  `json:"commentAge,omitempty"`
+	CommentScore          int64  `json:"commentScore,omitempty"`
+}
+
+// unchanged returns whether din would show the same thing as what was sent last.
+func (din DynamicIslandNotification) unchanged(last DynamicIslandNotification) bool {
+	return din.PostScore == last.PostScore &&
+		din.PostCommentCount == last.PostCommentCount &&
+		din.CommentID == last.CommentID
+}
+
+// significant returns whether the post moved enough since the last update to
+// be worth interrupting for.
+func (din DynamicIslandNotification) significant() bool {
+	return din.PostScoreDelta >= liveActivitySignificantScore ||
+		din.PostScoreDelta <= -liveActivitySignificantScore ||
+		din.PostCommentCountDelta >= liveActivitySignificantComments
+}
+
+// liveActivityAuthorMatches returns whether a comment is by one of the kinds of
+// authors the live activity is restricted to, if it's restricted at all.
+func liveActivityAuthorMatches(authors domain.LiveActivityAuthors, comment *reddit.Thing) bool {
+	if authors == 0 {
+		return true
+	}
+
+	return (authors.Has(domain.LiveActivityOP) && comment.IsSubmitter) ||
+		(authors.Has(domain.LiveActivityModerators) && comment.Distinguished == "moderator") ||
+		(authors.Has(domain.LiveActivityFlaired) && comment.AuthorFlair != "")
 }
 
 type liveActivitiesWorker struct {
@@ -43,6 +83,7 @@ type liveActivitiesWorker struct {
 	queue  rmq.Connection
 	reddit *reddit.Client
 	apns   *token.Token
+	tokens *tokencrypt.Sealer
 
 	consumers int
 
@@ -73,6 +114,11 @@ func NewLiveActivitiesWorker(ctx context.Context, logger *zap.Logger, tracer tra
 		}
 	}
 
+	tokens, err := tokencrypt.NewFromBase64(os.Getenv("LIVE_ACTIVITY_TOKEN_KEY"))
+	if err != nil {
+		panic(err)
+	}
+
 	return &liveActivitiesWorker{
 		ctx,
 		logger,
@@ -83,6 +129,7 @@ func NewLiveActivitiesWorker(ctx context.Context, logger *zap.Logger, tracer tra
 		queue,
 		reddit,
 		apns,
+		tokens,
 		consumers,
 
 		repository.NewPostgresLiveActivity(db),
@@ -176,7 +223,21 @@ func (lac *liveActivitiesConsumer) Consume(delivery rmq.Delivery) {
 		return
 	}
 
-	rac := lac.reddit.NewAuthenticatedClient(la.RedditAccountID, la.RefreshToken, la.AccessToken)
+	// Tokens are stored encrypted, and la keeps them that way so it can be saved as is
+	accessToken, refreshToken, err := lac.openTokens(&la)
+	if err != nil {
+		lac.logger.Error("failed to decrypt reddit tokens", zap.Error(err), zap.String("live_activity#apns_token", at))
+		_ = lac.liveActivityRepo.Delete(ctx, at)
+		return
+	}
+
+	// Until the scheduler gets to encrypting them
+	if !tokencrypt.IsSealed(la.AccessToken) || !tokencrypt.IsSealed(la.RefreshToken) {
+		_ = lac.statsd.Incr("apollo.live_activities.plaintext_tokens", []string{}, 1)
+		lac.logger.Debug("read plaintext reddit tokens", zap.String("live_activity#apns_token", at))
+	}
+
+	rac := lac.reddit.NewAuthenticatedClient(la.RedditAccountID, refreshToken, accessToken)
 	if la.TokenExpiresAt.Before(now.Add(5 * time.Minute)) {
 		lac.logger.Debug("refreshing reddit token",
 			zap.String("live_activity#apns_token", at),
@@ -201,10 +262,12 @@ func (lac *liveActivitiesConsumer) Consume(delivery rmq.Delivery) {
 		}
 
 		// Update account
-		la.AccessToken = tokens.AccessToken
-		la.RefreshToken = tokens.RefreshToken
-		la.TokenExpiresAt = now.Add(tokens.Expiry)
-		_ = lac.liveActivityRepo.Update(ctx, &la)
+		if err := lac.sealTokens(&la, tokens.AccessToken, tokens.RefreshToken); err != nil {
+			lac.logger.Error("failed to encrypt reddit tokens", zap.Error(err), zap.String("live_activity#apns_token", at))
+		} else {
+			la.TokenExpiresAt = now.Add(tokens.Expiry)
+			_ = lac.liveActivityRepo.UpdateTokens(ctx, &la)
+		}
 
 		// Refresh client
 		rac = lac.reddit.NewAuthenticatedClient(la.RedditAccountID, tokens.RefreshToken, tokens.AccessToken)
@@ -227,9 +290,22 @@ func (lac *liveActivitiesConsumer) Consume(delivery rmq.Delivery) {
 		return
 	}
 
-	if len(tr.Children) == 0 && la.ExpiresAt.After(now) {
-		lac.logger.Debug("no comments found", zap.String("live_activity#apns_token", at))
-		return
+	// Keyed by thread too, so following another thread starts over
+	lastKey := fmt.Sprintf("live-activities:%s:%s:last", at, la.ThreadID)
+	shownKey := fmt.Sprintf("live-activities:%s:%s:shown", at, la.ThreadID)
+	quietKey := fmt.Sprintf("live-activities:%s:%s:quiet", at, la.ThreadID)
+
+	var last *DynamicIslandNotification
+	if bb, err := lac.redis.Get(ctx, lastKey).Bytes(); err == nil {
+		last = &DynamicIslandNotification{}
+		if err := json.Unmarshal(bb, last); err != nil {
+			last = nil
+		}
+	}
+
+	shown := map[string]bool{}
+	for _, id := range lac.redis.SMembers(ctx, shownKey).Val() {
+		shown[id] = true
 	}
 
 	// Filter out comments in the last minute
@@ -242,7 +318,7 @@ func (lac *liveActivitiesConsumer) Consume(delivery rmq.Delivery) {
 
 	for _, cutoff := range cutoffs {
 		for _, t := range tr.Children {
-			if t.CreatedAt.After(cutoff) {
+			if t.CreatedAt.After(cutoff) && liveActivityAuthorMatches(la.Authors, t) {
 				candidates = append(candidates, t)
 			}
 		}
@@ -252,28 +328,51 @@ func (lac *liveActivitiesConsumer) Consume(delivery rmq.Delivery) {
 		}
 	}
 
-	if len(candidates) == 0 && la.ExpiresAt.After(now) {
-		lac.logger.Debug("no new comments found", zap.String("live_activity#apns_token", at))
-		return
-	}
-
 	sort.Slice(candidates, func(i, j int) bool {
 		return candidates[i].Score > candidates[j].Score
 	})
 
+	if len(candidates) > liveActivityRotationSize {
+		candidates = candidates[:liveActivityRotationSize]
+	}
+
 	din := DynamicIslandNotification{
 		PostCommentCount: tr.Post.NumComments,
 		PostScore:        tr.Post.Score,
 	}
 
-	if len(candidates) > 0 {
-		comment := candidates[0]
+	for _, comment := range candidates {
+		if shown[comment.ID] {
+			continue
+		}
 
 		din.CommentID = comment.ID
 		din.CommentAuthor = comment.Author
 		din.CommentBody = comment.Body
 		din.CommentAge = comment.CreatedAt.Unix()
 		din.CommentScore = comment.Score
+		break
+	}
+
+	if last != nil {
+		// Nothing new to show, so keep showing the last comment
+		if din.CommentID == "" {
+			din.CommentID = last.CommentID
+			din.CommentAuthor = last.CommentAuthor
+			din.CommentBody = last.CommentBody
+			din.CommentAge = last.CommentAge
+			din.CommentScore = last.CommentScore
+		}
+
+		din.PostScoreDelta = din.PostScore - last.PostScore
+		din.PostCommentCountDelta = din.PostCommentCount - last.PostCommentCount
+	}
+
+	if !la.Ended(now) && last != nil && din.unchanged(*last) {
+		_ = lac.statsd.Incr("apollo.live_activities.skipped", []string{}, 1)
+		lac.logger.Debug("live activity unchanged, skipping", zap.String("live_activity#apns_token", at))
+		lac.backOff(ctx, &la, quietKey, now)
+		return
 	}
 
 	ev := "update"
@@ -281,6 +380,18 @@ func (lac *liveActivitiesConsumer) Consume(delivery rmq.Delivery) {
 		ev = "end"
 	}
 
+	// Routine updates go out at low priority, which Apple doesn't hold against
+	// the activity's budget. Only the first update, the end and big swings
+	// spend from it.
+	priority := apns2.PriorityLow
+	if ev == "end" || last == nil || din.significant() {
+		if lac.spendBudget(ctx, at) {
+			priority = apns2.PriorityHigh
+		} else {
+			_ = lac.statsd.Incr("apollo.live_activities.over_budget", []string{}, 1)
+		}
+	}
+
 	bb, _ := json.Marshal(map[string]interface{}{
 		"aps": map[string]interface{}{
 			"content-state":  din,
@@ -294,6 +405,7 @@ func (lac *liveActivitiesConsumer) Consume(delivery rmq.Delivery) {
 		DeviceToken: la.APNSToken,
 		Topic:       "com.christianselig.Apollo.push-type.liveactivity",
 		PushType:    "liveactivity",
+		Priority:    priority,
 		Payload:     bb,
 	}
 
@@ -313,6 +425,19 @@ func (lac *liveActivitiesConsumer) Consume(delivery rmq.Delivery) {
 		)
 
 		_ = lac.liveActivityRepo.Delete(ctx, at)
+	} else if res.StatusCode == http.StatusTooManyRequests {
+		// Apple wants us to slow down, which doesn't mean the activity is gone
+		_ = lac.statsd.Incr("apns.live_activities.throttled", []string{fmt.Sprintf("priority:%d", priority)}, 1)
+		lac.logger.Info("notification throttled",
+			zap.String("live_activity#apns_token", at),
+			zap.Bool("live_activity#development", la.Development),
+			zap.String("notification#type", ev),
+			zap.Int("notification#priority", priority),
+			zap.String("response#reason", res.Reason),
+		)
+
+		lac.backOff(ctx, &la, quietKey, now)
+		return
 	} else if !res.Sent() {
 		_ = lac.statsd.Incr("apns.live_activities.errors", []string{}, 1)
 		lac.logger.Error("notification not sent",
@@ -330,15 +455,94 @@ func (lac *liveActivitiesConsumer) Consume(delivery rmq.Delivery) {
 			zap.String("live_activity#apns_token", at),
 			zap.Bool("live_activity#development", la.Development),
 			zap.String("notification#type", ev),
+			zap.Int("notification#priority", priority),
 		)
+
+		bb, _ := json.Marshal(din)
+		pipe := lac.redis.Pipeline()
+		pipe.Set(ctx, lastKey, bb, domain.LiveActivityDuration)
+		pipe.Del(ctx, quietKey)
+		if din.CommentID != "" {
+			pipe.SAdd(ctx, shownKey, din.CommentID)
+			pipe.Expire(ctx, shownKey, domain.LiveActivityDuration)
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			lac.logger.Error("failed to save live activity state", zap.Error(err), zap.String("live_activity#apns_token", at))
+		}
 	}
 
 	if la.ExpiresAt.Before(now) {
 		lac.logger.Debug("live activity expired, deleting", zap.String("live_activity#apns_token", at))
 		_ = lac.liveActivityRepo.Delete(ctx, at)
+		_ = lac.redis.Del(ctx, lastKey, shownKey, quietKey).Err()
 	}
 
 	lac.logger.Debug("finishing job",
 		zap.String("live_activity#apns_token", at),
 	)
 }
+
+// backOff pushes the activity's next check further out each time in a row it
+// had nothing new, never past when it expires so it still ends on time.
+func (lac *liveActivitiesConsumer) backOff(ctx context.Context, la *domain.LiveActivity, quietKey string, now time.Time) {
+	quiet, err := lac.redis.Incr(ctx, quietKey).Result()
+	if err != nil {
+		lac.logger.Error("failed to track quiet live activity", zap.Error(err), zap.String("live_activity#apns_token", la.APNSToken))
+		return
+	}
+	_ = lac.redis.Expire(ctx, quietKey, domain.LiveActivityDuration).Err()
+
+	// An edit or end in the meantime reschedules the activity itself, and wins
+	err = lac.liveActivityRepo.Reschedule(ctx, la, now.Add(domain.LiveActivityBackoff(quiet)))
+	if err == domain.ErrConflict {
+		lac.logger.Debug("live activity changed while checking, not backing off", zap.String("live_activity#apns_token", la.APNSToken))
+	} else if err != nil {
+		lac.logger.Error("failed to back off live activity", zap.Error(err), zap.String("live_activity#apns_token", la.APNSToken))
+	}
+}
+
+// spendBudget takes one high priority update out of the activity's hourly
+// budget, and returns whether there was any left.
+func (lac *liveActivitiesConsumer) spendBudget(ctx context.Context, at string) bool {
+	key := fmt.Sprintf("live-activities:%s:budget", at)
+
+	spent, err := lac.redis.Incr(ctx, key).Result()
+	if err != nil {
+		lac.logger.Error("failed to track live activity budget", zap.Error(err), zap.String("live_activity#apns_token", at))
+		return false
+	}
+
+	if spent == 1 {
+		_ = lac.redis.Expire(ctx, key, time.Hour).Err()
+	}
+	return spent <= domain.LiveActivityHourlyBudget
+}
+
+func (lac *liveActivitiesConsumer) openTokens(la *domain.LiveActivity) (string, string, error) {
+	accessToken, err := lac.tokens.Open(la.AccessToken)
+	if err != nil {
+		return "", "", err
+	}
+
+	refreshToken, err := lac.tokens.Open(la.RefreshToken)
+	if err != nil {
+		return "", "", err
+	}
+
+	return accessToken, refreshToken, nil
+}
+
+func (lac *liveActivitiesConsumer) sealTokens(la *domain.LiveActivity, accessToken, refreshToken string) error {
+	sealedAccess, err := lac.tokens.Seal(accessToken)
+	if err != nil {
+		return err
+	}
+
+	sealedRefresh, err := lac.tokens.Seal(refreshToken)
+	if err != nil {
+		return err
+	}
+
+	la.AccessToken, la.RefreshToken = sealedAccess, sealedRefresh
+	return nil
+}
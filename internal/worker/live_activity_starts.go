@@ -0,0 +1,158 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/sideshow/apns2"
+	"go.uber.org/zap"
+
+	"github.com/christianselig/apollo-backend/internal/domain"
+	"github.com/christianselig/apollo-backend/internal/reddit"
+)
+
+// The ActivityAttributes type the app starts live activities with
+const liveActivityAttributesType = "ThreadActivityAttributes"
+
+// startLiveActivities starts a live activity on every device following a
+// thread that just went up, through the device's push-to-start token. The
+// activity is pending until the app hands us its own token.
+func (sc *subredditsConsumer) startLiveActivities(ctx context.Context, subreddit domain.Subreddit, subs []domain.LiveActivitySubscription, posts []*reddit.Thing) {
+	for _, post := range posts {
+		for _, las := range subs {
+			if las.Device.PushToStartToken == "" {
+				continue
+			}
+
+			// Same as watchers, only threads that went up after following them count
+			if las.CreatedAt.After(post.CreatedAt) {
+				continue
+			}
+
+			// Threads stay in the listings for a while, but should only start once
+			if time.Since(post.CreatedAt) > domain.LiveActivityDuration {
+				continue
+			}
+
+			if !las.TitleMatches(post.Title) {
+				continue
+			}
+
+			lockKey := fmt.Sprintf("live-activity-subscription:%d:%s", las.ID, post.ID)
+			if ok, _ := sc.redis.SetNX(ctx, lockKey, true, 24*time.Hour).Result(); !ok {
+				continue
+			}
+
+			sc.startLiveActivity(ctx, subreddit, las, post)
+		}
+	}
+}
+
+func (sc *subredditsConsumer) startLiveActivity(ctx context.Context, subreddit domain.Subreddit, las domain.LiveActivitySubscription, post *reddit.Thing) {
+	now := time.Now()
+
+	// Activities are stored with encrypted tokens, which only the live activities worker opens
+	accessToken, err := sc.tokens.Seal(las.Account.AccessToken)
+	if err != nil {
+		sc.logger.Error("failed to encrypt reddit tokens", zap.Error(err), zap.Int64("live_activity_subscription#id", las.ID))
+		return
+	}
+	refreshToken, err := sc.tokens.Seal(las.Account.RefreshToken)
+	if err != nil {
+		sc.logger.Error("failed to encrypt reddit tokens", zap.Error(err), zap.Int64("live_activity_subscription#id", las.ID))
+		return
+	}
+
+	la := domain.LiveActivity{
+		Development:     las.Device.Sandbox,
+		DeviceID:        las.Device.ID,
+		RedditAccountID: las.Account.AccountID,
+		AccessToken:     accessToken,
+		RefreshToken:    refreshToken,
+		TokenExpiresAt:  las.Account.TokenExpiresAt,
+		ThreadID:        post.ID,
+		Subreddit:       post.Subreddit,
+		Authors:         las.Authors,
+	}
+
+	// Saved first so the same thread is never started twice on a device, even
+	// when more than one of its subscriptions matches it
+	if err := sc.liveActivityRepo.CreatePending(ctx, &la); err == domain.ErrConflict {
+		return
+	} else if err != nil {
+		sc.logger.Error("failed to save started live activity",
+			zap.Error(err),
+			zap.Int64("subreddit#id", subreddit.ID),
+			zap.String("subreddit#name", subreddit.NormalizedName()),
+			zap.Int64("live_activity_subscription#id", las.ID),
+			zap.String("post#id", post.ID),
+		)
+		return
+	}
+
+	bb, _ := json.Marshal(map[string]interface{}{
+		"aps": map[string]interface{}{
+			"event":     "start",
+			"timestamp": now.Unix(),
+			"content-state": DynamicIslandNotification{
+				PostCommentCount: post.NumComments,
+				PostScore:        post.Score,
+			},
+			"attributes-type": liveActivityAttributesType,
+			"attributes": map[string]interface{}{
+				"threadId":   post.ID,
+				"subreddit":  post.Subreddit,
+				"postTitle":  post.Title,
+				"postAuthor": post.Author,
+			},
+			"alert": map[string]interface{}{
+				"title": fmt.Sprintf("r/%s", post.Subreddit),
+				"body":  post.Title,
+			},
+		},
+	})
+
+	notification := &apns2.Notification{
+		DeviceToken: las.Device.PushToStartToken,
+		Topic:       "com.christianselig.Apollo.push-type.liveactivity",
+		PushType:    "liveactivity",
+		Priority:    apns2.PriorityHigh,
+		Payload:     bb,
+	}
+
+	client := sc.apnsProduction
+	if las.Device.Sandbox {
+		client = sc.apnsSandbox
+	}
+
+	res, err := client.PushWithContext(ctx, notification)
+	if err != nil || !res.Sent() {
+		_ = sc.statsd.Incr("apns.live_activities.errors", []string{"event:start"}, 1)
+
+		fields := []zap.Field{
+			zap.Int64("subreddit#id", subreddit.ID),
+			zap.String("subreddit#name", subreddit.NormalizedName()),
+			zap.Int64("live_activity_subscription#id", las.ID),
+			zap.String("post#id", post.ID),
+		}
+		if err != nil {
+			fields = append(fields, zap.Error(err))
+		} else {
+			fields = append(fields, zap.Int("response#status", res.StatusCode), zap.String("response#reason", res.Reason))
+		}
+		sc.logger.Error("failed to start live activity", fields...)
+
+		_ = sc.liveActivityRepo.DeleteByID(ctx, la.ID)
+		return
+	}
+
+	_ = sc.statsd.Incr("apollo.live_activities.started", []string{}, 1)
+	sc.logger.Info("started live activity",
+		zap.Int64("subreddit#id", subreddit.ID),
+		zap.String("subreddit#name", subreddit.NormalizedName()),
+		zap.Int64("live_activity_subscription#id", las.ID),
+		zap.String("post#id", post.ID),
+	)
+}
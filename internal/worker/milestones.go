@@ -0,0 +1,425 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/DataDog/datadog-go/statsd"
+	"github.com/adjust/rmq/v5"
+	"github.com/dustin/go-humanize"
+	"github.com/go-redis/redis/v8"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/sideshow/apns2"
+	"github.com/sideshow/apns2/payload"
+	"github.com/sideshow/apns2/token"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+
+	"github.com/christianselig/apollo-backend/internal/domain"
+	"github.com/christianselig/apollo-backend/internal/reddit"
+	"github.com/christianselig/apollo-backend/internal/repository"
+)
+
+const (
+	milestoneUpvotesTitleFormat  = "🎉 %s upvotes!"
+	milestoneCommentsTitleFormat = "💬 %s comments!"
+
+	// Nothing changing for this long forgets every milestone, they're only
+	// baselined again after that
+	milestoneNotifiedTTL = 7 * 24 * time.Hour
+
+	// Replies to the account's comments are counted from this many inbox items
+	milestoneInboxLimit = 100
+)
+
+type milestonesWorker struct {
+	context.Context
+
+	logger *zap.Logger
+	tracer trace.Tracer
+	statsd *statsd.Client
+	db     *pgxpool.Pool
+	redis  *redis.Client
+	queue  rmq.Connection
+	reddit *reddit.Client
+	apns   *token.Token
+
+	consumers int
+
+	accountRepo domain.AccountRepository
+	deviceRepo  domain.DeviceRepository
+}
+
+func NewMilestonesWorker(ctx context.Context, logger *zap.Logger, tracer trace.Tracer, statsd *statsd.Client, db *pgxpool.Pool, redis *redis.Client, queue rmq.Connection, consumers int) Worker {
+	reddit := reddit.NewClient(
+		os.Getenv("REDDIT_CLIENT_ID"),
+		os.Getenv("REDDIT_CLIENT_SECRET"),
+		tracer,
+		statsd,
+		redis,
+		consumers,
+	)
+
+	var apns *token.Token
+	{
+		authKey, err := token.AuthKeyFromFile(os.Getenv("APPLE_KEY_PATH"))
+		if err != nil {
+			panic(err)
+		}
+
+		apns = &token.Token{
+			AuthKey: authKey,
+			KeyID:   os.Getenv("APPLE_KEY_ID"),
+			TeamID:  os.Getenv("APPLE_TEAM_ID"),
+		}
+	}
+
+	return &milestonesWorker{
+		ctx,
+		logger,
+		tracer,
+		statsd,
+		db,
+		redis,
+		queue,
+		reddit,
+		apns,
+		consumers,
+
+		repository.NewPostgresAccount(db),
+		repository.NewPostgresDevice(db),
+	}
+}
+
+func (mw *milestonesWorker) Start() error {
+	queue, err := mw.queue.OpenQueue("milestones")
+	if err != nil {
+		return err
+	}
+
+	mw.logger.Info("starting up milestones worker", zap.Int("consumers", mw.consumers))
+
+	prefetchLimit := int64(mw.consumers * 2)
+
+	if err := queue.StartConsuming(prefetchLimit, pollDuration); err != nil {
+		return err
+	}
+
+	host, _ := os.Hostname()
+
+	for i := 0; i < mw.consumers; i++ {
+		name := fmt.Sprintf("consumer %s-%d", host, i)
+
+		consumer := NewMilestonesConsumer(mw, i)
+		if _, err := queue.AddConsumer(name, consumer); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (mw *milestonesWorker) Stop() {
+	<-mw.queue.StopAllConsuming() // wait for all Consume() calls to finish
+}
+
+type milestonesConsumer struct {
+	*milestonesWorker
+	tag int
+
+	apnsSandbox    *apns2.Client
+	apnsProduction *apns2.Client
+}
+
+func NewMilestonesConsumer(mw *milestonesWorker, tag int) *milestonesConsumer {
+	return &milestonesConsumer{
+		mw,
+		tag,
+		apns2.NewTokenClient(mw.apns),
+		apns2.NewTokenClient(mw.apns).Production(),
+	}
+}
+
+// milestoneNotifiedKey holds the highest milestone each device reached on each
+// of the account's posts and comments, so vote fuzzing going back and forth
+// across a threshold never notifies about it twice.
+func milestoneNotifiedKey(accountID int64) string {
+	return fmt.Sprintf("milestones:%d:notified", accountID)
+}
+
+func milestoneNotifiedField(deviceID int64, thing *reddit.Thing, kind string) string {
+	return fmt.Sprintf("%d:%s:%s", deviceID, thing.FullName(), kind)
+}
+
+func (mc *milestonesConsumer) Consume(delivery rmq.Delivery) {
+	ctx, cancel := context.WithCancel(mc)
+	defer cancel()
+
+	now := time.Now()
+	defer func() {
+		elapsed := time.Now().Sub(now).Milliseconds()
+		_ = mc.statsd.Histogram("apollo.consumer.runtime", float64(elapsed), []string{"queue:milestones"}, 0.1)
+	}()
+
+	id, err := strconv.ParseInt(delivery.Payload(), 10, 64)
+	if err != nil {
+		mc.logger.Error("failed to parse account id from payload", zap.Error(err), zap.String("payload", delivery.Payload()))
+		_ = delivery.Reject()
+		return
+	}
+
+	mc.logger.Debug("starting job", zap.Int64("account#id", id))
+
+	defer func() { _ = delivery.Ack() }()
+
+	account, err := mc.accountRepo.GetByID(ctx, id)
+	if err != nil {
+		mc.logger.Error("failed to fetch account from database", zap.Error(err), zap.Int64("account#id", id))
+		return
+	}
+
+	dms, err := mc.deviceRepo.GetMilestoneNotifiableByAccountID(ctx, account.ID)
+	if err != nil {
+		mc.logger.Error("failed to fetch devices from database",
+			zap.Error(err),
+			zap.Int64("account#id", id),
+			zap.String("account#username", account.NormalizedUsername()),
+		)
+		return
+	}
+
+	if len(dms) == 0 {
+		mc.logger.Debug("no devices want milestones, bailing early",
+			zap.Int64("account#id", id),
+			zap.String("account#username", account.NormalizedUsername()),
+		)
+		return
+	}
+
+	rac := mc.reddit.NewAuthenticatedClient(account.AccountID, account.RefreshToken, account.AccessToken)
+
+	things := []*reddit.Thing{}
+
+	posts, err := rac.UserPosts(ctx, account.Username)
+	if err != nil {
+		mc.logger.Error("failed to fetch account posts",
+			zap.Error(err),
+			zap.Int64("account#id", id),
+			zap.String("account#username", account.NormalizedUsername()),
+		)
+		return
+	}
+	things = append(things, posts.Children...)
+
+	comments, err := rac.UserComments(ctx, account.Username)
+	if err != nil {
+		mc.logger.Error("failed to fetch account comments",
+			zap.Error(err),
+			zap.Int64("account#id", id),
+			zap.String("account#username", account.NormalizedUsername()),
+		)
+		return
+	}
+	things = append(things, comments.Children...)
+
+	// Comment listings only have the thread's comment count, so replies to the
+	// account's comments are counted from its inbox. That only goes back so
+	// far, which undercounts the busiest comments.
+	replies := map[string]int64{}
+	if len(comments.Children) > 0 && wantsCommentMilestones(dms) {
+		inbox, err := rac.MessageInbox(ctx, reddit.WithQuery("limit", strconv.Itoa(milestoneInboxLimit)))
+		if err != nil {
+			mc.logger.Error("failed to fetch account inbox, skipping comment replies",
+				zap.Error(err),
+				zap.Int64("account#id", id),
+				zap.String("account#username", account.NormalizedUsername()),
+			)
+		} else {
+			for _, msg := range inbox.Children {
+				if msg.Kind == "t1" {
+					replies[msg.ParentID]++
+				}
+			}
+		}
+	}
+
+	key := milestoneNotifiedKey(account.ID)
+
+	notified, err := mc.redis.HGetAll(ctx, key).Result()
+	if err != nil {
+		mc.logger.Error("failed to fetch notified milestones",
+			zap.Error(err),
+			zap.Int64("account#id", id),
+			zap.String("account#username", account.NormalizedUsername()),
+		)
+		return
+	}
+
+	type milestoneNotification struct {
+		dm    domain.DeviceMilestones
+		thing *reddit.Thing
+		title string
+	}
+
+	current := map[string]bool{}
+	updates := map[string]interface{}{}
+	notifs := []milestoneNotification{}
+
+	// reached says whether there's a new milestone to notify about, and keeps
+	// track of what to store. Things seen for the first time are only
+	// baselined, there's nothing to compare them against yet.
+	reached := func(field string, milestones []int64, count int64) int64 {
+		current[field] = true
+
+		val, ok := notified[field]
+		if !ok {
+			updates[field] = domain.CrossedMilestone(milestones, 0, count)
+			return 0
+		}
+
+		last, _ := strconv.ParseInt(val, 10, 64)
+		m := domain.CrossedMilestone(milestones, last, count)
+		if m > 0 {
+			updates[field] = m
+		}
+		return m
+	}
+
+	for _, thing := range things {
+		numComments := int64(thing.NumComments)
+		if thing.Kind == "t1" {
+			numComments = replies[thing.FullName()]
+		}
+
+		for _, dm := range dms {
+			upvotes := reached(milestoneNotifiedField(dm.Device.ID, thing, "upvotes"), dm.Milestones.Upvotes, thing.Score)
+			comments := reached(milestoneNotifiedField(dm.Device.ID, thing, "comments"), dm.Milestones.Comments, numComments)
+
+			switch {
+			case upvotes > 0:
+				notifs = append(notifs, milestoneNotification{dm, thing, fmt.Sprintf(milestoneUpvotesTitleFormat, humanize.Comma(upvotes))})
+
+				// One notification per thing at a time, the comments one waits for the next check
+				delete(updates, milestoneNotifiedField(dm.Device.ID, thing, "comments"))
+			case comments > 0:
+				notifs = append(notifs, milestoneNotification{dm, thing, fmt.Sprintf(milestoneCommentsTitleFormat, humanize.Comma(comments))})
+			}
+		}
+	}
+
+	stale := []string{}
+	for field := range notified {
+		if !current[field] {
+			stale = append(stale, field)
+		}
+	}
+
+	// Save what was reached first, we'd rather miss a milestone than announce it twice
+	if len(updates) > 0 || len(stale) > 0 {
+		pipe := mc.redis.TxPipeline()
+		if len(stale) > 0 {
+			pipe.HDel(ctx, key, stale...)
+		}
+		if len(updates) > 0 {
+			pipe.HSet(ctx, key, updates)
+		}
+		pipe.Expire(ctx, key, milestoneNotifiedTTL)
+		if _, err := pipe.Exec(ctx); err != nil {
+			mc.logger.Error("failed to store notified milestones",
+				zap.Error(err),
+				zap.Int64("account#id", id),
+				zap.String("account#username", account.NormalizedUsername()),
+			)
+			return
+		}
+	}
+
+	for _, n := range notifs {
+		notification := &apns2.Notification{}
+		notification.Topic = "com.christianselig.Apollo"
+		notification.DeviceToken = n.dm.Device.APNSToken
+		notification.Payload = payloadFromMilestone(n.thing).AlertTitle(n.title)
+
+		client := mc.apnsProduction
+		if n.dm.Device.Sandbox {
+			client = mc.apnsSandbox
+		}
+
+		res, err := client.Push(notification)
+		if err != nil || !res.Sent() {
+			_ = mc.statsd.Incr("apns.notification.errors", []string{}, 1)
+
+			fields := []zap.Field{
+				zap.Error(err),
+				zap.Int64("account#id", id),
+				zap.String("account#username", account.NormalizedUsername()),
+				zap.String("thing#id", n.thing.FullName()),
+				zap.String("device#token", n.dm.Device.APNSToken),
+			}
+			if res != nil {
+				fields = append(fields, zap.Int("response#status", res.StatusCode), zap.String("response#reason", res.Reason))
+			}
+			mc.logger.Error("failed to send notification", fields...)
+		} else {
+			_ = mc.statsd.Incr("apns.notification.sent", []string{}, 1)
+			_ = mc.statsd.Incr("apollo.milestones.sent", []string{}, 1)
+			mc.logger.Info("sent notification",
+				zap.Int64("account#id", id),
+				zap.String("account#username", account.NormalizedUsername()),
+				zap.String("thing#id", n.thing.FullName()),
+				zap.String("device#token", n.dm.Device.APNSToken),
+			)
+		}
+	}
+
+	mc.logger.Debug("finishing job",
+		zap.Int64("account#id", id),
+		zap.String("account#username", account.NormalizedUsername()),
+	)
+}
+
+func wantsCommentMilestones(dms []domain.DeviceMilestones) bool {
+	for _, dm := range dms {
+		if len(dm.Milestones.Comments) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func payloadFromMilestone(thing *reddit.Thing) *payload.Payload {
+	if thing.Kind == "t1" {
+		body := thing.Body
+		if len(body) > 2000 {
+			body = thing.Body[:2000]
+		}
+
+		_, postID := reddit.SplitID(thing.LinkID)
+
+		return payload.
+			NewPayload().
+			AlertBody(body).
+			AlertSubtitle(thing.LinkTitle).
+			Category("milestone-comment").
+			Custom("comment_id", thing.ID).
+			Custom("post_id", postID).
+			Custom("subreddit", thing.Subreddit).
+			Custom("context", thing.Permalink).
+			MutableContent().
+			Sound("traloop.wav")
+	}
+
+	return payload.
+		NewPayload().
+		AlertBody(thing.Title).
+		AlertSubtitle(fmt.Sprintf("r/%s", thing.Subreddit)).
+		Category("milestone-post").
+		Custom("post_id", thing.ID).
+		Custom("post_title", thing.Title).
+		Custom("subreddit", thing.Subreddit).
+		MutableContent().
+		Sound("traloop.wav")
+}
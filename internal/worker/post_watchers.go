@@ -0,0 +1,200 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/DataDog/datadog-go/statsd"
+	"github.com/go-redis/redis/v8"
+	"github.com/sideshow/apns2"
+	"go.uber.org/zap"
+
+	"github.com/christianselig/apollo-backend/internal/domain"
+	"github.com/christianselig/apollo-backend/internal/matcher"
+	"github.com/christianselig/apollo-backend/internal/reddit"
+)
+
+// newPosts returns the posts in a listing that weren't seen yet, marking them
+// as seen. It stops at the first post older than cutoff or with the fullname
+// stop, and says whether it got to one.
+func newPosts(children []*reddit.Thing, seen map[string]bool, cutoff time.Time, stop string) ([]*reddit.Thing, bool) {
+	posts := []*reddit.Thing{}
+	for _, post := range children {
+		if post.FullName() == stop || post.CreatedAt.Before(cutoff) {
+			return posts, true
+		}
+
+		if !seen[post.ID] {
+			posts = append(posts, post)
+			seen[post.ID] = true
+		}
+	}
+	return posts, false
+}
+
+// Trending keys live the longest, so the set tracking them has to as well
+const maxNotifiedTTL = 48 * time.Hour
+
+// markNotified sets a key saying the device heard about a post already, and
+// tracks it under domain.DeviceDedupeKey so it can be cleared along with the
+// rest of them.
+func markNotified(ctx context.Context, rdb *redis.Client, deviceID int64, key string, ttl time.Duration) {
+	now := time.Now()
+	dedupeKey := domain.DeviceDedupeKey(deviceID)
+
+	pipe := rdb.Pipeline()
+	pipe.SetEX(ctx, key, true, ttl)
+	pipe.ZRemRangeByScore(ctx, dedupeKey, "-inf", strconv.FormatInt(now.Unix(), 10))
+	pipe.ZAdd(ctx, dedupeKey, &redis.Z{Score: float64(now.Add(ttl).Unix()), Member: key})
+	pipe.Expire(ctx, dedupeKey, maxNotifiedTTL)
+	_, _ = pipe.Exec(ctx)
+}
+
+// postWatcherNotifier checks posts against the watchers of a listing, and
+// notifies the devices of the ones they match. The subreddits and feeds
+// workers share it.
+type postWatcherNotifier struct {
+	logger      *zap.Logger
+	statsd      *statsd.Client
+	redis       *redis.Client
+	watcherRepo domain.WatcherRepository
+	limiter     *watcherLimiter
+
+	apnsSandbox    *apns2.Client
+	apnsProduction *apns2.Client
+}
+
+// notify goes through every post and watcher. The fields say where the posts
+// came from in logs. It only fails when it can't count a hit, and stops there.
+func (pn *postWatcherNotifier) notify(ctx context.Context, posts []*reddit.Thing, watchers []domain.Watcher, fields ...zap.Field) error {
+	log := func(fs ...zap.Field) []zap.Field {
+		return append(fs, fields...)
+	}
+
+	for _, post := range posts {
+		notifs := []domain.Watcher{}
+
+		for _, watcher := range watchers {
+			// Make sure we only alert on posts created after the search
+			if watcher.CreatedAt.After(post.CreatedAt) {
+				continue
+			}
+
+			// Nor on anything posted while it was snoozed
+			if watcher.SnoozedUntil.After(post.CreatedAt) {
+				continue
+			}
+
+			if !matcher.Match(&watcher, post).Matched {
+				continue
+			}
+
+			pn.logger.Debug("matched post", log(
+				zap.Int64("watcher#id", watcher.ID),
+				zap.String("watcher#keywords", watcher.Keyword),
+				zap.Int64("watcher#upvotes", watcher.Upvotes),
+				zap.String("post#id", post.ID),
+				zap.String("post#title", post.Title),
+				zap.Int64("post#score", post.Score),
+			)...)
+
+			lockKey := fmt.Sprintf("watcher:%d:%s", watcher.DeviceID, post.ID)
+			notified, _ := pn.redis.Get(ctx, lockKey).Bool()
+
+			if notified {
+				pn.logger.Debug("already notified, skipping", log(
+					zap.Int64("watcher#id", watcher.ID),
+					zap.String("post#id", post.ID),
+				)...)
+				continue
+			}
+
+			if !pn.limiter.Allow(ctx, watcher) {
+				pn.logger.Debug("watcher rate limited, suppressing", log(
+					zap.Int64("watcher#id", watcher.ID),
+					zap.String("post#id", post.ID),
+				)...)
+				markNotified(ctx, pn.redis, watcher.DeviceID, lockKey, 24*time.Hour)
+				recordWatcherHit(ctx, pn.logger, pn.watcherRepo, watcher, post, domain.WatcherHitSuppressed)
+				continue
+			}
+
+			if err := pn.watcherRepo.IncrementHits(ctx, watcher.ID); err != nil {
+				pn.logger.Error("could not increment hits", log(
+					zap.Error(err),
+					zap.Int64("watcher#id", watcher.ID),
+				)...)
+				return err
+			}
+			pn.logger.Debug("got a hit", log(
+				zap.Int64("watcher#id", watcher.ID),
+				zap.String("post#id", post.ID),
+			)...)
+
+			markNotified(ctx, pn.redis, watcher.DeviceID, lockKey, 24*time.Hour)
+			notifs = append(notifs, watcher)
+		}
+
+		if len(notifs) == 0 {
+			continue
+		}
+		pn.logger.Debug("got hits for post", log(
+			zap.String("post#id", post.ID),
+			zap.Int("count", len(notifs)),
+		)...)
+
+		payload := payloadFromPost(post)
+
+		for _, watcher := range notifs {
+			title := fmt.Sprintf(subredditNotificationTitleFormat, watcher.Label)
+			payload.AlertTitle(title)
+
+			body := fmt.Sprintf(subredditNotificationBodyFormat, post.Subreddit, post.Title)
+			payload.AlertBody(body)
+
+			notification := &apns2.Notification{}
+			notification.Topic = "com.christianselig.Apollo"
+			notification.DeviceToken = watcher.Device.APNSToken
+			notification.Payload = payload
+
+			client := pn.apnsProduction
+			if watcher.Device.Sandbox {
+				client = pn.apnsSandbox
+			}
+
+			res, err := client.Push(notification)
+			if err != nil {
+				_ = pn.statsd.Incr("apns.notification.errors", []string{}, 1)
+				pn.logger.Error("failed to send notification", log(
+					zap.Error(err),
+					zap.String("post#id", post.ID),
+					zap.String("apns", watcher.Device.APNSToken),
+				)...)
+			} else if !res.Sent() {
+				_ = pn.statsd.Incr("apns.notification.errors", []string{}, 1)
+				pn.logger.Error("notification not sent", log(
+					zap.String("post#id", post.ID),
+					zap.String("apns", watcher.Device.APNSToken),
+					zap.Int("response#status", res.StatusCode),
+					zap.String("response#reason", res.Reason),
+				)...)
+			} else {
+				_ = pn.statsd.Incr("apns.notification.sent", []string{}, 1)
+				pn.logger.Info("sent notification", log(
+					zap.String("post#id", post.ID),
+					zap.String("device#token", watcher.Device.APNSToken),
+				)...)
+			}
+
+			status := domain.WatcherHitDelivered
+			if err != nil || !res.Sent() {
+				status = domain.WatcherHitFailed
+			}
+			recordWatcherHit(ctx, pn.logger, pn.watcherRepo, watcher, post, status)
+		}
+	}
+
+	return nil
+}
@@ -20,8 +20,10 @@ import (
 	"go.uber.org/zap"
 
 	"github.com/christianselig/apollo-backend/internal/domain"
+	"github.com/christianselig/apollo-backend/internal/matcher"
 	"github.com/christianselig/apollo-backend/internal/reddit"
 	"github.com/christianselig/apollo-backend/internal/repository"
+	"github.com/christianselig/apollo-backend/internal/tokencrypt"
 )
 
 type subredditsWorker struct {
@@ -35,6 +37,7 @@ type subredditsWorker struct {
 	queue  rmq.Connection
 	reddit *reddit.Client
 	apns   *token.Token
+	tokens *tokencrypt.Sealer
 
 	consumers int
 
@@ -42,6 +45,11 @@ type subredditsWorker struct {
 	deviceRepo    domain.DeviceRepository
 	subredditRepo domain.SubredditRepository
 	watcherRepo   domain.WatcherRepository
+
+	liveActivityRepo             domain.LiveActivityRepository
+	liveActivitySubscriptionRepo domain.LiveActivitySubscriptionRepository
+
+	limiter *watcherLimiter
 }
 
 const (
@@ -73,6 +81,12 @@ func NewSubredditsWorker(ctx context.Context, logger *zap.Logger, tracer trace.T
 		}
 	}
 
+	// Live activities we start get stored with encrypted tokens
+	tokens, err := tokencrypt.NewFromBase64(os.Getenv("LIVE_ACTIVITY_TOKEN_KEY"))
+	if err != nil {
+		panic(err)
+	}
+
 	return &subredditsWorker{
 		ctx,
 		logger,
@@ -83,12 +97,18 @@ func NewSubredditsWorker(ctx context.Context, logger *zap.Logger, tracer trace.T
 		queue,
 		reddit,
 		apns,
+		tokens,
 		consumers,
 
 		repository.NewPostgresAccount(db),
 		repository.NewPostgresDevice(db),
 		repository.NewPostgresSubreddit(db),
 		repository.NewPostgresWatcher(db),
+
+		repository.NewPostgresLiveActivity(db),
+		repository.NewPostgresLiveActivitySubscription(db),
+
+		newWatcherLimiter(logger, statsd, redis),
 	}
 }
 
@@ -130,14 +150,20 @@ type subredditsConsumer struct {
 
 	apnsSandbox    *apns2.Client
 	apnsProduction *apns2.Client
+
+	notifier *postWatcherNotifier
 }
 
 func NewSubredditsConsumer(sw *subredditsWorker, tag int) *subredditsConsumer {
+	apnsSandbox := apns2.NewTokenClient(sw.apns)
+	apnsProduction := apns2.NewTokenClient(sw.apns).Production()
+
 	return &subredditsConsumer{
 		sw,
 		tag,
-		apns2.NewTokenClient(sw.apns),
-		apns2.NewTokenClient(sw.apns).Production(),
+		apnsSandbox,
+		apnsProduction,
+		&postWatcherNotifier{sw.logger, sw.statsd, sw.redis, sw.watcherRepo, sw.limiter, apnsSandbox, apnsProduction},
 	}
 }
 
@@ -172,26 +198,63 @@ func (sc *subredditsConsumer) Consume(delivery rmq.Delivery) {
 		return
 	}
 
-	if len(watchers) == 0 {
-		sc.logger.Debug("no watchers for subreddit, bailing early",
+	subs, err := sc.liveActivitySubscriptionRepo.GetBySubredditID(ctx, subreddit.ID)
+	if err != nil {
+		sc.logger.Error("failed to fetch live activity subscriptions from database",
+			zap.Error(err),
 			zap.Int64("subreddit#id", id),
 			zap.String("subreddit#name", subreddit.NormalizedName()),
 		)
 		return
 	}
 
+	if len(watchers) == 0 && len(subs) == 0 {
+		sc.logger.Debug("no watchers or live activity subscriptions for subreddit, bailing early",
+			zap.Int64("subreddit#id", id),
+			zap.String("subreddit#name", subreddit.NormalizedName()),
+		)
+		return
+	}
+
+	sc.limiter.Summarize(ctx, watchers, sc.apnsProduction, sc.apnsSandbox)
+
 	threshold := time.Now().Add(-24 * time.Hour)
 	posts := []*reddit.Thing{}
-	before := ""
-	finished := false
 	seenPosts := map[string]bool{}
+	requests := 0
+
+	// Once we know the newest post we've processed, only what's been posted since needs fetching
+	mode := "full"
+	if subreddit.HasHighWaterMark(threshold) {
+		mode = "incremental"
+
+		// Reddit returns nothing before a post that got removed, which looks just like a quiet
+		// subreddit. Every so often we do a full check instead, stopping at the mark's timestamp.
+		if time.Since(subreddit.LastPostAt) > domain.SubredditHighWaterMarkRecheck {
+			key := fmt.Sprintf("subreddit:%d:mark-checked", subreddit.ID)
+			if ok, _ := sc.redis.SetNX(ctx, key, true, domain.SubredditHighWaterMarkRecheck).Result(); ok {
+				mode = "recheck"
+			}
+		}
+	}
+
+	cutoff := threshold
+	if subreddit.LastPostAt.After(cutoff) {
+		cutoff = subreddit.LastPostAt
+	}
 
-	// Load 500 newest posts
 	sc.logger.Debug("loading up to 500 new posts",
 		zap.Int64("subreddit#id", id),
 		zap.String("subreddit#name", subreddit.NormalizedName()),
+		zap.String("mode", mode),
 	)
 
+	before := ""
+	if mode == "incremental" {
+		before = subreddit.LastPostID
+	}
+	after := ""
+
 	for page := 0; page < 5; page++ {
 		sc.logger.Debug("loading new posts",
 			zap.Int64("subreddit#id", id),
@@ -199,13 +262,14 @@ func (sc *subredditsConsumer) Consume(delivery rmq.Delivery) {
 			zap.Int("page", page),
 		)
 
-		i := rand.Intn(len(watchers))
-		watcher := watchers[i]
+		account, revoke := sc.pollingAccount(ctx, subreddit, watchers, subs)
 
-		rac := sc.reddit.NewAuthenticatedClient(watcher.Account.AccountID, watcher.Account.RefreshToken, watcher.Account.AccessToken)
+		rac := sc.reddit.NewAuthenticatedClient(account.AccountID, account.RefreshToken, account.AccessToken)
+		requests++
 		sps, err := rac.SubredditNew(ctx,
 			subreddit.Name,
 			reddit.WithQuery("before", before),
+			reddit.WithQuery("after", after),
 			reddit.WithQuery("limit", "100"),
 			reddit.WithQuery("show", "all"),
 			reddit.WithQuery("always_show_media", "1"),
@@ -221,18 +285,17 @@ func (sc *subredditsConsumer) Consume(delivery rmq.Delivery) {
 
 			switch err {
 			case reddit.ErrOauthRevoked:
-				sc.logger.Info("deleting watcher",
-					zap.Int64("subreddit#id", id),
-					zap.String("subreddit#name", subreddit.NormalizedName()),
-					zap.Int64("watcher#id", watcher.ID),
-				)
-				_ = sc.watcherRepo.Delete(ctx, watcher.ID)
+				revoke()
 			case reddit.ErrSubredditNotFound:
 				sc.logger.Info("subreddit deleted, deleting watchers",
 					zap.Int64("subreddit#id", id),
 					zap.String("subreddit#name", subreddit.NormalizedName()),
 				)
 				for _, watcher := range watchers {
+					// Multi-subreddit watchers still have other subreddits to look at
+					if watcher.Type == domain.MultiSubredditWatcher {
+						continue
+					}
 					_ = sc.watcherRepo.Delete(ctx, watcher.ID)
 				}
 			}
@@ -252,43 +315,67 @@ func (sc *subredditsConsumer) Consume(delivery rmq.Delivery) {
 			break
 		}
 
+		pps, finished := newPosts(sps.Children, seenPosts, cutoff, subreddit.LastPostID)
+		posts = append(posts, pps...)
+
 		// If we don't have 100 posts, we're going to be done
-		if sps.Count < 100 {
-			finished = true
+		if finished || sps.Count < 100 {
+			sc.logger.Debug("reached high-water mark or date threshold",
+				zap.Int64("subreddit#id", id),
+				zap.String("subreddit#name", subreddit.NormalizedName()),
+				zap.Int("page", page),
+			)
+			break
 		}
 
-		for _, post := range sps.Children {
-			if post.CreatedAt.Before(threshold) {
-				finished = true
-				break
-			}
+		// Listings are newest first, so walking forwards means going before the newest
+		// post we got, and walking backwards means going after the oldest one.
+		if mode == "incremental" {
+			before = sps.Children[0].FullName()
+		} else {
+			after = sps.Children[len(sps.Children)-1].FullName()
+		}
+	}
 
-			if _, ok := seenPosts[post.ID]; !ok {
-				posts = append(posts, post)
-				seenPosts[post.ID] = true
-			}
+	mark := subreddit.LastPostID
+	for _, post := range posts {
+		if post.CreatedAt.After(subreddit.LastPostAt) {
+			subreddit.LastPostID = post.FullName()
+			subreddit.LastPostAt = post.CreatedAt
 		}
+	}
 
-		if finished {
-			sc.logger.Debug("reached date threshold",
+	if subreddit.LastPostID != mark {
+		if err := sc.subredditRepo.UpdateHighWaterMark(ctx, &subreddit); err != nil {
+			sc.logger.Error("failed to update high-water mark",
+				zap.Error(err),
 				zap.Int64("subreddit#id", id),
 				zap.String("subreddit#name", subreddit.NormalizedName()),
-				zap.Int("page", page),
 			)
-			break
 		}
 	}
 
-	// Load hot posts
-	sc.logger.Debug("loading hot posts",
-		zap.Int64("subreddit#id", id),
-		zap.String("subreddit#name", subreddit.NormalizedName()),
-	)
-	{
+	// New posts are only evaluated once, so posts that could still climb past an
+	// upvote or comment threshold are caught by rechecking hot, and the ones that
+	// were short of it by fetching them again.
+	var wantsHot bool
+	for _, watcher := range watchers {
+		wantsHot = wantsHot || watcher.Upvotes > 0 || watcher.MinComments > 0
+	}
+
+	if !wantsHot {
+		_ = sc.statsd.Incr("apollo.subreddits.hot.skipped", nil, 0.1)
+	} else {
+		sc.logger.Debug("loading hot posts",
+			zap.Int64("subreddit#id", id),
+			zap.String("subreddit#name", subreddit.NormalizedName()),
+		)
+
 		i := rand.Intn(len(watchers))
 		watcher := watchers[i]
 
 		rac := sc.reddit.NewAuthenticatedClient(watcher.Account.AccountID, watcher.Account.RefreshToken, watcher.Account.AccessToken)
+		requests++
 		sps, err := rac.SubredditHot(ctx,
 			subreddit.Name,
 			reddit.WithQuery("limit", "100"),
@@ -318,168 +405,177 @@ func (sc *subredditsConsumer) Consume(delivery rmq.Delivery) {
 				zap.Int("count", sps.Count),
 			)
 
-			for _, post := range sps.Children {
-				if post.CreatedAt.Before(threshold) {
-					break
-				}
-				if _, ok := seenPosts[post.ID]; !ok {
-					posts = append(posts, post)
-					seenPosts[post.ID] = true
-				}
-			}
+			pps, _ := newPosts(sps.Children, seenPosts, threshold, "")
+			posts = append(posts, pps...)
 		}
+
+		pps, reqs := sc.recheckPendingPosts(ctx, subreddit, watchers, seenPosts, threshold)
+		posts = append(posts, pps...)
+		requests += reqs
 	}
 
+	tags := []string{fmt.Sprintf("mode:%s", mode)}
+	_ = sc.statsd.Histogram("apollo.subreddits.requests", float64(requests), tags, 0.1)
+	_ = sc.statsd.Histogram("apollo.subreddits.posts", float64(len(posts)), tags, 0.1)
+
 	sc.logger.Debug("checking posts for watcher hits",
 		zap.Int64("subreddit#id", id),
 		zap.String("subreddit#name", subreddit.NormalizedName()),
 		zap.Int("count", len(posts)),
 	)
-	for _, post := range posts {
-		lowcaseAuthor := strings.ToLower(post.Author)
-		lowcaseTitle := strings.ToLower(post.Title)
-		lowcaseFlair := strings.ToLower(post.Flair)
-		lowcaseDomain := strings.ToLower(post.URL)
+	if err := sc.notifier.notify(ctx, posts, watchers,
+		zap.Int64("subreddit#id", id),
+		zap.String("subreddit#name", subreddit.NormalizedName()),
+	); err != nil {
+		return
+	}
 
-		notifs := []domain.Watcher{}
+	if wantsHot {
+		sc.trackPendingPosts(ctx, subreddit, watchers, posts)
+	}
 
-		for _, watcher := range watchers {
-			// Make sure we only alert on posts created after the search
-			if watcher.CreatedAt.After(post.CreatedAt) {
-				continue
-			}
+	if len(subs) > 0 {
+		sc.startLiveActivities(ctx, subreddit, subs, posts)
+	}
 
-			matched := watcher.KeywordMatches(lowcaseTitle)
+	sc.logger.Debug("finishing job",
+		zap.Int64("subreddit#id", id),
+		zap.String("subreddit#name", subreddit.NormalizedName()),
+	)
+}
 
-			if watcher.Author != "" && lowcaseAuthor != watcher.Author {
-				matched = false
-			}
+func subredditPendingKey(id int64) string {
+	return fmt.Sprintf("subreddit:%d:pending", id)
+}
 
-			if watcher.Upvotes > 0 && post.Score < watcher.Upvotes {
-				matched = false
-			}
+// recheckPendingPosts fetches the posts that were short of an upvote or comment
+// threshold again. They can pass it long after leaving new, without ever making
+// it to hot.
+func (sc *subredditsConsumer) recheckPendingPosts(ctx context.Context, subreddit domain.Subreddit, watchers []domain.Watcher, seen map[string]bool, threshold time.Time) ([]*reddit.Thing, int) {
+	key := subredditPendingKey(subreddit.ID)
+	_ = sc.redis.ZRemRangeByScore(ctx, key, "-inf", strconv.FormatInt(threshold.Unix(), 10)).Err()
 
-			if watcher.Flair != "" && !strings.Contains(lowcaseFlair, watcher.Flair) {
-				matched = false
-			}
+	ids, err := sc.redis.ZRevRange(ctx, key, 0, domain.SubredditPendingPostsLimit-1).Result()
+	if err != nil {
+		sc.logger.Error("failed to fetch pending posts",
+			zap.Error(err),
+			zap.Int64("subreddit#id", subreddit.ID),
+			zap.String("subreddit#name", subreddit.NormalizedName()),
+		)
+		return nil, 0
+	}
 
-			if watcher.Domain != "" && !strings.Contains(lowcaseDomain, watcher.Domain) {
-				matched = false
-			}
+	posts := []*reddit.Thing{}
+	requests := 0
 
-			if !matched {
-				continue
-			}
+	for len(ids) > 0 {
+		batch := ids
+		if len(batch) > 100 {
+			batch = ids[:100]
+		}
+		ids = ids[len(batch):]
 
-			sc.logger.Debug("matched post",
-				zap.Int64("subreddit#id", id),
+		watcher := watchers[rand.Intn(len(watchers))]
+		rac := sc.reddit.NewAuthenticatedClient(watcher.Account.AccountID, watcher.Account.RefreshToken, watcher.Account.AccessToken)
+		requests++
+		sps, err := rac.AboutInfo(ctx, strings.Join(batch, ","))
+		if err != nil {
+			sc.logger.Error("failed to fetch pending posts",
+				zap.Error(err),
+				zap.Int64("subreddit#id", subreddit.ID),
 				zap.String("subreddit#name", subreddit.NormalizedName()),
-				zap.Int64("watcher#id", watcher.ID),
-				zap.String("watcher#keywords", watcher.Keyword),
-				zap.Int64("watcher#upvotes", watcher.Upvotes),
-				zap.String("post#id", post.ID),
-				zap.String("post#title", post.Title),
-				zap.Int64("post#score", post.Score),
 			)
+			break
+		}
 
-			lockKey := fmt.Sprintf("watcher:%d:%s", watcher.DeviceID, post.ID)
-			notified, _ := sc.redis.Get(ctx, lockKey).Bool()
+		pps, _ := newPosts(sps.Children, seen, threshold, "")
+		posts = append(posts, pps...)
+	}
 
-			if notified {
-				sc.logger.Debug("already notified, skipping",
-					zap.Int64("subreddit#id", id),
-					zap.String("subreddit#name", subreddit.NormalizedName()),
-					zap.Int64("watcher#id", watcher.ID),
-					zap.String("post#id", post.ID),
-				)
-				continue
-			}
+	sc.logger.Debug("rechecked pending posts",
+		zap.Int64("subreddit#id", subreddit.ID),
+		zap.String("subreddit#name", subreddit.NormalizedName()),
+		zap.Int("count", len(posts)),
+	)
 
-			if err := sc.watcherRepo.IncrementHits(ctx, watcher.ID); err != nil {
-				sc.logger.Error("could not increment hits",
-					zap.Error(err),
-					zap.Int64("subreddit#id", id),
-					zap.String("subreddit#name", subreddit.NormalizedName()),
-					zap.Int64("watcher#id", watcher.ID),
-				)
-				return
-			}
-			sc.logger.Debug("got a hit",
-				zap.Int64("subreddit#id", id),
-				zap.String("subreddit#name", subreddit.NormalizedName()),
-				zap.Int64("watcher#id", watcher.ID),
-				zap.String("post#id", post.ID),
-			)
+	return posts, requests
+}
 
-			sc.redis.SetEX(ctx, lockKey, true, 24*time.Hour)
-			notifs = append(notifs, watcher)
-		}
+// trackPendingPosts remembers the posts a watcher only skipped for being short
+// of its upvote or comment threshold, and forgets the rest.
+func (sc *subredditsConsumer) trackPendingPosts(ctx context.Context, subreddit domain.Subreddit, watchers []domain.Watcher, posts []*reddit.Thing) {
+	pending := []*redis.Z{}
+	done := []interface{}{}
 
-		if len(notifs) == 0 {
-			continue
+	for _, post := range posts {
+		if stillClimbing(watchers, post) {
+			pending = append(pending, &redis.Z{Score: float64(post.CreatedAt.Unix()), Member: post.FullName()})
+		} else {
+			done = append(done, post.FullName())
 		}
-		sc.logger.Debug("got hits for post",
-			zap.Int64("subreddit#id", id),
+	}
+
+	key := subredditPendingKey(subreddit.ID)
+	pipe := sc.redis.Pipeline()
+	if len(pending) > 0 {
+		pipe.ZAdd(ctx, key, pending...)
+		pipe.Expire(ctx, key, 24*time.Hour)
+	}
+	if len(done) > 0 {
+		pipe.ZRem(ctx, key, done...)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		sc.logger.Error("failed to track pending posts",
+			zap.Error(err),
+			zap.Int64("subreddit#id", subreddit.ID),
 			zap.String("subreddit#name", subreddit.NormalizedName()),
-			zap.String("post#id", post.ID),
-			zap.Int("count", len(notifs)),
 		)
+	}
+}
 
-		payload := payloadFromPost(post)
-
-		for _, watcher := range notifs {
-			title := fmt.Sprintf(subredditNotificationTitleFormat, watcher.Label)
-			payload.AlertTitle(title)
-
-			body := fmt.Sprintf(subredditNotificationBodyFormat, subreddit.Name, post.Title)
-			payload.AlertBody(body)
-
-			notification := &apns2.Notification{}
-			notification.Topic = "com.christianselig.Apollo"
-			notification.DeviceToken = watcher.Device.APNSToken
-			notification.Payload = payload
+func stillClimbing(watchers []domain.Watcher, post *reddit.Thing) bool {
+	for _, watcher := range watchers {
+		if watcher.Upvotes == 0 && watcher.MinComments == 0 {
+			continue
+		}
+		if watcher.CreatedAt.After(post.CreatedAt) || watcher.SnoozedUntil.After(post.CreatedAt) {
+			continue
+		}
 
-			client := sc.apnsProduction
-			if watcher.Device.Sandbox {
-				client = sc.apnsSandbox
-			}
+		res := matcher.Match(&watcher, post)
+		if res.MissedOnly("upvotes", "comments") {
+			return true
+		}
+	}
+	return false
+}
 
-			res, err := client.Push(notification)
-			if err != nil {
-				_ = sc.statsd.Incr("apns.notification.errors", []string{}, 1)
-				sc.logger.Error("failed to send notification",
-					zap.Error(err),
-					zap.Int64("subreddit#id", id),
-					zap.String("subreddit#name", subreddit.NormalizedName()),
-					zap.String("post#id", post.ID),
-					zap.String("apns", watcher.Device.APNSToken),
-				)
-			} else if !res.Sent() {
-				_ = sc.statsd.Incr("apns.notification.errors", []string{}, 1)
-				sc.logger.Error("notification not sent",
-					zap.Int64("subreddit#id", id),
-					zap.String("subreddit#name", subreddit.NormalizedName()),
-					zap.String("post#id", post.ID),
-					zap.String("apns", watcher.Device.APNSToken),
-					zap.Int("response#status", res.StatusCode),
-					zap.String("response#reason", res.Reason),
-				)
-			} else {
-				_ = sc.statsd.Incr("apns.notification.sent", []string{}, 1)
-				sc.logger.Info("sent notification",
-					zap.Int64("subreddit#id", id),
-					zap.String("subreddit#name", subreddit.NormalizedName()),
-					zap.String("post#id", post.ID),
-					zap.String("device#token", watcher.Device.APNSToken),
-				)
-			}
+// pollingAccount picks who to fetch the subreddit as, spreading requests over
+// everyone watching it or following threads in it. The returned func gets rid
+// of whatever the account came from, for when reddit says it's been revoked.
+func (sc *subredditsConsumer) pollingAccount(ctx context.Context, subreddit domain.Subreddit, watchers []domain.Watcher, subs []domain.LiveActivitySubscription) (domain.Account, func()) {
+	i := rand.Intn(len(watchers) + len(subs))
+	if i < len(watchers) {
+		watcher := watchers[i]
+		return watcher.Account, func() {
+			sc.logger.Info("deleting watcher",
+				zap.Int64("subreddit#id", subreddit.ID),
+				zap.String("subreddit#name", subreddit.NormalizedName()),
+				zap.Int64("watcher#id", watcher.ID),
+			)
+			_ = sc.watcherRepo.Delete(ctx, watcher.ID)
 		}
 	}
 
-	sc.logger.Debug("finishing job",
-		zap.Int64("subreddit#id", id),
-		zap.String("subreddit#name", subreddit.NormalizedName()),
-	)
+	las := subs[i-len(watchers)]
+	return las.Account, func() {
+		sc.logger.Info("deleting live activity subscription",
+			zap.Int64("subreddit#id", subreddit.ID),
+			zap.String("subreddit#name", subreddit.NormalizedName()),
+			zap.Int64("live_activity_subscription#id", las.ID),
+		)
+		_ = sc.liveActivitySubscriptionRepo.Delete(ctx, las.ID)
+	}
 }
 
 func payloadFromPost(post *reddit.Thing) *payload.Payload {
@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"math/rand"
 	"os"
-	"sort"
 	"strconv"
 	"time"
 
@@ -22,6 +21,7 @@ import (
 	"github.com/christianselig/apollo-backend/internal/domain"
 	"github.com/christianselig/apollo-backend/internal/reddit"
 	"github.com/christianselig/apollo-backend/internal/repository"
+	"github.com/christianselig/apollo-backend/internal/trending"
 )
 
 type trendingWorker struct {
@@ -41,6 +41,9 @@ type trendingWorker struct {
 	deviceRepo    domain.DeviceRepository
 	subredditRepo domain.SubredditRepository
 	watcherRepo   domain.WatcherRepository
+
+	limiter       *watcherLimiter
+	trendingStore *trending.Store
 }
 
 const trendingNotificationTitleFormat = "🔥 r/%s Trending"
@@ -84,6 +87,9 @@ func NewTrendingWorker(ctx context.Context, logger *zap.Logger, tracer trace.Tra
 		repository.NewPostgresDevice(db),
 		repository.NewPostgresSubreddit(db),
 		repository.NewPostgresWatcher(db),
+
+		newWatcherLimiter(logger, statsd, redis),
+		trending.NewStore(redis),
 	}
 }
 
@@ -175,79 +181,73 @@ func (tc *trendingConsumer) Consume(delivery rmq.Delivery) {
 		return
 	}
 
-	// Grab last month's top posts so we calculate a trending average
+	tc.limiter.Summarize(ctx, watchers, tc.apnsProduction, tc.apnsSandbox)
+
+	// Grab hot posts and filter out anything that's > 2 days old
 	i := rand.Intn(len(watchers))
 	watcher := watchers[i]
 	rac := tc.reddit.NewAuthenticatedClient(watcher.Account.AccountID, watcher.Account.RefreshToken, watcher.Account.AccessToken)
 
-	tps, err := rac.SubredditTop(ctx, subreddit.Name, reddit.WithQuery("t", "week"), reddit.WithQuery("show", "all"), reddit.WithQuery("limit", "25"))
+	hps, err := rac.SubredditHot(ctx, subreddit.Name, reddit.WithQuery("show", "all"), reddit.WithQuery("always_show_media", "1"), reddit.WithQuery("limit", "100"))
 	if err != nil {
-		tc.logger.Error("failed to fetch weeks's top posts",
+		tc.logger.Error("failed to fetch hot posts",
 			zap.Error(err),
 			zap.Int64("subreddit#id", id),
 			zap.String("subreddit#name", subreddit.NormalizedName()),
 		)
 		return
 	}
-
-	tc.logger.Debug("loaded weeks's top posts",
+	tc.logger.Debug("loaded hot posts",
 		zap.Int64("subreddit#id", id),
 		zap.String("subreddit#name", subreddit.NormalizedName()),
-		zap.Int("count", tps.Count),
+		zap.Int("count", hps.Count),
 	)
 
-	if tps.Count < 20 {
-		tc.logger.Debug("no top posts, bailing early",
+	// Trending only counts for posts less than 2 days old
+	now := time.Now()
+	threshold := now.Add(-24 * time.Hour * 2)
+
+	posts := []*reddit.Thing{}
+	for _, post := range hps.Children {
+		if post.CreatedAt.Before(threshold) {
+			continue
+		}
+		posts = append(posts, post)
+	}
+
+	if len(posts) < trending.MinimumSample {
+		tc.logger.Debug("not enough recent posts, bailing early",
 			zap.Int64("subreddit#id", id),
 			zap.String("subreddit#name", subreddit.NormalizedName()),
-			zap.Int("count", tps.Count),
+			zap.Int("count", len(posts)),
 		)
 		return
 	}
 
-	sort.SliceStable(tps.Children, func(i, j int) bool {
-		return tps.Children[i].Score > tps.Children[j].Score
-	})
-
-	middlePost := tps.Count / 2
-	medianScore := tps.Children[middlePost].Score
-	tc.logger.Debug("calculated median score",
-		zap.Int64("subreddit#id", id),
-		zap.String("subreddit#name", subreddit.NormalizedName()),
-		zap.Int64("score", medianScore),
-	)
-
-	// Grab hot posts and filter out anything that's > 2 days old
-	i = rand.Intn(len(watchers))
-	watcher = watchers[i]
-	rac = tc.reddit.NewAuthenticatedClient(watcher.Account.AccountID, watcher.Account.RefreshToken, watcher.Account.AccessToken)
-
-	hps, err := rac.SubredditHot(ctx, subreddit.Name, reddit.WithQuery("show", "all"), reddit.WithQuery("always_show_media", "1"))
+	histories, err := tc.trendingStore.Record(ctx, posts, now)
 	if err != nil {
-		tc.logger.Error("failed to fetch hot posts",
+		tc.logger.Error("failed to record post snapshots",
 			zap.Error(err),
 			zap.Int64("subreddit#id", id),
 			zap.String("subreddit#name", subreddit.NormalizedName()),
 		)
 		return
 	}
-	tc.logger.Debug("loaded hot posts",
+
+	trends := make([]trending.Trend, len(posts))
+	for i, post := range posts {
+		trends[i] = trending.Velocity(post.CreatedAt, histories[post.ID])
+	}
+
+	baseline := trending.Baseline(trends)
+	tc.logger.Debug("calculated baseline velocity",
 		zap.Int64("subreddit#id", id),
 		zap.String("subreddit#name", subreddit.NormalizedName()),
-		zap.Int("count", hps.Count),
+		zap.Float64("baseline", baseline),
 	)
 
-	// Trending only counts for posts less than 2 days old
-	threshold := time.Now().Add(-24 * time.Hour * 2)
-
-	for _, post := range hps.Children {
-		if post.Score < medianScore {
-			continue
-		}
-
-		if post.CreatedAt.Before(threshold) {
-			break
-		}
+	for i, post := range posts {
+		trend := trends[i]
 
 		notification := &apns2.Notification{}
 		notification.Topic = "com.christianselig.Apollo"
@@ -258,6 +258,10 @@ func (tc *trendingConsumer) Consume(delivery rmq.Delivery) {
 				continue
 			}
 
+			if !trending.IsTrending(trend, baseline, watcher.TrendingSensitivity) {
+				continue
+			}
+
 			lockKey := fmt.Sprintf("watcher:trending:%d:%s", watcher.DeviceID, post.ID)
 			notified, _ := tc.redis.Get(ctx, lockKey).Bool()
 
@@ -271,7 +275,18 @@ func (tc *trendingConsumer) Consume(delivery rmq.Delivery) {
 				continue
 			}
 
-			tc.redis.SetEX(ctx, lockKey, true, 48*time.Hour)
+			markNotified(ctx, tc.redis, watcher.DeviceID, lockKey, 48*time.Hour)
+
+			if !tc.limiter.Allow(ctx, watcher) {
+				tc.logger.Debug("watcher rate limited, suppressing",
+					zap.Int64("subreddit#id", id),
+					zap.String("subreddit#name", subreddit.NormalizedName()),
+					zap.Int64("watcher#id", watcher.ID),
+					zap.String("post#id", post.ID),
+				)
+				recordWatcherHit(ctx, tc.logger, tc.watcherRepo, watcher, post, domain.WatcherHitSuppressed)
+				continue
+			}
 
 			if err := tc.watcherRepo.IncrementHits(ctx, watcher.ID); err != nil {
 				tc.logger.Error("could not increment hits",
@@ -299,7 +314,8 @@ func (tc *trendingConsumer) Consume(delivery rmq.Delivery) {
 					zap.String("subreddit#name", subreddit.NormalizedName()),
 					zap.String("post#id", post.ID),
 					zap.String("apns", watcher.Device.APNSToken),
-					zap.Int64("median_score", medianScore),
+					zap.Float64("velocity", trend.ScoreVelocity),
+					zap.Float64("baseline", baseline),
 				)
 			} else if !res.Sent() {
 				_ = tc.statsd.Incr("apns.notification.errors", []string{}, 1)
@@ -308,7 +324,8 @@ func (tc *trendingConsumer) Consume(delivery rmq.Delivery) {
 					zap.String("subreddit#name", subreddit.NormalizedName()),
 					zap.String("post#id", post.ID),
 					zap.String("apns", watcher.Device.APNSToken),
-					zap.Int64("median_score", medianScore),
+					zap.Float64("velocity", trend.ScoreVelocity),
+					zap.Float64("baseline", baseline),
 					zap.Int("response#status", res.StatusCode),
 					zap.String("response#reason", res.Reason),
 				)
@@ -320,9 +337,17 @@ func (tc *trendingConsumer) Consume(delivery rmq.Delivery) {
 					zap.String("post#id", post.ID),
 					zap.Int64("post#score", post.Score),
 					zap.String("device#token", watcher.Device.APNSToken),
-					zap.Int64("median_score", medianScore),
+					zap.Float64("velocity", trend.ScoreVelocity),
+					zap.Float64("comment_velocity", trend.CommentVelocity),
+					zap.Float64("baseline", baseline),
 				)
 			}
+
+			status := domain.WatcherHitDelivered
+			if err != nil || !res.Sent() {
+				status = domain.WatcherHitFailed
+			}
+			recordWatcherHit(ctx, tc.logger, tc.watcherRepo, watcher, post, status)
 		}
 	}
 
@@ -6,7 +6,7 @@ import (
 	"math/rand"
 	"os"
 	"strconv"
-	"strings"
+	"time"
 
 	"github.com/DataDog/datadog-go/statsd"
 	"github.com/adjust/rmq/v5"
@@ -19,6 +19,7 @@ import (
 	"go.uber.org/zap"
 
 	"github.com/christianselig/apollo-backend/internal/domain"
+	"github.com/christianselig/apollo-backend/internal/matcher"
 	"github.com/christianselig/apollo-backend/internal/reddit"
 	"github.com/christianselig/apollo-backend/internal/repository"
 )
@@ -41,9 +42,14 @@ type usersWorker struct {
 	deviceRepo  domain.DeviceRepository
 	userRepo    domain.UserRepository
 	watcherRepo domain.WatcherRepository
+
+	limiter *watcherLimiter
 }
 
-const userNotificationTitleFormat = "👨\u200d🚀 %s"
+const (
+	userNotificationTitleFormat           = "👨\u200d🚀 %s"
+	userCommentNotificationSubtitleFormat = "%s in \u201c%s\u201d"
+)
 
 func NewUsersWorker(ctx context.Context, logger *zap.Logger, tracer trace.Tracer, statsd *statsd.Client, db *pgxpool.Pool, redis *redis.Client, queue rmq.Connection, consumers int) Worker {
 	reddit := reddit.NewClient(
@@ -85,6 +91,8 @@ func NewUsersWorker(ctx context.Context, logger *zap.Logger, tracer trace.Tracer
 		repository.NewPostgresDevice(db),
 		repository.NewPostgresUser(db),
 		repository.NewPostgresWatcher(db),
+
+		newWatcherLimiter(logger, statsd, redis),
 	}
 }
 
@@ -176,6 +184,8 @@ func (uc *usersConsumer) Consume(delivery rmq.Delivery) {
 		return
 	}
 
+	uc.limiter.Summarize(ctx, watchers, uc.apnsProduction, uc.apnsSandbox)
+
 	// Load 25 newest posts
 	i := rand.Intn(len(watchers))
 	watcher := watchers[i]
@@ -218,23 +228,74 @@ func (uc *usersConsumer) Consume(delivery rmq.Delivery) {
 		}
 	}
 
-	posts, err := rac.UserPosts(ctx, user.Name)
-	if err != nil {
-		uc.logger.Error("failed to fetch user activity",
-			zap.Error(err),
-			zap.Int64("user#id", id),
-			zap.String("user#name", user.NormalizedName()),
-		)
-		return
+	var wantsPosts, wantsComments bool
+	for _, watcher := range watchers {
+		wantsPosts = wantsPosts || watcher.UserActivity.IncludesPosts()
+		wantsComments = wantsComments || watcher.UserActivity.IncludesComments()
+	}
+
+	posts := []*reddit.Thing{}
+
+	if wantsPosts {
+		ups, err := rac.UserPosts(ctx, user.Name)
+		if err != nil {
+			uc.logger.Error("failed to fetch user activity",
+				zap.Error(err),
+				zap.Int64("user#id", id),
+				zap.String("user#name", user.NormalizedName()),
+			)
+			return
+		}
+
+		posts = append(posts, ups.Children...)
 	}
 
-	for _, post := range posts.Children {
-		lowcaseSubreddit := strings.ToLower(post.Subreddit)
+	if wantsComments {
+		ucs, err := rac.UserComments(ctx, user.Name)
+		if err != nil {
+			uc.logger.Error("failed to fetch user comments",
+				zap.Error(err),
+				zap.Int64("user#id", id),
+				zap.String("user#name", user.NormalizedName()),
+			)
+			return
+		}
+
+		// Only look at comments newer than the last one we've processed
+		cursor := user.LastCommentAt
+		for _, comment := range ucs.Children {
+			if !comment.CreatedAt.After(cursor) || comment.ID == user.LastCommentID {
+				continue
+			}
+
+			posts = append(posts, comment)
+
+			if comment.CreatedAt.After(user.LastCommentAt) {
+				user.LastCommentID = comment.ID
+				user.LastCommentAt = comment.CreatedAt
+			}
+		}
+
+		// Move the cursor before notifying, we'd rather miss a comment than send it twice
+		if user.LastCommentAt.After(cursor) {
+			if err := uc.userRepo.UpdateCommentCursor(ctx, &user); err != nil {
+				uc.logger.Error("failed to update comment cursor",
+					zap.Error(err),
+					zap.Int64("user#id", id),
+					zap.String("user#name", user.NormalizedName()),
+				)
+				return
+			}
+		}
+	}
 
+	for _, post := range posts {
 		if post.SubredditType == "private" {
 			continue
 		}
 
+		isComment := post.Kind == "t1"
+
 		notifs := []domain.Watcher{}
 
 		for _, watcher := range watchers {
@@ -243,11 +304,43 @@ func (uc *usersConsumer) Consume(delivery rmq.Delivery) {
 				continue
 			}
 
-			if watcher.LastNotifiedAt.After(post.CreatedAt) {
+			// Nor on anything posted while it was snoozed
+			if watcher.SnoozedUntil.After(post.CreatedAt) {
 				continue
 			}
 
-			if watcher.Subreddit != "" && lowcaseSubreddit != watcher.Subreddit {
+			if isComment && !watcher.UserActivity.IncludesComments() {
+				continue
+			}
+
+			if !isComment && !watcher.UserActivity.IncludesPosts() {
+				continue
+			}
+
+			// Comments are deduplicated by the user's comment cursor instead
+			if !isComment && watcher.LastNotifiedAt.After(post.CreatedAt) {
+				continue
+			}
+
+			if !matcher.Match(&watcher, post).Matched {
+				continue
+			}
+
+			// Suppressed posts don't bump LastNotifiedAt, so remember them separately
+			lockKey := fmt.Sprintf("watcher:%d:%s", watcher.DeviceID, post.ID)
+			if suppressed, _ := uc.redis.Get(ctx, lockKey).Bool(); suppressed {
+				continue
+			}
+
+			if !uc.limiter.Allow(ctx, watcher) {
+				uc.logger.Debug("watcher rate limited, suppressing",
+					zap.Int64("user#id", id),
+					zap.String("user#name", user.NormalizedName()),
+					zap.Int64("watcher#id", watcher.ID),
+					zap.String("post#id", post.ID),
+				)
+				markNotified(ctx, uc.redis, watcher.DeviceID, lockKey, 24*time.Hour)
+				recordWatcherHit(ctx, uc.logger, uc.watcherRepo, watcher, post, domain.WatcherHitSuppressed)
 				continue
 			}
 
@@ -259,6 +352,9 @@ func (uc *usersConsumer) Consume(delivery rmq.Delivery) {
 		}
 
 		payload := payloadFromUserPost(post)
+		if isComment {
+			payload = payloadFromUserComment(post)
+		}
 
 		notification := &apns2.Notification{}
 		notification.Topic = "com.christianselig.Apollo"
@@ -308,6 +404,12 @@ func (uc *usersConsumer) Consume(delivery rmq.Delivery) {
 					zap.String("device#token", watcher.Device.APNSToken),
 				)
 			}
+
+			status := domain.WatcherHitDelivered
+			if err != nil || !res.Sent() {
+				status = domain.WatcherHitFailed
+			}
+			recordWatcherHit(ctx, uc.logger, uc.watcherRepo, watcher, post, status)
 		}
 	}
 
@@ -317,6 +419,38 @@ func (uc *usersConsumer) Consume(delivery rmq.Delivery) {
 	)
 }
 
+func payloadFromUserComment(comment *reddit.Thing) *payload.Payload {
+	body := comment.Body
+	if len(body) > 2000 {
+		body = comment.Body[:2000]
+	}
+
+	postTitle := comment.LinkTitle
+	if len(postTitle) > 75 {
+		postTitle = fmt.Sprintf("%s…", postTitle[0:75])
+	}
+
+	_, postID := reddit.SplitID(comment.LinkID)
+
+	payload := payload.
+		NewPayload().
+		AlertBody(body).
+		AlertSubtitle(fmt.Sprintf(userCommentNotificationSubtitleFormat, comment.Author, postTitle)).
+		AlertSummaryArg(comment.Author).
+		Category("user-watch-comment").
+		Custom("comment_id", comment.ID).
+		Custom("post_id", postID).
+		Custom("post_title", comment.LinkTitle).
+		Custom("subreddit", comment.Subreddit).
+		Custom("author", comment.Author).
+		Custom("context", comment.Permalink).
+		Custom("comment_age", comment.CreatedAt).
+		MutableContent().
+		Sound("traloop.wav")
+
+	return payload
+}
+
 func payloadFromUserPost(post *reddit.Thing) *payload.Payload {
 	payload := payload.
 		NewPayload().
@@ -0,0 +1,370 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DataDog/datadog-go/statsd"
+	"github.com/adjust/rmq/v5"
+	"github.com/go-redis/redis/v8"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/sideshow/apns2"
+	"github.com/sideshow/apns2/payload"
+	"github.com/sideshow/apns2/token"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+
+	"github.com/christianselig/apollo-backend/internal/domain"
+	"github.com/christianselig/apollo-backend/internal/reddit"
+	"github.com/christianselig/apollo-backend/internal/repository"
+)
+
+const watcherBrokenTitleFormat = "⚠️ “%s” Watcher"
+
+type watcherHealthWorker struct {
+	context.Context
+
+	logger *zap.Logger
+	tracer trace.Tracer
+	statsd *statsd.Client
+	db     *pgxpool.Pool
+	redis  *redis.Client
+	queue  rmq.Connection
+	reddit *reddit.Client
+	apns   *token.Token
+
+	consumers int
+
+	subredditRepo domain.SubredditRepository
+	userRepo      domain.UserRepository
+	watcherRepo   domain.WatcherRepository
+}
+
+func NewWatcherHealthWorker(ctx context.Context, logger *zap.Logger, tracer trace.Tracer, statsd *statsd.Client, db *pgxpool.Pool, redis *redis.Client, queue rmq.Connection, consumers int) Worker {
+	reddit := reddit.NewClient(
+		os.Getenv("REDDIT_CLIENT_ID"),
+		os.Getenv("REDDIT_CLIENT_SECRET"),
+		tracer,
+		statsd,
+		redis,
+		consumers,
+	)
+
+	var apns *token.Token
+	{
+		authKey, err := token.AuthKeyFromFile(os.Getenv("APPLE_KEY_PATH"))
+		if err != nil {
+			panic(err)
+		}
+
+		apns = &token.Token{
+			AuthKey: authKey,
+			KeyID:   os.Getenv("APPLE_KEY_ID"),
+			TeamID:  os.Getenv("APPLE_TEAM_ID"),
+		}
+	}
+
+	return &watcherHealthWorker{
+		ctx,
+		logger,
+		tracer,
+		statsd,
+		db,
+		redis,
+		queue,
+		reddit,
+		apns,
+		consumers,
+
+		repository.NewPostgresSubreddit(db),
+		repository.NewPostgresUser(db),
+		repository.NewPostgresWatcher(db),
+	}
+}
+
+func (hw *watcherHealthWorker) Start() error {
+	queue, err := hw.queue.OpenQueue("watcher-health")
+	if err != nil {
+		return err
+	}
+
+	hw.logger.Info("starting up watcher health worker", zap.Int("consumers", hw.consumers))
+
+	prefetchLimit := int64(hw.consumers * 2)
+
+	if err := queue.StartConsuming(prefetchLimit, pollDuration); err != nil {
+		return err
+	}
+
+	host, _ := os.Hostname()
+
+	for i := 0; i < hw.consumers; i++ {
+		name := fmt.Sprintf("consumer %s-%d", host, i)
+
+		consumer := NewWatcherHealthConsumer(hw, i)
+		if _, err := queue.AddConsumer(name, consumer); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (hw *watcherHealthWorker) Stop() {
+	<-hw.queue.StopAllConsuming() // wait for all Consume() calls to finish
+}
+
+type watcherHealthConsumer struct {
+	*watcherHealthWorker
+	tag int
+
+	apnsSandbox    *apns2.Client
+	apnsProduction *apns2.Client
+}
+
+func NewWatcherHealthConsumer(hw *watcherHealthWorker, tag int) *watcherHealthConsumer {
+	return &watcherHealthConsumer{
+		hw,
+		tag,
+		apns2.NewTokenClient(hw.apns),
+		apns2.NewTokenClient(hw.apns).Production(),
+	}
+}
+
+// Consume checks a single subreddit or user, with payloads looking like
+// "subreddit:<id>" or "user:<id>".
+func (hc *watcherHealthConsumer) Consume(delivery rmq.Delivery) {
+	ctx, cancel := context.WithCancel(hc)
+	defer cancel()
+
+	now := time.Now()
+	defer func() {
+		elapsed := time.Now().Sub(now).Milliseconds()
+		_ = hc.statsd.Histogram("apollo.consumer.runtime", float64(elapsed), []string{"queue:watcher-health"}, 0.1)
+	}()
+
+	kind, rawID, _ := strings.Cut(delivery.Payload(), ":")
+	id, err := strconv.ParseInt(rawID, 10, 64)
+	if err != nil || (kind != "subreddit" && kind != "user") {
+		hc.logger.Error("failed to parse watchee from payload", zap.Error(err), zap.String("payload", delivery.Payload()))
+		_ = delivery.Reject()
+		return
+	}
+
+	hc.logger.Debug("starting job", zap.String("watchee#kind", kind), zap.Int64("watchee#id", id))
+
+	defer func() { _ = delivery.Ack() }()
+
+	switch kind {
+	case "subreddit":
+		hc.checkSubreddit(ctx, id)
+	case "user":
+		hc.checkUser(ctx, id)
+	}
+}
+
+func (hc *watcherHealthConsumer) checkSubreddit(ctx context.Context, id int64) {
+	subreddit, err := hc.subredditRepo.GetByID(ctx, id)
+	if err != nil {
+		hc.logger.Error("failed to fetch subreddit from database", zap.Error(err), zap.Int64("subreddit#id", id))
+		return
+	}
+
+	// Snoozed and muted watchers still want to hear about it once they're back
+	watchers, err := hc.watcherRepo.GetAllBySubredditID(ctx, subreddit.ID)
+	if err != nil {
+		hc.logger.Error("failed to fetch watchers from database",
+			zap.Error(err),
+			zap.Int64("subreddit#id", id),
+			zap.String("subreddit#name", subreddit.NormalizedName()),
+		)
+		return
+	}
+
+	if len(watchers) == 0 {
+		hc.logger.Debug("no watchers for subreddit, bailing early",
+			zap.Int64("subreddit#id", id),
+			zap.String("subreddit#name", subreddit.NormalizedName()),
+		)
+		return
+	}
+
+	watcher := watchers[rand.Intn(len(watchers))]
+	rac := hc.reddit.NewAuthenticatedClient(watcher.Account.AccountID, watcher.Account.RefreshToken, watcher.Account.AccessToken)
+
+	reason := domain.WatcherNotBroken
+
+	srr, err := rac.SubredditAbout(ctx, subreddit.Name)
+	switch err {
+	case nil:
+		if !srr.Public {
+			reason = domain.WatcherSubredditPrivate
+		}
+	case reddit.ErrSubredditIsPrivate:
+		reason = domain.WatcherSubredditPrivate
+	case reddit.ErrSubredditIsQuarantined:
+		reason = domain.WatcherSubredditQuarantined
+	case reddit.ErrSubredditNotFound:
+		reason = domain.WatcherSubredditUnavailable
+	default:
+		hc.logger.Error("failed to fetch subreddit details",
+			zap.Error(err),
+			zap.Int64("subreddit#id", id),
+			zap.String("subreddit#name", subreddit.NormalizedName()),
+		)
+		return
+	}
+
+	changed, err := hc.watcherRepo.SetBrokenBySubredditID(ctx, subreddit.ID, reason)
+	if err != nil {
+		hc.logger.Error("failed to update watcher health",
+			zap.Error(err),
+			zap.Int64("subreddit#id", id),
+			zap.String("subreddit#name", subreddit.NormalizedName()),
+		)
+		return
+	}
+
+	hc.notifyBroken(changed, "r/"+subreddit.Name)
+}
+
+func (hc *watcherHealthConsumer) checkUser(ctx context.Context, id int64) {
+	user, err := hc.userRepo.GetByID(ctx, id)
+	if err != nil {
+		hc.logger.Error("failed to fetch user from database", zap.Error(err), zap.Int64("user#id", id))
+		return
+	}
+
+	watchers, err := hc.watcherRepo.GetAllByUserID(ctx, user.ID)
+	if err != nil {
+		hc.logger.Error("failed to fetch watchers from database",
+			zap.Error(err),
+			zap.Int64("user#id", id),
+			zap.String("user#name", user.NormalizedName()),
+		)
+		return
+	}
+
+	if len(watchers) == 0 {
+		hc.logger.Debug("no watchers for user, bailing early",
+			zap.Int64("user#id", id),
+			zap.String("user#name", user.NormalizedName()),
+		)
+		return
+	}
+
+	watcher := watchers[rand.Intn(len(watchers))]
+	rac := hc.reddit.NewAuthenticatedClient(watcher.Account.AccountID, watcher.Account.RefreshToken, watcher.Account.AccessToken)
+
+	reason := domain.WatcherNotBroken
+
+	ru, err := rac.UserAbout(ctx, user.Name)
+	switch {
+	case err == reddit.ErrUserNotFound:
+		reason = domain.WatcherUserUnavailable
+	case err != nil:
+		hc.logger.Error("failed to fetch user details",
+			zap.Error(err),
+			zap.Int64("user#id", id),
+			zap.String("user#name", user.NormalizedName()),
+		)
+		return
+	case ru.Suspended:
+		reason = domain.WatcherUserSuspended
+	case !ru.AcceptFollowers:
+		reason = domain.WatcherUserFollowersDisabled
+	}
+
+	changed, err := hc.watcherRepo.SetBrokenByUserID(ctx, user.ID, reason)
+	if err != nil {
+		hc.logger.Error("failed to update watcher health",
+			zap.Error(err),
+			zap.Int64("user#id", id),
+			zap.String("user#name", user.NormalizedName()),
+		)
+		return
+	}
+
+	hc.notifyBroken(changed, "u/"+user.Name)
+}
+
+// notifyBroken lets devices know once that their watcher stopped working, if they asked to.
+func (hc *watcherHealthConsumer) notifyBroken(watchers []domain.Watcher, watchee string) {
+	for _, watcher := range watchers {
+		tags := []string{fmt.Sprintf("reason:%s", watcher.BrokenReason)}
+		if watcher.Broken() {
+			_ = hc.statsd.Incr("apollo.watcher.broken", tags, 1)
+		} else {
+			_ = hc.statsd.Incr("apollo.watcher.recovered", []string{}, 1)
+		}
+
+		hc.logger.Info("watcher health changed",
+			zap.Int64("watcher#id", watcher.ID),
+			zap.String("watcher#reason", watcher.BrokenReason.String()),
+			zap.String("watchee", watchee),
+		)
+
+		if !watcher.Broken() || !watcher.NotifyOnBroken {
+			continue
+		}
+
+		notification := &apns2.Notification{}
+		notification.Topic = "com.christianselig.Apollo"
+		notification.DeviceToken = watcher.Device.APNSToken
+		notification.Payload = payload.
+			NewPayload().
+			AlertTitle(fmt.Sprintf(watcherBrokenTitleFormat, watcher.Label)).
+			AlertBody(watcherBrokenMessage(watcher.BrokenReason, watchee)).
+			Category("watcher-broken").
+			Custom("watcher_id", watcher.ID).
+			Custom("watcher_type", watcher.Type.String()).
+			Custom("reason", watcher.BrokenReason.String()).
+			Sound("traloop.wav")
+
+		client := hc.apnsProduction
+		if watcher.Device.Sandbox {
+			client = hc.apnsSandbox
+		}
+
+		res, err := client.Push(notification)
+		if err != nil || !res.Sent() {
+			_ = hc.statsd.Incr("apns.notification.errors", []string{}, 1)
+
+			fields := []zap.Field{
+				zap.Error(err),
+				zap.Int64("watcher#id", watcher.ID),
+				zap.String("device#token", watcher.Device.APNSToken),
+			}
+			if res != nil {
+				fields = append(fields, zap.Int("response#status", res.StatusCode), zap.String("response#reason", res.Reason))
+			}
+			hc.logger.Error("failed to send broken watcher notification", fields...)
+		} else {
+			_ = hc.statsd.Incr("apns.notification.sent", []string{}, 1)
+		}
+	}
+}
+
+func watcherBrokenMessage(reason domain.WatcherBrokenReason, watchee string) string {
+	switch reason {
+	case domain.WatcherSubredditPrivate:
+		return fmt.Sprintf("%s went private, so this watcher stopped working.", watchee)
+	case domain.WatcherSubredditQuarantined:
+		return fmt.Sprintf("%s was quarantined, so this watcher stopped working.", watchee)
+	case domain.WatcherSubredditUnavailable:
+		return fmt.Sprintf("%s was banned or removed, so this watcher stopped working.", watchee)
+	case domain.WatcherUserSuspended:
+		return fmt.Sprintf("%s was suspended, so this watcher stopped working.", watchee)
+	case domain.WatcherUserUnavailable:
+		return fmt.Sprintf("%s no longer exists, so this watcher stopped working.", watchee)
+	case domain.WatcherUserFollowersDisabled:
+		return fmt.Sprintf("%s no longer allows followers, so this watcher stopped working.", watchee)
+	}
+
+	return "This watcher stopped working."
+}
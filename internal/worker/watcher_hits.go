@@ -0,0 +1,38 @@
+package worker
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/christianselig/apollo-backend/internal/domain"
+	"github.com/christianselig/apollo-backend/internal/reddit"
+)
+
+// recordWatcherHit adds a post to a watcher's hit history. It's best effort,
+// failing to record a hit never holds up notifying about it.
+func recordWatcherHit(ctx context.Context, logger *zap.Logger, repo domain.WatcherRepository, watcher domain.Watcher, post *reddit.Thing, status domain.WatcherHitStatus) {
+	title := post.Title
+	if post.Kind == "t1" {
+		title = post.LinkTitle
+	}
+
+	hit := &domain.WatcherHit{
+		WatcherID: watcher.ID,
+		PostID:    post.ID,
+		Title:     title,
+		Subreddit: post.Subreddit,
+		Author:    post.Author,
+		MatchedAt: time.Now(),
+		Status:    status,
+	}
+
+	if err := repo.CreateHit(ctx, hit); err != nil {
+		logger.Error("failed to record watcher hit",
+			zap.Error(err),
+			zap.Int64("watcher#id", watcher.ID),
+			zap.String("post#id", post.ID),
+		)
+	}
+}
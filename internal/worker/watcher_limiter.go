@@ -0,0 +1,190 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/DataDog/datadog-go/statsd"
+	"github.com/dustin/go-humanize/english"
+	"github.com/go-redis/redis/v8"
+	"github.com/sideshow/apns2"
+	"github.com/sideshow/apns2/payload"
+	"go.uber.org/zap"
+
+	"github.com/christianselig/apollo-backend/internal/domain"
+)
+
+const (
+	suppressedNotificationBodyFormat = "%s since your last notification"
+
+	// How long we hold on to suppressed hits waiting for a summary
+	suppressedTTL = 7 * 24 * time.Hour
+)
+
+// Checks the cooldown and window counter, and either records a notification
+// or counts the hit as suppressed. Runs as a script so concurrent consumers
+// can't both squeeze into the last slot of a window.
+var allowNotificationScript = redis.NewScript(`
+local function suppress()
+	redis.call("INCR", KEYS[3])
+	redis.call("EXPIRE", KEYS[3], ARGV[4])
+	return 0
+end
+
+if redis.call("EXISTS", KEYS[2]) == 1 then
+	return suppress()
+end
+
+local max = tonumber(ARGV[1])
+if max > 0 then
+	local count = tonumber(redis.call("GET", KEYS[1]) or "0")
+	if count >= max then
+		return suppress()
+	end
+
+	if redis.call("INCR", KEYS[1]) == 1 then
+		redis.call("EXPIRE", KEYS[1], ARGV[2])
+	end
+end
+
+if tonumber(ARGV[3]) > 0 then
+	redis.call("SET", KEYS[2], 1, "EX", ARGV[3])
+end
+
+return 1
+`)
+
+// Hands back the suppressed count once both the window and cooldown are over.
+var takeSuppressedScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 1 or redis.call("EXISTS", KEYS[2]) == 1 then
+	return 0
+end
+
+local count = tonumber(redis.call("GET", KEYS[3]) or "0")
+redis.call("DEL", KEYS[3])
+return count
+`)
+
+type watcherLimiter struct {
+	logger *zap.Logger
+	statsd *statsd.Client
+	redis  *redis.Client
+}
+
+func newWatcherLimiter(logger *zap.Logger, statsd *statsd.Client, redis *redis.Client) *watcherLimiter {
+	return &watcherLimiter{logger, statsd, redis}
+}
+
+func (wl *watcherLimiter) keys(watcher domain.Watcher) []string {
+	return []string{
+		fmt.Sprintf("watcher:%d:ratelimit", watcher.ID),
+		fmt.Sprintf("watcher:%d:cooldown", watcher.ID),
+		fmt.Sprintf("watcher:%d:suppressed", watcher.ID),
+	}
+}
+
+// Allow reports whether a hit for the watcher may be notified. Redis errors
+// fail open, we'd rather send one notification too many than lose one.
+func (wl *watcherLimiter) Allow(ctx context.Context, watcher domain.Watcher) bool {
+	if !watcher.RateLimited() {
+		return true
+	}
+
+	allowed, err := allowNotificationScript.Run(ctx, wl.redis, wl.keys(watcher),
+		watcher.MaxNotifications,
+		int64(watcher.NotificationWindow.Seconds()),
+		int64(watcher.Cooldown.Seconds()),
+		int64(suppressedTTL.Seconds()),
+	).Int64()
+	if err != nil {
+		wl.logger.Error("failed to check watcher rate limit", zap.Error(err), zap.Int64("watcher#id", watcher.ID))
+		return true
+	}
+
+	if allowed == 0 {
+		_ = wl.statsd.Incr("apollo.watcher.suppressed", []string{fmt.Sprintf("type:%s", watcher.Type)}, 1)
+		return false
+	}
+
+	return true
+}
+
+// Summarize sends a single "N more matches" notification to every watcher
+// that opted in and had hits suppressed during a window that has since reset.
+func (wl *watcherLimiter) Summarize(ctx context.Context, watchers []domain.Watcher, production, sandbox *apns2.Client) {
+	for _, watcher := range watchers {
+		if !watcher.SummarizeSuppressed || !watcher.RateLimited() {
+			continue
+		}
+
+		count, err := takeSuppressedScript.Run(ctx, wl.redis, wl.keys(watcher)).Int64()
+		if err != nil {
+			wl.logger.Error("failed to fetch suppressed watcher hits", zap.Error(err), zap.Int64("watcher#id", watcher.ID))
+			continue
+		}
+
+		if count == 0 {
+			continue
+		}
+
+		notification := &apns2.Notification{}
+		notification.Topic = "com.christianselig.Apollo"
+		notification.DeviceToken = watcher.Device.APNSToken
+		notification.Payload = payloadFromSuppressedMatches(watcher, count)
+
+		client := production
+		if watcher.Device.Sandbox {
+			client = sandbox
+		}
+
+		res, err := client.Push(notification)
+		if err != nil || !res.Sent() {
+			_ = wl.statsd.Incr("apns.notification.errors", []string{}, 1)
+			fields := []zap.Field{
+				zap.Error(err),
+				zap.Int64("watcher#id", watcher.ID),
+				zap.String("apns", watcher.Device.APNSToken),
+			}
+			if res != nil {
+				fields = append(fields, zap.Int("response#status", res.StatusCode), zap.String("response#reason", res.Reason))
+			}
+			wl.logger.Error("failed to send suppressed summary notification", fields...)
+		} else {
+			_ = wl.statsd.Incr("apns.notification.sent", []string{}, 1)
+			wl.logger.Info("sent suppressed summary notification",
+				zap.Int64("watcher#id", watcher.ID),
+				zap.Int64("count", count),
+				zap.String("device#token", watcher.Device.APNSToken),
+			)
+		}
+	}
+}
+
+func payloadFromSuppressedMatches(watcher domain.Watcher, count int64) *payload.Payload {
+	var title, category string
+
+	switch watcher.Type {
+	case domain.UserWatcher:
+		title = fmt.Sprintf(userNotificationTitleFormat, watcher.Label)
+		category = "user-watch"
+	case domain.TrendingWatcher:
+		title = fmt.Sprintf(trendingNotificationTitleFormat, watcher.WatcheeLabel)
+		category = "trending-post"
+	default:
+		title = fmt.Sprintf(subredditNotificationTitleFormat, watcher.Label)
+		category = "subreddit-watcher"
+	}
+
+	body := fmt.Sprintf(suppressedNotificationBodyFormat, english.Plural(int(count), "more match", "more matches"))
+
+	return payload.
+		NewPayload().
+		AlertTitle(title).
+		AlertBody(body).
+		Category(category).
+		Custom("watcher_id", watcher.ID).
+		Custom("suppressed_count", count).
+		ThreadID(category).
+		Sound("traloop.wav")
+}
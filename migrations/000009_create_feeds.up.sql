@@ -0,0 +1,7 @@
+-- Table Definition ----------------------------------------------
+
+CREATE TABLE feeds (
+    id SERIAL PRIMARY KEY,
+    path character varying(96) DEFAULT ''::character varying UNIQUE,
+    next_check_at timestamp without time zone
+);
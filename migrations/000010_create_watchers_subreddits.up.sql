@@ -0,0 +1,12 @@
+-- Table Definition ----------------------------------------------
+
+CREATE TABLE watchers_subreddits (
+    id SERIAL PRIMARY KEY,
+    watcher_id integer REFERENCES watchers(id) ON DELETE CASCADE,
+    subreddit_id integer REFERENCES subreddits(id) ON DELETE CASCADE
+);
+
+-- Indices -------------------------------------------------------
+
+CREATE UNIQUE INDEX watchers_subreddits_watcher_id_subreddit_id_idx ON watchers_subreddits(watcher_id int4_ops,subreddit_id int4_ops);
+CREATE INDEX watchers_subreddits_subreddit_id_idx ON watchers_subreddits(subreddit_id int4_ops);
@@ -0,0 +1,5 @@
+ALTER TABLE watchers
+    DROP COLUMN IF EXISTS max_notifications,
+    DROP COLUMN IF EXISTS notification_window,
+    DROP COLUMN IF EXISTS cooldown,
+    DROP COLUMN IF EXISTS summarize_suppressed;
@@ -0,0 +1,5 @@
+ALTER TABLE watchers
+    ADD COLUMN max_notifications integer DEFAULT 0,
+    ADD COLUMN notification_window integer DEFAULT 0,
+    ADD COLUMN cooldown integer DEFAULT 0,
+    ADD COLUMN summarize_suppressed boolean DEFAULT false;
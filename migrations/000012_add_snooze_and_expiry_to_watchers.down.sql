@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS watchers_expires_at_idx;
+
+ALTER TABLE watchers
+    DROP COLUMN IF EXISTS snoozed_until,
+    DROP COLUMN IF EXISTS expires_at,
+    DROP COLUMN IF EXISTS notify_on_expiry;
@@ -0,0 +1,6 @@
+ALTER TABLE watchers
+    ADD COLUMN snoozed_until timestamp without time zone,
+    ADD COLUMN expires_at timestamp without time zone,
+    ADD COLUMN notify_on_expiry boolean DEFAULT false;
+
+CREATE INDEX watchers_expires_at_idx ON watchers(expires_at timestamp_ops) WHERE expires_at IS NOT NULL;
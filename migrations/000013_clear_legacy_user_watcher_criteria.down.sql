@@ -0,0 +1,2 @@
+-- The cleared criteria were never used, so there's nothing to restore
+SELECT 1;
@@ -0,0 +1,6 @@
+-- User watchers used to only look at the subreddit, whatever other criteria
+-- got stored with them. Now that every criterion applies, clear those so
+-- existing watchers keep notifying the same way.
+UPDATE watchers
+    SET upvotes = 0, keyword = '', flair = '', domain = '', author = ''
+    WHERE type = 1;
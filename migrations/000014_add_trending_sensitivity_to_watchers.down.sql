@@ -0,0 +1,2 @@
+ALTER TABLE watchers
+    DROP COLUMN IF EXISTS trending_sensitivity;
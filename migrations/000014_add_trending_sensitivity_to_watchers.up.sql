@@ -0,0 +1,2 @@
+ALTER TABLE watchers
+    ADD COLUMN trending_sensitivity integer DEFAULT 0;
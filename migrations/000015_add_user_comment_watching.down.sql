@@ -0,0 +1,6 @@
+ALTER TABLE users
+    DROP COLUMN IF EXISTS last_comment_id,
+    DROP COLUMN IF EXISTS last_comment_at;
+
+ALTER TABLE watchers
+    DROP COLUMN IF EXISTS user_activity;
@@ -0,0 +1,6 @@
+ALTER TABLE watchers
+    ADD COLUMN user_activity integer DEFAULT 0;
+
+ALTER TABLE users
+    ADD COLUMN last_comment_id character varying(32) DEFAULT ''::character varying,
+    ADD COLUMN last_comment_at timestamp without time zone;
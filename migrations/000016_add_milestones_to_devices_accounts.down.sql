@@ -0,0 +1,6 @@
+ALTER TABLE accounts
+    DROP COLUMN IF EXISTS next_milestone_check_at;
+
+ALTER TABLE devices_accounts
+    DROP COLUMN IF EXISTS upvote_milestones,
+    DROP COLUMN IF EXISTS comment_milestones;
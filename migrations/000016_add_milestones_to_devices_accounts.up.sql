@@ -0,0 +1,6 @@
+ALTER TABLE devices_accounts
+    ADD COLUMN upvote_milestones integer[] DEFAULT '{}'::integer[],
+    ADD COLUMN comment_milestones integer[] DEFAULT '{}'::integer[];
+
+ALTER TABLE accounts
+    ADD COLUMN next_milestone_check_at timestamp without time zone;
@@ -0,0 +1,3 @@
+ALTER TABLE subreddits
+    DROP COLUMN IF EXISTS last_post_id,
+    DROP COLUMN IF EXISTS last_post_at;
@@ -0,0 +1,3 @@
+ALTER TABLE subreddits
+    ADD COLUMN last_post_id character varying(32) DEFAULT ''::character varying,
+    ADD COLUMN last_post_at timestamp without time zone;
@@ -0,0 +1,17 @@
+-- Table Definition ----------------------------------------------
+
+CREATE TABLE watcher_hits (
+    id SERIAL PRIMARY KEY,
+    watcher_id integer REFERENCES watchers(id) ON DELETE CASCADE,
+    post_id character varying(32) DEFAULT ''::character varying,
+    title character varying(300) DEFAULT ''::character varying,
+    subreddit character varying(32) DEFAULT ''::character varying,
+    author character varying(32) DEFAULT ''::character varying,
+    matched_at timestamp without time zone,
+    status integer DEFAULT 0
+);
+
+-- Indices -------------------------------------------------------
+
+CREATE INDEX watcher_hits_watcher_id_id_idx ON watcher_hits(watcher_id int4_ops,id int4_ops);
+CREATE INDEX watcher_hits_matched_at_idx ON watcher_hits(matched_at timestamp_ops);
@@ -0,0 +1,6 @@
+ALTER TABLE watchers
+    DROP COLUMN IF EXISTS media_kind,
+    DROP COLUMN IF EXISTS post_kind,
+    DROP COLUMN IF EXISTS nsfw,
+    DROP COLUMN IF EXISTS min_comments,
+    DROP COLUMN IF EXISTS max_age;
@@ -0,0 +1,6 @@
+ALTER TABLE watchers
+    ADD COLUMN media_kind integer DEFAULT 0,
+    ADD COLUMN post_kind integer DEFAULT 0,
+    ADD COLUMN nsfw integer DEFAULT 0,
+    ADD COLUMN min_comments integer DEFAULT 0,
+    ADD COLUMN max_age integer DEFAULT 0;
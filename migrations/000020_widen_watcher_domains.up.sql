@@ -0,0 +1,2 @@
+ALTER TABLE watchers
+    ALTER COLUMN domain TYPE character varying(128);
@@ -0,0 +1,11 @@
+ALTER TABLE watchers
+    DROP COLUMN IF EXISTS broken_reason,
+    DROP COLUMN IF EXISTS broken_at,
+    DROP COLUMN IF EXISTS notify_on_broken;
+
+ALTER TABLE subreddits
+    DROP COLUMN IF EXISTS broken_reason,
+    DROP COLUMN IF EXISTS next_health_check_at;
+
+ALTER TABLE users
+    DROP COLUMN IF EXISTS next_health_check_at;
@@ -0,0 +1,11 @@
+ALTER TABLE watchers
+    ADD COLUMN broken_reason integer DEFAULT 0,
+    ADD COLUMN broken_at timestamp without time zone,
+    ADD COLUMN notify_on_broken boolean DEFAULT false;
+
+ALTER TABLE subreddits
+    ADD COLUMN broken_reason integer DEFAULT 0,
+    ADD COLUMN next_health_check_at timestamp without time zone;
+
+ALTER TABLE users
+    ADD COLUMN next_health_check_at timestamp without time zone;
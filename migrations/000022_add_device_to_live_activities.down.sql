@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS live_activities_device_id_idx;
+
+ALTER TABLE live_activities
+    DROP COLUMN IF EXISTS device_id;
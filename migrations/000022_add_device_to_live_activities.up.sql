@@ -0,0 +1,4 @@
+ALTER TABLE live_activities
+    ADD COLUMN device_id integer REFERENCES devices(id) ON DELETE CASCADE;
+
+CREATE INDEX live_activities_device_id_idx ON live_activities(device_id int4_ops);
@@ -0,0 +1,2 @@
+ALTER TABLE live_activities
+    DROP COLUMN IF EXISTS authors;
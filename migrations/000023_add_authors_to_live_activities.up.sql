@@ -0,0 +1,2 @@
+ALTER TABLE live_activities
+    ADD COLUMN authors integer DEFAULT 0;
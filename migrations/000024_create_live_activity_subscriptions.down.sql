@@ -0,0 +1,12 @@
+DROP TABLE IF EXISTS live_activity_subscriptions;
+
+DROP INDEX IF EXISTS live_activities_pending_idx;
+
+DELETE FROM live_activities
+    WHERE pending;
+
+ALTER TABLE live_activities
+    DROP COLUMN IF EXISTS pending;
+
+ALTER TABLE devices
+    DROP COLUMN IF EXISTS push_to_start_token;
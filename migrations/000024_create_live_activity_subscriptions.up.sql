@@ -0,0 +1,25 @@
+ALTER TABLE devices
+    ADD COLUMN push_to_start_token character varying(200) DEFAULT ''::character varying;
+
+ALTER TABLE live_activities
+    ADD COLUMN pending boolean DEFAULT false;
+
+-- Pending activities are known by their device and thread until they're claimed
+CREATE UNIQUE INDEX live_activities_pending_idx ON live_activities(device_id, thread_id) WHERE pending;
+
+-- Table Definition ----------------------------------------------
+
+CREATE TABLE live_activity_subscriptions (
+    id SERIAL PRIMARY KEY,
+    created_at timestamp without time zone,
+    device_id integer REFERENCES devices(id) ON DELETE CASCADE,
+    account_id integer REFERENCES accounts(id) ON DELETE CASCADE,
+    subreddit_id integer REFERENCES subreddits(id) ON DELETE CASCADE,
+    title_pattern character varying(100) DEFAULT ''::character varying,
+    authors integer DEFAULT 0
+);
+
+-- Indices -------------------------------------------------------
+
+CREATE INDEX live_activity_subscriptions_subreddit_id_idx ON live_activity_subscriptions(subreddit_id int4_ops);
+CREATE INDEX live_activity_subscriptions_device_id_idx ON live_activity_subscriptions(device_id int4_ops);
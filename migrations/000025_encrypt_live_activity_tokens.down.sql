@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS live_activities_expires_at_idx;
+
+-- Activities only last so long, so anything still encrypted can go
+DELETE FROM live_activities WHERE length(access_token) > 64 OR length(refresh_token) > 64;
+
+ALTER TABLE live_activities
+    ALTER COLUMN access_token TYPE character varying(64),
+    ALTER COLUMN refresh_token TYPE character varying(64);
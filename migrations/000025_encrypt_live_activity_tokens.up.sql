@@ -0,0 +1,6 @@
+-- Encrypted tokens no longer fit in 64 characters
+ALTER TABLE live_activities
+    ALTER COLUMN access_token TYPE text,
+    ALTER COLUMN refresh_token TYPE text;
+
+CREATE INDEX live_activities_expires_at_idx ON live_activities(expires_at timestamp_ops);
@@ -0,0 +1,2 @@
+ALTER TABLE devices
+    ADD COLUMN secret text DEFAULT ''::text;
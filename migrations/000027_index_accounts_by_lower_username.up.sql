@@ -0,0 +1,2 @@
+-- Usernames are looked up case insensitively, like from the admin API
+CREATE INDEX accounts_lower_username_idx ON accounts(LOWER(username) text_ops);